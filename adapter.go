@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"net"
+)
+
+// ListenerService is a Service that listens on a network address, such as the ones created by NewHTTPService,
+// NewTCPService and NewGRPCService.
+type ListenerService interface {
+	Service
+
+	// Addr returns the address the service is listening on. It returns nil if the service is not listening.
+	Addr() net.Addr
+}
+
+// runServer runs a blocking serve function on behalf of a listening service. It calls Running() once the serve
+// function has been started, and when the lifecycle requests a stop it calls Stopping() and shutdown with the shutdown
+// context. If the shutdown context expires before shutdown returns, forceClose is called to abort the remaining work.
+// It returns the result of the serve function.
+func runServer(
+	lifecycle Lifecycle,
+	serve func() error,
+	shutdown func(shutdownContext context.Context) error,
+	forceClose func(),
+) error {
+	serveResult := make(chan error, 1)
+	go func() {
+		serveResult <- serve()
+	}()
+	lifecycle.Running()
+
+	select {
+	case err := <-serveResult:
+		return err
+	case <-lifecycle.Context().Done():
+	}
+
+	shutdownContext := lifecycle.Stopping()
+	shutdownResult := make(chan error, 1)
+	go func() {
+		shutdownResult <- shutdown(shutdownContext)
+	}()
+	select {
+	case <-shutdownResult:
+	case <-shutdownContext.Done():
+		forceClose()
+		<-shutdownResult
+	}
+	return <-serveResult
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// NewFuncService creates a service from a function. The service enters the "running" state as soon as the function is
+// called and the "stopping" state when the context passed to the function is canceled. The function should return
+// when the context is canceled; returning context.Canceled at that point is not treated as an error. If the function
+// returns on its own the service stops, or crashes if the function returned an error.
+func NewFuncService(name string, run func(ctx context.Context) error) Service {
+	if run == nil {
+		panic("bug: no function passed to NewFuncService")
+	}
+	return &funcService{
+		name: name,
+		run:  run,
+	}
+}
+
+type funcService struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+func (f *funcService) String() string {
+	return f.name
+}
+
+func (f *funcService) RunWithLifecycle(lifecycle Lifecycle) error {
+	ctx := lifecycle.Context()
+	lifecycle.Running()
+
+	// The function runs in this goroutine so the lifecycle can recover its panics. Stopping() is called from a
+	// watcher, which must finish before returning so the "stopping" state is never entered after the service exited.
+	finished := make(chan struct{})
+	watcherDone := make(chan struct{})
+	stoppingCalled := false
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			lifecycle.Stopping()
+			stoppingCalled = true
+		case <-finished:
+		}
+	}()
+	defer func() {
+		close(finished)
+		<-watcherDone
+		if !stoppingCalled && ctx.Err() != nil {
+			lifecycle.Stopping()
+		}
+	}()
+
+	err := f.run(ctx)
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
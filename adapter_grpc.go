@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// GracefulServer is a server that serves on a listener and can be stopped gracefully or immediately. It matches the
+// methods of *grpc.Server, so gRPC servers can be passed to NewGRPCService directly.
+type GracefulServer interface {
+	// Serve accepts connections on the listener and blocks until the server is stopped.
+	Serve(listener net.Listener) error
+	// GracefulStop stops accepting connections and waits for the pending requests to finish.
+	GracefulStop()
+	// Stop closes all connections and aborts the pending requests.
+	Stop()
+}
+
+// NewGRPCService creates a service that runs a gRPC-style server on the specified TCP address. The service enters the
+// "running" state once it is listening.
+//
+// When stopped, the server is stopped with GracefulStop(). If the shutdown context expires first, Stop() is called to
+// abort the remaining requests. Whether the service can be run again depends on the server; gRPC servers cannot be
+// restarted.
+func NewGRPCService(address string, server GracefulServer) ListenerService {
+	if server == nil {
+		panic("bug: no server passed to NewGRPCService")
+	}
+	return &grpcService{
+		address: address,
+		server:  server,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+type grpcService struct {
+	address  string
+	server   GracefulServer
+	mutex    *sync.Mutex
+	listener net.Listener
+}
+
+func (g *grpcService) String() string {
+	return "gRPC server on " + g.address
+}
+
+func (g *grpcService) Addr() net.Addr {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Addr()
+}
+
+func (g *grpcService) RunWithLifecycle(lifecycle Lifecycle) error {
+	listener, err := net.Listen("tcp", g.address)
+	if err != nil {
+		return err
+	}
+	g.mutex.Lock()
+	g.listener = listener
+	g.mutex.Unlock()
+	defer func() {
+		g.mutex.Lock()
+		g.listener = nil
+		g.mutex.Unlock()
+	}()
+
+	return runServer(
+		lifecycle,
+		func() error {
+			return g.server.Serve(listener)
+		},
+		func(_ context.Context) error {
+			g.server.GracefulStop()
+			return nil
+		},
+		g.server.Stop,
+	)
+}
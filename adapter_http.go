@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// NewHTTPService creates a service that runs an HTTP server. The service listens on server.Addr, or ":http" if it is
+// empty, and enters the "running" state once it is listening. If server.TLSConfig is set, the server serves HTTPS using
+// the certificates in the TLS configuration.
+//
+// When stopped, the server is shut down gracefully using Shutdown() with the shutdown context. If the shutdown context
+// expires first, the remaining connections are closed. Since net/http does not support restarting a server that has
+// been shut down, the service cannot be run again.
+func NewHTTPService(server *http.Server) ListenerService {
+	if server == nil {
+		panic("bug: no HTTP server passed to NewHTTPService")
+	}
+	return &httpService{
+		server: server,
+		mutex:  &sync.Mutex{},
+	}
+}
+
+type httpService struct {
+	server   *http.Server
+	mutex    *sync.Mutex
+	listener net.Listener
+}
+
+func (h *httpService) String() string {
+	return "HTTP server on " + h.address()
+}
+
+func (h *httpService) address() string {
+	if h.server.Addr == "" {
+		return ":http"
+	}
+	return h.server.Addr
+}
+
+func (h *httpService) Addr() net.Addr {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.listener == nil {
+		return nil
+	}
+	return h.listener.Addr()
+}
+
+func (h *httpService) RunWithLifecycle(lifecycle Lifecycle) error {
+	listener, err := net.Listen("tcp", h.address())
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	h.listener = listener
+	h.mutex.Unlock()
+	defer func() {
+		h.mutex.Lock()
+		h.listener = nil
+		h.mutex.Unlock()
+	}()
+
+	err = runServer(
+		lifecycle,
+		func() error {
+			if h.server.TLSConfig != nil {
+				return h.server.ServeTLS(listener, "", "")
+			}
+			return h.server.Serve(listener)
+		},
+		h.server.Shutdown,
+		func() {
+			_ = h.server.Close()
+		},
+	)
+	if errors.Is(err, http.ErrServerClosed) && lifecycle.ShouldStop() {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectionHandler handles a single connection accepted by a TCP service. The context is canceled when the service
+// starts shutting down, giving the handler a chance to finish the conversation gracefully. Connections still open when
+// the shutdown context expires are closed by the service. The connection is also closed after the handler returns.
+type ConnectionHandler func(ctx context.Context, conn net.Conn)
+
+// TCPService is a ListenerService that accepts TCP connections and tracks them until they are closed.
+type TCPService interface {
+	ListenerService
+
+	// Connections returns the number of connections currently being handled.
+	Connections() int
+}
+
+// NewTCPService creates a service that listens on the specified TCP address and runs the handler for each accepted
+// connection in its own goroutine. The service enters the "running" state once it is listening.
+//
+// When stopped, the service stops accepting connections and waits for the running handlers to return. If the shutdown
+// context expires first, the remaining connections are closed.
+func NewTCPService(address string, handler ConnectionHandler) TCPService {
+	if handler == nil {
+		panic("bug: no connection handler passed to NewTCPService")
+	}
+	return &tcpService{
+		address: address,
+		handler: handler,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+type tcpService struct {
+	address  string
+	handler  ConnectionHandler
+	mutex    *sync.Mutex
+	listener net.Listener
+	tracker  *connectionTracker
+}
+
+func (t *tcpService) String() string {
+	return "TCP server on " + t.address
+}
+
+func (t *tcpService) Addr() net.Addr {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.listener == nil {
+		return nil
+	}
+	return t.listener.Addr()
+}
+
+func (t *tcpService) Connections() int {
+	t.mutex.Lock()
+	tracker := t.tracker
+	t.mutex.Unlock()
+	if tracker == nil {
+		return 0
+	}
+	return tracker.count()
+}
+
+func (t *tcpService) RunWithLifecycle(lifecycle Lifecycle) error {
+	listener, err := net.Listen("tcp", t.address)
+	if err != nil {
+		return err
+	}
+	// Each run tracks its own connections so handlers of a previous run cannot delay the next one.
+	tracker := newConnectionTracker()
+	t.mutex.Lock()
+	t.listener = listener
+	t.tracker = tracker
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		t.listener = nil
+		t.mutex.Unlock()
+	}()
+
+	err = runServer(
+		lifecycle,
+		func() error {
+			return t.acceptLoop(lifecycle, listener, tracker)
+		},
+		func(shutdownContext context.Context) error {
+			_ = listener.Close()
+			tracker.close()
+			return tracker.wait(shutdownContext)
+		},
+		tracker.closeAll,
+	)
+	// A failed accept loop must not leave handlers behind either.
+	_ = listener.Close()
+	tracker.close()
+	tracker.closeAll()
+	<-tracker.drained
+	return err
+}
+
+// acceptLoop accepts connections until the listener is closed. Temporary errors, such as running out of file
+// descriptors, are retried with a backoff.
+func (t *tcpService) acceptLoop(lifecycle Lifecycle, listener net.Listener, tracker *connectionTracker) error {
+	ctx := lifecycle.Context()
+	clock := lifecycleClock(lifecycle)
+	var backoff time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				backoff = nextAcceptBackoff(backoff)
+				timer := clock.NewTimer(backoff)
+				select {
+				case <-timer.C():
+					continue
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				}
+			}
+			return err
+		}
+		backoff = 0
+		if !tracker.add(conn) {
+			_ = conn.Close()
+			continue
+		}
+		// Connections are also tracked as work units so they show up in the active work count of the lifecycle.
+		unit := lifecycle.Track(func(_ error) {
+			_ = conn.Close()
+		})
+		go func() {
+			defer func() {
+				tracker.remove(conn)
+				unit.Finish()
+			}()
+			t.handler(ctx, conn)
+		}()
+	}
+}
+
+// nextAcceptBackoff doubles the accept backoff, starting at 5ms and capped at one second like net/http.
+func nextAcceptBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return 5 * time.Millisecond
+	}
+	backoff *= 2
+	if backoff > time.Second {
+		return time.Second
+	}
+	return backoff
+}
+
+// connectionTracker keeps track of the open connections of a single run of a TCP service.
+type connectionTracker struct {
+	mutex       *sync.Mutex
+	connections map[net.Conn]struct{}
+	closed      bool
+	// drained is closed once the tracker is closed and all connections have been removed.
+	drained chan struct{}
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		mutex:       &sync.Mutex{},
+		connections: map[net.Conn]struct{}{},
+		drained:     make(chan struct{}),
+	}
+}
+
+// add registers a new connection. It returns false if the tracker is already closed.
+func (c *connectionTracker) add(conn net.Conn) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false
+	}
+	c.connections[conn] = struct{}{}
+	return true
+}
+
+// remove closes and unregisters a connection after its handler has returned.
+func (c *connectionTracker) remove(conn net.Conn) {
+	_ = conn.Close()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.connections, conn)
+	c.checkDrained()
+}
+
+// close stops accepting new connections.
+func (c *connectionTracker) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	c.checkDrained()
+}
+
+// checkDrained closes the drained channel if appropriate. It must be called with the mutex held.
+func (c *connectionTracker) checkDrained() {
+	if c.closed && len(c.connections) == 0 && !isClosed(c.drained) {
+		close(c.drained)
+	}
+}
+
+func (c *connectionTracker) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.connections)
+}
+
+// wait waits for all connections to be removed or the context to end.
+func (c *connectionTracker) wait(ctx context.Context) error {
+	select {
+	case <-c.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *connectionTracker) closeAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for conn := range c.connections {
+		_ = conn.Close()
+	}
+}
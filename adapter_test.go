@@ -0,0 +1,310 @@
+package service_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func TestHTTPService(t *testing.T) {
+	handlerStarted := make(chan struct{})
+	releaseHandler := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello world!"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(handlerStarted)
+		<-releaseHandler
+		_, _ = w.Write([]byte("done"))
+	})
+	s := service.NewHTTPService(&http.Server{Addr: "127.0.0.1:0", Handler: mux})
+	assert.Equal(t, "HTTP server on 127.0.0.1:0", s.String())
+	assert.Nil(t, s.Addr())
+
+	l, result := runAdapter(t, s)
+	url := fmt.Sprintf("http://%s", s.Addr())
+	body, err := httpGet(url + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello world!", body)
+
+	// A pending request must be completed during the graceful shutdown.
+	slowResult := make(chan string, 1)
+	go func() {
+		body, _ := httpGet(url + "/slow")
+		slowResult <- body
+	}()
+	<-handlerStarted
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop(context.Background())
+		close(stopped)
+	}()
+	_, err = l.WaitForState(context.Background(), service.StateStopping)
+	assert.NoError(t, err)
+	close(releaseHandler)
+	assert.Equal(t, "done", <-slowResult)
+	<-stopped
+	assert.NoError(t, <-result)
+	assert.Nil(t, s.Addr())
+}
+
+func TestHTTPServiceShutdownTimeout(t *testing.T) {
+	handlerStarted := make(chan struct{})
+	s := service.NewHTTPService(&http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(handlerStarted)
+			<-r.Context().Done()
+		}),
+	})
+	l, result := runAdapter(t, s)
+	go func() {
+		_, _ = httpGet(fmt.Sprintf("http://%s/", s.Addr()))
+	}()
+	<-handlerStarted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	l.Stop(ctx)
+	assert.NoError(t, <-result)
+}
+
+func TestHTTPServiceListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	s := service.NewHTTPService(&http.Server{Addr: listener.Addr().String()})
+	l := service.NewLifecycle(s)
+	assert.Error(t, l.Run())
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestTCPService(t *testing.T) {
+	s := service.NewTCPService("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte(line)); err != nil {
+				return
+			}
+		}
+	})
+	assert.Equal(t, "TCP server on 127.0.0.1:0", s.String())
+	l, result := runAdapter(t, s)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("Hello world!\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello world!\n", line)
+	assert.Equal(t, 1, s.Connections())
+	assert.Equal(t, 1, l.ActiveWork())
+
+	// The handler ignores the context, so the connection must be closed when the shutdown context expires.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	l.Stop(ctx)
+	assert.NoError(t, <-result)
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+	assert.Equal(t, 0, s.Connections())
+	assert.Nil(t, s.Addr())
+	_ = conn.Close()
+
+	// The service can be run again.
+	l, result = runAdapter(t, s)
+	conn, err = net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	_ = conn.Close()
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestTCPServiceGracefulShutdown(t *testing.T) {
+	handlerStarted := make(chan struct{})
+	s := service.NewTCPService("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		close(handlerStarted)
+		<-ctx.Done()
+		_, _ = conn.Write([]byte("Goodbye\n"))
+	})
+	l, result := runAdapter(t, s)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	<-handlerStarted
+
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+	data, err := ioutil.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "Goodbye\n", string(data))
+}
+
+func TestGRPCService(t *testing.T) {
+	server := newFakeGRPCServer(false)
+	s := service.NewGRPCService("127.0.0.1:0", server)
+	assert.Equal(t, "gRPC server on 127.0.0.1:0", s.String())
+	l, result := runAdapter(t, s)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	_ = conn.Close()
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.ElementsMatch(t, []string{"serve", "gracefulStop"}, server.getCalls())
+
+	// A hanging graceful stop is aborted when the shutdown context expires.
+	server = newFakeGRPCServer(true)
+	s = service.NewGRPCService("127.0.0.1:0", server)
+	l, result = runAdapter(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	l.Stop(ctx)
+	assert.NoError(t, <-result)
+	assert.ElementsMatch(t, []string{"serve", "gracefulStop", "stop"}, server.getCalls())
+}
+
+func TestFuncService(t *testing.T) {
+	s := service.NewFuncService("Worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, "Worker", s.String())
+	l := service.NewLifecycle(s)
+	var states []service.State
+	l.OnStateChange(func(s service.Service, l service.Lifecycle, state service.State) {
+		states = append(states, state)
+	})
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		go l.Stop(context.Background())
+	})
+	assert.NoError(t, l.Run())
+	assert.Equal(t, []service.State{
+		service.StateStarting,
+		service.StateRunning,
+		service.StateStopping,
+		service.StateStopped,
+	}, states)
+
+	crash := errors.New("crash")
+	l = service.NewLifecycle(service.NewFuncService("Worker", func(ctx context.Context) error {
+		return crash
+	}))
+	assert.Equal(t, crash, l.Run())
+	assert.Equal(t, service.StateCrashed, l.State())
+
+	l = service.NewLifecycle(service.NewFuncService("Worker", func(ctx context.Context) error {
+		panic("crash")
+	}))
+	assert.Error(t, l.Run())
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+// runAdapter runs the service on a new lifecycle and waits for it to enter the "running" state.
+func runAdapter(t *testing.T, s service.Service) (service.Lifecycle, <-chan error) {
+	t.Helper()
+	l := service.NewLifecycle(s)
+	return l, startLifecycle(t, l)
+}
+
+func httpGet(url string) (string, error) {
+	response, err := http.Get(url) //nolint:gosec
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	body, err := ioutil.ReadAll(response.Body)
+	return string(body), err
+}
+
+// fakeGRPCServer implements the methods of a gRPC server used by the adapter.
+type fakeGRPCServer struct {
+	mutex              sync.Mutex
+	calls              []string
+	listener           net.Listener
+	stopping           bool
+	stopped            chan struct{}
+	hangOnGracefulStop bool
+}
+
+func newFakeGRPCServer(hangOnGracefulStop bool) *fakeGRPCServer {
+	return &fakeGRPCServer{
+		stopped:            make(chan struct{}),
+		hangOnGracefulStop: hangOnGracefulStop,
+	}
+}
+
+func (f *fakeGRPCServer) call(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, name)
+}
+
+func (f *fakeGRPCServer) getCalls() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+func (f *fakeGRPCServer) Serve(listener net.Listener) error {
+	f.call("serve")
+	f.mutex.Lock()
+	f.listener = listener
+	if f.stopping {
+		_ = listener.Close()
+	}
+	f.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil
+		}
+		_ = conn.Close()
+	}
+}
+
+func (f *fakeGRPCServer) closeListener() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.stopping = true
+	if f.listener != nil {
+		_ = f.listener.Close()
+	}
+}
+
+func (f *fakeGRPCServer) GracefulStop() {
+	f.call("gracefulStop")
+	f.closeListener()
+	if f.hangOnGracefulStop {
+		<-f.stopped
+	}
+}
+
+func (f *fakeGRPCServer) Stop() {
+	f.call("stop")
+	f.closeListener()
+	close(f.stopped)
+}
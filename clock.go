@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is an abstraction of the time functions used by lifecycles and pools. It can be replaced in tests to trigger
+// timeouts deterministically. The servicetest package contains a fake implementation.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer creates a timer that fires once after the specified duration.
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker creates a ticker that fires repeatedly with the specified interval.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single-shot timer created by a Clock. It mirrors the behavior of time.Timer.
+type Timer interface {
+	// C returns the channel the current time is sent on when the timer fires.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing. It returns false if the timer has already fired or has been stopped.
+	Stop() bool
+
+	// Reset changes the timer to fire after the specified duration. It returns true if the timer was active.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a repeating timer created by a Clock. It mirrors the behavior of time.Ticker.
+type Ticker interface {
+	// C returns the channel the current time is sent on every time the ticker fires.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// NewRealClock returns a Clock backed by the time package.
+func NewRealClock() Clock {
+	return &realClock{}
+}
+
+type realClock struct {
+}
+
+func (r *realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (r *realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+func (r *realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (r *realTimer) C() <-chan time.Time {
+	return r.timer.C
+}
+
+func (r *realTimer) Stop() bool {
+	return r.timer.Stop()
+}
+
+func (r *realTimer) Reset(d time.Duration) bool {
+	return r.timer.Reset(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (r *realTicker) C() <-chan time.Time {
+	return r.ticker.C
+}
+
+func (r *realTicker) Stop() {
+	r.ticker.Stop()
+}
+
+// clockSource is implemented by the lifecycles of this package. It allows the services they run to use the clock of
+// the lifecycle for their own timers.
+type clockSource interface {
+	clock() Clock
+}
+
+// lifecycleClock returns the clock of the lifecycle, or the system clock if the lifecycle does not expose one.
+func lifecycleClock(l Lifecycle) Clock {
+	if source, ok := l.(clockSource); ok {
+		return source.clock()
+	}
+	return NewRealClock()
+}
+
+// withClockTimeout returns a context that expires when the parent context expires or when the specified duration has
+// passed on the clock, whichever happens first. A duration of zero or less returns a cancelable copy of the parent.
+func withClockTimeout(parent context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(parent)
+	}
+	if _, ok := clock.(*realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+	ctx, cancel := context.WithCancel(parent)
+	result := &clockContext{
+		Context:  ctx,
+		deadline: clock.Now().Add(d),
+	}
+	timer := clock.NewTimer(d)
+	go func() {
+		select {
+		case <-timer.C():
+			result.lock.Lock()
+			result.expired = true
+			result.lock.Unlock()
+			cancel()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return result, cancel
+}
+
+// clockContext is a context with a deadline measured on a Clock instead of the system time.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+	lock     sync.Mutex
+	expired  bool
+}
+
+func (c *clockContext) Deadline() (time.Time, bool) {
+	if parentDeadline, ok := c.Context.Deadline(); ok && parentDeadline.Before(c.deadline) {
+		return parentDeadline, true
+	}
+	return c.deadline, true
+}
+
+func (c *clockContext) Err() error {
+	err := c.Context.Err()
+	if err == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return err
+}
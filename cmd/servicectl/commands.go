@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+
+	"github.com/containerssh/service"
+)
+
+func runStatus(ctx context.Context, client *service.ControlClient, _ arguments, p printer) error {
+	services, err := client.List(ctx)
+	if err != nil {
+		return err
+	}
+	return p.services(services)
+}
+
+func runStop(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	if err := client.Stop(ctx, args.id); err != nil {
+		return err
+	}
+	return p.result(service.ControlCommandStop, args.id)
+}
+
+func runRestart(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	if err := client.Restart(ctx, args.id); err != nil {
+		return err
+	}
+	return p.result(service.ControlCommandRestart, args.id)
+}
+
+func runPause(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	if err := client.Pause(ctx, args.id); err != nil {
+		return err
+	}
+	return p.result(service.ControlCommandPause, args.id)
+}
+
+func runResume(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	if err := client.Resume(ctx, args.id); err != nil {
+		return err
+	}
+	return p.result(service.ControlCommandResume, args.id)
+}
+
+func runReload(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	if err := client.Reload(ctx, args.id); err != nil {
+		return err
+	}
+	return p.result(service.ControlCommandReload, args.id)
+}
+
+func runHistory(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	history, err := client.History(ctx, args.id)
+	if err != nil {
+		return err
+	}
+	return p.history(history)
+}
+
+func runWatch(ctx context.Context, client *service.ControlClient, _ arguments, p printer) error {
+	events, err := client.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	for event := range events {
+		if err := p.event(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runGraph(ctx context.Context, client *service.ControlClient, args arguments, p printer) error {
+	graph, err := client.Graph(ctx, args.format)
+	if err != nil {
+		return err
+	}
+	return p.graph(graph)
+}
+
+func runStates(_ context.Context, _ *service.ControlClient, args arguments, p printer) error {
+	graph, err := service.StateGraph(args.format)
+	if err != nil {
+		return err
+	}
+	return p.graph(graph)
+}
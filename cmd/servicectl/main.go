@@ -0,0 +1,246 @@
+// Command servicectl controls a running service pool through its control socket.
+//
+// Usage:
+//
+//	servicectl [-socket path] [-output table|json] [-format dot|mermaid] <command> [flags] [id]
+//
+// The socket path can also be set using the SERVICECTL_SOCKET environment variable.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/containerssh/service"
+)
+
+const defaultTimeout = 60 * time.Second
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	exitCode := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(exitCode)
+}
+
+// options holds the flags shared by all commands.
+type options struct {
+	socketPath string
+	output     string
+	timeout    time.Duration
+	format     string
+}
+
+// arguments are the arguments passed to a command.
+type arguments struct {
+	// id is the service ID for commands that need one.
+	id string
+	// format is the diagram format for the graph and states commands.
+	format service.GraphFormat
+}
+
+// command is a subcommand of servicectl.
+type command struct {
+	usage       string
+	description string
+	// needsID is true if the command takes a service ID as its only argument.
+	needsID bool
+	// local is true if the command does not need the control socket. Its client is nil.
+	local bool
+	run   func(ctx context.Context, client *service.ControlClient, args arguments, p printer) error
+}
+
+var commands = map[string]command{
+	"status": {
+		usage:       "status",
+		description: "Show the services of the pool with their states, uptimes, active work and last errors.",
+		run:         runStatus,
+	},
+	"stop": {
+		usage:       "stop [-timeout duration] <id>",
+		description: "Stop a service and wait for it to exit. The timeout is also the shutdown timeout.",
+		needsID:     true,
+		run:         runStop,
+	},
+	"restart": {
+		usage:       "restart [-timeout duration] <id>",
+		description: "Restart a service and wait until it is running.",
+		needsID:     true,
+		run:         runRestart,
+	},
+	"pause": {
+		usage:       "pause <id>",
+		description: "Pause a service that supports pausing.",
+		needsID:     true,
+		run:         runPause,
+	},
+	"resume": {
+		usage:       "resume <id>",
+		description: "Resume a paused service.",
+		needsID:     true,
+		run:         runResume,
+	},
+	"reload": {
+		usage:       "reload <id>",
+		description: "Reload the configuration of a service that supports reloading.",
+		needsID:     true,
+		run:         runReload,
+	},
+	"history": {
+		usage:       "history <id>",
+		description: "Show the recent state changes of a service.",
+		needsID:     true,
+		run:         runHistory,
+	},
+	"watch": {
+		usage:       "watch",
+		description: "Print the events of the pool as they happen until interrupted.",
+		run:         runWatch,
+	},
+	"graph": {
+		usage:       "graph [-format dot|mermaid]",
+		description: "Print the services of the pool with their dependencies and states as a diagram.",
+		run:         runGraph,
+	},
+	"states": {
+		usage:       "states [-format dot|mermaid]",
+		description: "Print the state machine of service lifecycles as a diagram. Does not need a socket.",
+		local:       true,
+		run:         runStates,
+	},
+}
+
+// run executes servicectl with the specified arguments and returns the exit code.
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	opts := options{
+		socketPath: os.Getenv("SERVICECTL_SOCKET"),
+		output:     "table",
+		timeout:    defaultTimeout,
+		format:     string(service.GraphFormatDOT),
+	}
+	flags := newFlagSet("servicectl", &opts, stderr)
+	flags.Usage = func() {
+		printUsage(stderr, flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "Unknown command: %s\n\n", name)
+		flags.Usage()
+		return 2
+	}
+	commandFlags := newFlagSet("servicectl "+name, &opts, stderr)
+	commandFlags.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: servicectl %s\n\n%s\n\nFlags:\n", cmd.usage, cmd.description)
+		commandFlags.PrintDefaults()
+	}
+	positional, err := parseInterleaved(commandFlags, flags.Args()[1:])
+	if err != nil {
+		return 2
+	}
+	commandArgs := arguments{format: service.GraphFormat(opts.format)}
+	if cmd.needsID {
+		if len(positional) != 1 {
+			commandFlags.Usage()
+			return 2
+		}
+		commandArgs.id = positional[0]
+	} else if len(positional) != 0 {
+		commandFlags.Usage()
+		return 2
+	}
+	if err := commandArgs.format.Validate(); err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if opts.socketPath == "" && !cmd.local {
+		_, _ = fmt.Fprintln(stderr, "No control socket specified. Use -socket or set SERVICECTL_SOCKET.")
+		return 2
+	}
+	p, err := newPrinter(opts.output, stdout)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if name != "watch" {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	var client *service.ControlClient
+	if !cmd.local {
+		client = service.NewControlClient(opts.socketPath)
+	}
+	if err := cmd.run(ctx, client, commandArgs, p); err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s failed: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func newFlagSet(name string, opts *options, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.socketPath, "socket", opts.socketPath, "Path of the control socket.")
+	flags.StringVar(&opts.output, "output", opts.output, "Output format: table or json.")
+	flags.DurationVar(&opts.timeout, "timeout", opts.timeout, "Time to wait for the command to complete.")
+	flags.StringVar(
+		&opts.format,
+		"format",
+		opts.format,
+		"Diagram format of the graph and states commands: dot or mermaid.",
+	)
+	return flags
+}
+
+// parseInterleaved parses flags that may appear before or after the positional arguments.
+func parseInterleaved(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func printUsage(stderr io.Writer, flags *flag.FlagSet) {
+	_, _ = fmt.Fprintf(stderr, "Usage: servicectl [flags] <command> [flags] [id]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(stderr, "  %-34s %s\n", commands[name].usage, commands[name].description)
+	}
+	_, _ = fmt.Fprintf(stderr, "\nFlags:\n")
+	flags.PrintDefaults()
+	_, _ = fmt.Fprintf(stderr, "\nThe socket path can also be set using the SERVICECTL_SOCKET environment variable.\n")
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+type testService struct {
+	name  string
+	crash chan struct{}
+}
+
+func (t *testService) String() string {
+	return t.name
+}
+
+func (t *testService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Running()
+	select {
+	case <-lifecycle.Context().Done():
+		lifecycle.Stopping()
+		return nil
+	case <-t.crash:
+		return errors.New("crash\nwith details")
+	}
+}
+
+func newTestService(name string) *testService {
+	return &testService{
+		name:  name,
+		crash: make(chan struct{}),
+	}
+}
+
+// startTestPool runs a pool with a nested pool and a control service and returns the socket path.
+func startTestPool(t *testing.T) (string, *testService) {
+	logger := log.NewTestLogger(t)
+	dir, err := ioutil.TempDir("", "servicectl-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(dir, "control.sock")
+
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	nested := service.NewPool(service.NewLifecycleFactory(), logger)
+	crashing := newTestService("Crashing")
+	nested.Add(crashing)
+	_, err = pool.AddWithOptions(nested, service.ServiceOptions{ID: "nested"})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Web server"), service.ServiceOptions{ID: "web"})
+	assert.NoError(t, err)
+	control, err := service.NewControlService(service.ControlConfig{SocketPath: socketPath}, pool, logger)
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(control, service.ServiceOptions{ID: "control"})
+	assert.NoError(t, err)
+
+	lifecycle := service.NewLifecycle(pool)
+	result := make(chan error, 1)
+	go func() {
+		result <- lifecycle.Run()
+	}()
+	select {
+	case <-lifecycle.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout while waiting for the pool to start")
+	}
+	t.Cleanup(func() {
+		lifecycle.Stop(context.Background())
+		<-result
+		_ = os.RemoveAll(dir)
+	})
+	return socketPath, crashing
+}
+
+func runCommand(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	exitCode := run(context.Background(), args, stdout, stderr)
+	return exitCode, stdout.String(), stderr.String()
+}
+
+func TestStatus(t *testing.T) {
+	socketPath, _ := startTestPool(t)
+
+	exitCode, stdout, stderr := runCommand(t, "-socket", socketPath, "status")
+	assert.Equal(t, 0, exitCode, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if assert.Len(t, lines, 5) {
+		assert.Equal(t, []string{"ID", "NAME", "STATE", "UPTIME", "ACTIVE", "ERROR"}, strings.Fields(lines[0]))
+		assert.True(t, strings.HasPrefix(lines[1], "nested "))
+		assert.True(t, strings.HasPrefix(lines[2], "  Crashing "), "nested services are not indented")
+		assert.Equal(t, []string{"web", "Web", "server", "running", "0s", "0"}, strings.Fields(lines[3]))
+	}
+
+	exitCode, stdout, stderr = runCommand(t, "status", "-socket", socketPath, "-output", "json")
+	assert.Equal(t, 0, exitCode, stderr)
+	var services []service.ControlServiceInfo
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &services))
+	if assert.Len(t, services, 3) {
+		assert.Equal(t, "nested", services[0].ID)
+		assert.Len(t, services[0].Children, 1)
+	}
+}
+
+func TestStopAndRestart(t *testing.T) {
+	socketPath, _ := startTestPool(t)
+	assert.NoError(t, os.Setenv("SERVICECTL_SOCKET", socketPath))
+	defer func() {
+		_ = os.Unsetenv("SERVICECTL_SOCKET")
+	}()
+
+	exitCode, stdout, stderr := runCommand(t, "stop", "web", "-timeout", "30s")
+	assert.Equal(t, 0, exitCode, stderr)
+	assert.Equal(t, "web: stop completed\n", stdout)
+
+	exitCode, stdout, stderr = runCommand(t, "-output", "json", "restart", "web")
+	assert.Equal(t, 0, exitCode, stderr)
+	assert.JSONEq(t, `{"command":"restart","id":"web"}`, stdout)
+
+	exitCode, stdout, stderr = runCommand(t, "-output", "json", "history", "web")
+	assert.Equal(t, 0, exitCode, stderr)
+	var history []service.StateChange
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &history))
+	assert.Len(t, history, 6)
+
+	exitCode, _, stderr = runCommand(t, "pause", "web")
+	assert.Equal(t, 1, exitCode)
+	assert.Contains(t, stderr, "does not support pausing")
+
+	exitCode, _, stderr = runCommand(t, "stop", "nonexistent")
+	assert.Equal(t, 1, exitCode)
+	assert.Contains(t, stderr, "nonexistent")
+}
+
+func TestWatch(t *testing.T) {
+	socketPath, crashing := startTestPool(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stdout := &lockedBuffer{}
+	result := make(chan int, 1)
+	go func() {
+		result <- run(ctx, []string{"-socket", socketPath, "watch"}, stdout, ioutil.Discard)
+	}()
+
+	// Wait for the watch to be established before triggering events.
+	client := service.NewControlClient(socketPath)
+	for i := 0; i < 100; i++ {
+		_ = client.Stop(context.Background(), "web")
+		_ = client.Restart(context.Background(), "web")
+		if strings.Contains(stdout.String(), "web  running") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(crashing.crash)
+
+	select {
+	case exitCode := <-result:
+		// The pool shuts down after the crash, which ends the watch.
+		assert.Equal(t, 0, exitCode)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the watch did not end when the pool stopped")
+	}
+	output := stdout.String()
+	assert.Contains(t, output, "web  stopped")
+	assert.Contains(t, output, "web  restarted")
+	assert.Contains(t, output, "Crashing  crashed: crash; with details")
+}
+
+func TestGraph(t *testing.T) {
+	socketPath, _ := startTestPool(t)
+
+	exitCode, stdout, stderr := runCommand(t, "-socket", socketPath, "graph")
+	assert.Equal(t, 0, exitCode, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "digraph pool {\n"))
+	assert.Contains(t, stdout, `subgraph "cluster_nested"`)
+
+	exitCode, stdout, stderr = runCommand(t, "-socket", socketPath, "-output", "json", "graph", "-format", "mermaid")
+	assert.Equal(t, 0, exitCode, stderr)
+	var result struct {
+		Graph string `json:"graph"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.True(t, strings.HasPrefix(result.Graph, "flowchart LR\n"))
+	assert.Contains(t, result.Graph, `"web<br/>Web server<br/>running<br/>critical"`)
+}
+
+func TestStates(t *testing.T) {
+	// The state machine is rendered locally, so no socket is needed.
+	exitCode, stdout, stderr := runCommand(t, "states", "-format", "mermaid")
+	assert.Equal(t, 0, exitCode, stderr)
+	expected, err := service.StateGraph(service.GraphFormatMermaid)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, stdout)
+
+	exitCode, _, stderr = runCommand(t, "states", "-format", "png")
+	assert.Equal(t, 2, exitCode)
+	assert.Contains(t, stderr, "invalid graph format")
+}
+
+func TestUsageErrors(t *testing.T) {
+	exitCode, _, stderr := runCommand(t)
+	assert.Equal(t, 2, exitCode)
+	assert.Contains(t, stderr, "Usage:")
+
+	exitCode, _, stderr = runCommand(t, "-socket", "/nonexistent", "invalid")
+	assert.Equal(t, 2, exitCode)
+	assert.Contains(t, stderr, "Unknown command: invalid")
+
+	exitCode, _, _ = runCommand(t, "-socket", "/nonexistent", "stop")
+	assert.Equal(t, 2, exitCode)
+
+	exitCode, _, stderr = runCommand(t, "status")
+	assert.Equal(t, 2, exitCode)
+	assert.Contains(t, stderr, "No control socket")
+
+	exitCode, _, stderr = runCommand(t, "-socket", "/nonexistent", "-output", "xml", "status")
+	assert.Equal(t, 2, exitCode)
+	assert.Contains(t, stderr, "unknown output format")
+
+	exitCode, _, _ = runCommand(t, "-socket", "/nonexistent", "status")
+	assert.Equal(t, 1, exitCode)
+}
+
+func TestUptime(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := &tablePrinter{now: func() time.Time { return now }}
+	assert.Equal(t, "1h2m3s", p.uptime(service.ControlServiceInfo{
+		State: service.StateRunning,
+		Since: now.Add(-time.Hour - 2*time.Minute - 3*time.Second),
+	}))
+	assert.Equal(t, "-", p.uptime(service.ControlServiceInfo{State: service.StateStopped, Since: now}))
+	assert.Equal(t, "-", p.uptime(service.ControlServiceInfo{State: service.StateRunning}))
+}
+
+func TestCrashLoopState(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := &tablePrinter{now: func() time.Time { return now }}
+	until := now.Add(25 * time.Second)
+	assert.Equal(t, "crashed (crash loop, restart in 25s)", p.state(service.ControlServiceInfo{
+		State:            service.StateCrashed,
+		CircuitOpen:      true,
+		CircuitOpenUntil: &until,
+	}))
+	assert.Equal(t, "crashed (crash loop)", p.state(service.ControlServiceInfo{
+		State:       service.StateCrashed,
+		CircuitOpen: true,
+	}))
+	assert.Equal(t, "running", p.state(service.ControlServiceInfo{State: service.StateRunning}))
+}
+
+// lockedBuffer is a buffer that can be written and read from different goroutines.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buffer.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buffer.String()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerssh/service"
+)
+
+// printer writes the results of the commands in an output format.
+type printer interface {
+	services(services []service.ControlServiceInfo) error
+	result(command service.ControlCommand, id string) error
+	history(history []service.StateChange) error
+	event(event service.ControlEvent) error
+	graph(graph string) error
+}
+
+func newPrinter(format string, out io.Writer) (printer, error) {
+	switch format {
+	case "table":
+		return &tablePrinter{out: out, now: time.Now}, nil
+	case "json":
+		return &jsonPrinter{encoder: json.NewEncoder(out)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// tablePrinter prints human-readable tables.
+type tablePrinter struct {
+	out io.Writer
+	now func() time.Time
+}
+
+func (t *tablePrinter) services(services []service.ControlServiceInfo) error {
+	w := tabwriter.NewWriter(t.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tNAME\tSTATE\tUPTIME\tACTIVE\tERROR")
+	t.serviceRows(w, services, "")
+	return w.Flush()
+}
+
+// serviceRows prints one row per service, indenting the services of nested pools below their pool.
+func (t *tablePrinter) serviceRows(w io.Writer, services []service.ControlServiceInfo, indent string) {
+	for _, s := range services {
+		_, _ = fmt.Fprintf(
+			w,
+			"%s%s\t%s\t%s\t%s\t%d\t%s\n",
+			indent,
+			s.ID,
+			s.Name,
+			t.state(s),
+			t.uptime(s),
+			s.ActiveWork,
+			singleLine(s.Error),
+		)
+		t.serviceRows(w, s.Children, indent+"  ")
+	}
+}
+
+// state returns the state of the service, noting when it is not restarted because it crashes in a loop.
+func (t *tablePrinter) state(s service.ControlServiceInfo) string {
+	if !s.CircuitOpen {
+		return string(s.State)
+	}
+	if s.CircuitOpenUntil == nil {
+		return string(s.State) + " (crash loop)"
+	}
+	retry := s.CircuitOpenUntil.Sub(t.now()).Round(time.Second)
+	if retry < 0 {
+		retry = 0
+	}
+	return fmt.Sprintf("%s (crash loop, restart in %s)", s.State, retry)
+}
+
+// uptime returns how long the service has been running, or "-" if it is not running.
+func (t *tablePrinter) uptime(s service.ControlServiceInfo) string {
+	if s.State != service.StateRunning || s.Since.IsZero() {
+		return "-"
+	}
+	return t.now().Sub(s.Since).Round(time.Second).String()
+}
+
+func (t *tablePrinter) result(command service.ControlCommand, id string) error {
+	_, err := fmt.Fprintf(t.out, "%s: %s completed\n", id, command)
+	return err
+}
+
+func (t *tablePrinter) history(history []service.StateChange) error {
+	w := tabwriter.NewWriter(t.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIME\tSTATE")
+	for _, change := range history {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", change.Time.Format(time.RFC3339), change.State)
+	}
+	return w.Flush()
+}
+
+func (t *tablePrinter) event(event service.ControlEvent) error {
+	id := event.ID
+	if id == "" {
+		id = event.Name
+	}
+	description := string(event.State)
+	switch event.Type {
+	case service.EventTypeHealthChange:
+		description = "unhealthy"
+		if event.Healthy {
+			description = "healthy"
+		}
+	case service.EventTypeRestart:
+		description = "restarted"
+	case service.EventTypeHookError:
+		description = "hook failed"
+	}
+	if event.Error != "" {
+		description += ": " + singleLine(event.Error)
+	}
+	_, err := fmt.Fprintf(t.out, "%s  %s  %s\n", event.Time.Format(time.RFC3339), id, description)
+	return err
+}
+
+func (t *tablePrinter) graph(graph string) error {
+	_, err := io.WriteString(t.out, graph)
+	return err
+}
+
+// singleLine keeps multi-line errors from breaking the table layout.
+func singleLine(text string) string {
+	return strings.ReplaceAll(text, "\n", "; ")
+}
+
+// jsonPrinter prints one JSON document per result, suitable for scripts.
+type jsonPrinter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonPrinter) services(services []service.ControlServiceInfo) error {
+	if services == nil {
+		services = []service.ControlServiceInfo{}
+	}
+	return j.encoder.Encode(services)
+}
+
+func (j *jsonPrinter) result(command service.ControlCommand, id string) error {
+	return j.encoder.Encode(struct {
+		Command service.ControlCommand `json:"command"`
+		ID      string                 `json:"id"`
+	}{command, id})
+}
+
+func (j *jsonPrinter) history(history []service.StateChange) error {
+	if history == nil {
+		history = []service.StateChange{}
+	}
+	return j.encoder.Encode(history)
+}
+
+func (j *jsonPrinter) event(event service.ControlEvent) error {
+	return j.encoder.Encode(event)
+}
+
+func (j *jsonPrinter) graph(graph string) error {
+	return j.encoder.Encode(struct {
+		Graph string `json:"graph"`
+	}{graph})
+}
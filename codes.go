@@ -26,3 +26,57 @@ const MServicesStopping = "SERVICE_POOL_STOPPING"
 
 // ContainerSSH has stopped all services.
 const MServicesStopped = "SERVICE_POOL_STOPPED"
+
+// A ContainerSSH service attempted to move into a state that is not reachable from its current state, for example by
+// calling Running() after Stopping(). This is a bug in the service and should be reported.
+const EServiceIllegalTransition = "SERVICE_ILLEGAL_TRANSITION"
+
+// A hook registered on a ContainerSSH service returned an error. If the hook was called when the service was starting,
+// the service did not start.
+const EServiceHookFailed = "SERVICE_HOOK_FAILED"
+
+// A hook registered on a ContainerSSH service did not return within its timeout or panicked. The hook was abandoned
+// and the service continued its lifecycle. This is a bug in the hook and should be reported.
+const EServiceHookMisbehaved = "SERVICE_HOOK_MISBEHAVED"
+
+// An operator has issued a command through the control socket, for example to stop or restart a service.
+const MServiceControlCommand = "SERVICE_CONTROL_COMMAND"
+
+// A process has connected to the control socket but was rejected because its user is not allowed to control the
+// services. Check the permissions of the control socket and the list of allowed users.
+const EServiceControlRejected = "SERVICE_CONTROL_REJECTED"
+
+// A service still had in-flight work, such as connections or sessions, when its shutdown deadline was reached. The
+// remaining work has been canceled. Increase the shutdown timeout if this happens regularly.
+const EServiceDrainTimeout = "SERVICE_DRAIN_TIMEOUT"
+
+// A process run by an exec service has written a line to its standard output or standard error. The stream label
+// tells which one.
+const MServiceExecOutput = "SERVICE_EXEC_OUTPUT"
+
+// A process run by an exec service did not exit after receiving the termination signal before the shutdown deadline
+// and has been killed.
+const EServiceExecKilled = "SERVICE_EXEC_KILLED"
+
+// A service has exited, but goroutines it started with Lifecycle.Go() were still running at the shutdown deadline.
+// The message lists the names of the leaked tasks.
+const EServiceTaskLeaked = "SERVICE_TASK_LEAKED"
+
+// A cleanup registered with Lifecycle.Defer() failed or panicked after the service exited. The error is also reported
+// through Lifecycle.Error().
+const EServiceCleanupFailed = "SERVICE_CLEANUP_FAILED"
+
+// A service in a pool has exited and will be restarted according to its restart policy.
+const MServiceRestarting = "SERVICE_RESTARTING"
+
+// A service in a pool has crashed too often within the crash loop window. The pool stops restarting the service for
+// the cooldown, or shuts down if the service is critical and configured to stop the pool.
+const EServiceCrashLoop = "SERVICE_CRASH_LOOP"
+
+// A notification about a service could not be delivered to a webhook endpoint after all attempts. The notifications
+// of the batch are not sent to this endpoint.
+const EServiceNotificationFailed = "SERVICE_NOTIFICATION_FAILED"
+
+// Notifications about services have been discarded because the notification queue was full or the notifier could not
+// send them before the shutdown deadline.
+const EServiceNotificationsDropped = "SERVICE_NOTIFICATIONS_DROPPED"
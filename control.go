@@ -0,0 +1,115 @@
+package service
+
+import (
+	"time"
+)
+
+// ControlCommand is a command sent to the control socket.
+type ControlCommand string
+
+const (
+	// ControlCommandList lists the services of the pool with their states.
+	ControlCommandList ControlCommand = "list"
+	// ControlCommandStop stops a single service.
+	ControlCommandStop ControlCommand = "stop"
+	// ControlCommandRestart restarts a single service.
+	ControlCommandRestart ControlCommand = "restart"
+	// ControlCommandPause pauses a single service. The service must implement Pauser.
+	ControlCommandPause ControlCommand = "pause"
+	// ControlCommandResume resumes a paused service. The service must implement Pauser.
+	ControlCommandResume ControlCommand = "resume"
+	// ControlCommandReload reloads the configuration of a single service. The service must implement Reloader.
+	ControlCommandReload ControlCommand = "reload"
+	// ControlCommandHistory returns the state history of a single service.
+	ControlCommandHistory ControlCommand = "history"
+	// ControlCommandWatch streams the events of the pool. The control service confirms the subscription with an empty
+	// response, then sends one response containing an event per line until the connection is closed.
+	ControlCommandWatch ControlCommand = "watch"
+	// ControlCommandGraph renders the structure of the pool as a diagram in the requested format.
+	ControlCommandGraph ControlCommand = "graph"
+)
+
+// ControlRequest is a single request on the control socket. Requests are sent as JSON objects, one per line.
+type ControlRequest struct {
+	// Command is the command to execute.
+	Command ControlCommand `json:"command"`
+	// ID is the ID of the service the command applies to. Not used by the list and watch commands.
+	ID string `json:"id,omitempty"`
+	// Timeout is the shutdown timeout for the stop command. Defaults to the command timeout of the control service.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Format is the diagram format for the graph command. Defaults to GraphFormatDOT.
+	Format GraphFormat `json:"format,omitempty"`
+}
+
+// ControlResponse is the response to a ControlRequest. Responses are sent as JSON objects, one per line.
+type ControlResponse struct {
+	// Error contains the error message if the command failed.
+	Error string `json:"error,omitempty"`
+	// Services contains the services for the list command.
+	Services []ControlServiceInfo `json:"services,omitempty"`
+	// History contains the state history for the history command.
+	History []StateChange `json:"history,omitempty"`
+	// Event contains a single event for the watch command.
+	Event *ControlEvent `json:"event,omitempty"`
+	// Graph contains the diagram for the graph command.
+	Graph string `json:"graph,omitempty"`
+}
+
+// ControlServiceInfo describes a service in the response of the list command.
+type ControlServiceInfo struct {
+	// ID is the unique ID of the service within the pool.
+	ID string `json:"id"`
+	// Name is the name of the service as returned by String().
+	Name string `json:"name"`
+	// State is the current state of the service.
+	State State `json:"state"`
+	// Since is the time the service entered its current state. It is zero if the service has not been run yet.
+	Since time.Time `json:"since"`
+	// Error is the error of the last run of the service, if any.
+	Error string `json:"error,omitempty"`
+	// ActiveWork is the number of in-flight work units tracked by the lifecycle of the service.
+	ActiveWork int `json:"activeWork"`
+	// Labels are the labels the service was registered with.
+	Labels map[string]string `json:"labels,omitempty"`
+	// DependsOn lists the IDs of the services this service depends on.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Optional is true if the exit of the service does not shut down the pool.
+	Optional bool `json:"optional,omitempty"`
+	// CircuitOpen is true if the service has been detected to crash in a loop and is not being restarted.
+	CircuitOpen bool `json:"circuitOpen,omitempty"`
+	// CircuitOpenUntil is the time the service will be restarted again. It is nil if the circuit is closed, or if
+	// the crash loop has shut down the pool.
+	CircuitOpenUntil *time.Time `json:"circuitOpenUntil,omitempty"`
+	// Children contains the services of a nested pool. Their IDs are relative to the nested pool and cannot be used
+	// in commands.
+	Children []ControlServiceInfo `json:"children,omitempty"`
+}
+
+// ControlEvent describes an event of the pool sent for the watch command.
+type ControlEvent struct {
+	// Type is the type of the event.
+	Type EventType `json:"type"`
+	// Time is the time the event happened.
+	Time time.Time `json:"time"`
+	// ID is the ID of the service in the pool. It is empty for the services of nested pools.
+	ID string `json:"id,omitempty"`
+	// Name is the name of the service as returned by String().
+	Name string `json:"name"`
+	// State is the state of the service at the time of the event.
+	State State `json:"state"`
+	// Healthy is true if the service was running at the time of the event.
+	Healthy bool `json:"healthy"`
+	// Error is the error attached to the event, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// ControlError is the error returned by the ControlClient when the control service reports a failed command.
+type ControlError struct {
+	// Message is the error message sent by the control service.
+	Message string
+}
+
+// Error returns the error message.
+func (e *ControlError) Error() string {
+	return e.Message
+}
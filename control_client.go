@@ -0,0 +1,196 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// maxStopResponseMargin is the longest part of the deadline of a stop command reserved for the response of the control
+// service.
+const maxStopResponseMargin = time.Second
+
+// ControlClient sends commands to a control service over its Unix socket. Each call opens a new connection.
+type ControlClient struct {
+	socketPath string
+}
+
+// NewControlClient creates a client for the control socket at the specified path.
+func NewControlClient(socketPath string) *ControlClient {
+	return &ControlClient{
+		socketPath: socketPath,
+	}
+}
+
+// List returns the services of the pool with their current states.
+func (c *ControlClient) List(ctx context.Context) ([]ControlServiceInfo, error) {
+	response, err := c.Send(ctx, ControlRequest{Command: ControlCommandList})
+	if err != nil {
+		return nil, err
+	}
+	return response.Services, nil
+}
+
+// Stop stops the service with the specified ID and waits for it to exit. If the context has a deadline, it is also used
+// as the shutdown deadline of the service, minus a margin for the control service to report a timeout back.
+func (c *ControlClient) Stop(ctx context.Context, id string) error {
+	request := ControlRequest{Command: ControlCommandStop, ID: id}
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return context.DeadlineExceeded
+		}
+		margin := remaining / 10
+		if margin > maxStopResponseMargin {
+			margin = maxStopResponseMargin
+		}
+		request.Timeout = remaining - margin
+	}
+	_, err := c.Send(ctx, request)
+	return err
+}
+
+// Restart restarts the service with the specified ID and waits for it to enter the "running" state.
+func (c *ControlClient) Restart(ctx context.Context, id string) error {
+	_, err := c.Send(ctx, ControlRequest{Command: ControlCommandRestart, ID: id})
+	return err
+}
+
+// Pause pauses the service with the specified ID. The service must implement Pauser.
+func (c *ControlClient) Pause(ctx context.Context, id string) error {
+	_, err := c.Send(ctx, ControlRequest{Command: ControlCommandPause, ID: id})
+	return err
+}
+
+// Resume resumes the paused service with the specified ID. The service must implement Pauser.
+func (c *ControlClient) Resume(ctx context.Context, id string) error {
+	_, err := c.Send(ctx, ControlRequest{Command: ControlCommandResume, ID: id})
+	return err
+}
+
+// Reload reloads the configuration of the service with the specified ID. The service must implement Reloader.
+func (c *ControlClient) Reload(ctx context.Context, id string) error {
+	_, err := c.Send(ctx, ControlRequest{Command: ControlCommandReload, ID: id})
+	return err
+}
+
+// History returns the recorded state changes of the service with the specified ID, oldest first.
+func (c *ControlClient) History(ctx context.Context, id string) ([]StateChange, error) {
+	response, err := c.Send(ctx, ControlRequest{Command: ControlCommandHistory, ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return response.History, nil
+}
+
+// Graph returns the structure of the pool as a diagram in the specified format.
+func (c *ControlClient) Graph(ctx context.Context, format GraphFormat) (string, error) {
+	response, err := c.Send(ctx, ControlRequest{Command: ControlCommandGraph, Format: format})
+	if err != nil {
+		return "", err
+	}
+	return response.Graph, nil
+}
+
+// Watch streams the events of the pool. The returned channel is closed when the context ends or the control service
+// closes the connection.
+func (c *ControlClient) Watch(ctx context.Context) (<-chan ControlEvent, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(conn).Encode(ControlRequest{Command: ControlCommandWatch}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	var confirmation ControlResponse
+	if err := decoder.Decode(&confirmation); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if confirmation.Error != "" {
+		_ = conn.Close()
+		return nil, &ControlError{Message: confirmation.Error}
+	}
+	// The stream has no deadline, only the context ends it.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	events := make(chan ControlEvent)
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		defer close(events)
+		for {
+			var response ControlResponse
+			if err := decoder.Decode(&response); err != nil || response.Event == nil {
+				_ = conn.Close()
+				return
+			}
+			select {
+			case events <- *response.Event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Send sends a raw request to the control service. If the service reports an error it is returned as a *ControlError.
+func (c *ControlClient) Send(ctx context.Context, request ControlRequest) (ControlResponse, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return ControlResponse{}, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return ControlResponse{}, err
+	}
+	var response ControlResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		if ctx.Err() != nil {
+			return ControlResponse{}, ctx.Err()
+		}
+		return ControlResponse{}, err
+	}
+	if response.Error != "" {
+		return response, &ControlError{Message: response.Error}
+	}
+	return response, nil
+}
+
+// dial connects to the control socket. The deadline of the context, if any, applies to the connection.
+func (c *ControlClient) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
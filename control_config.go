@@ -0,0 +1,35 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ControlConfig holds the settings for the control socket.
+type ControlConfig struct {
+	// SocketPath is the path of the Unix socket to listen on. Required.
+	SocketPath string
+
+	// SocketMode is the file mode of the socket. Defaults to 0600, allowing only the owner to connect.
+	SocketMode os.FileMode
+
+	// AllowedUIDs lists the users that may send commands. Connecting processes are identified by their socket peer
+	// credentials where the operating system supports it. Defaults to the user running this process.
+	AllowedUIDs []int
+
+	// CommandTimeout bounds the execution of a single command, including the shutdown of a stopped service. Defaults
+	// to 60 seconds.
+	CommandTimeout time.Duration
+}
+
+// Validate checks the control configuration for errors.
+func (c *ControlConfig) Validate() error {
+	if c.SocketPath == "" {
+		return fmt.Errorf("no control socket path provided")
+	}
+	if c.CommandTimeout < 0 {
+		return fmt.Errorf("the command timeout must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+const (
+	defaultControlSocketMode     os.FileMode = 0600
+	defaultControlCommandTimeout             = 60 * time.Second
+)
+
+// errPeerCredentialsUnsupported is returned by peerUID on platforms that cannot identify the peer of a Unix socket.
+var errPeerCredentialsUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// NewControlService creates a service that accepts commands for the pool on a Unix socket. The returned service can be
+// run on its own lifecycle or added to the pool it controls. It returns an error if the configuration is invalid.
+func NewControlService(config ControlConfig, pool Pool, logger log.Logger) (Service, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.SocketMode == 0 {
+		config.SocketMode = defaultControlSocketMode
+	}
+	if len(config.AllowedUIDs) == 0 {
+		config.AllowedUIDs = []int{os.Getuid()}
+	}
+	if config.CommandTimeout == 0 {
+		config.CommandTimeout = defaultControlCommandTimeout
+	}
+	return &controlService{
+		config:      config,
+		pool:        pool,
+		logger:      logger,
+		mutex:       &sync.Mutex{},
+		connections: map[*controlConnection]struct{}{},
+	}, nil
+}
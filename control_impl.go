@@ -0,0 +1,428 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/containerssh/log"
+)
+
+// maxControlRequestSize is the longest request line the control service accepts.
+const maxControlRequestSize = 64 * 1024
+
+type controlService struct {
+	config      ControlConfig
+	pool        Pool
+	logger      log.Logger
+	mutex       *sync.Mutex
+	connections map[*controlConnection]struct{}
+	wg          sync.WaitGroup
+}
+
+type controlConnection struct {
+	conn net.Conn
+	uid  int
+}
+
+func (c *controlService) String() string {
+	return "Control socket"
+}
+
+func (c *controlService) RunWithLifecycle(lifecycle Lifecycle) error {
+	if err := removeStaleSocket(c.config.SocketPath); err != nil {
+		return err
+	}
+	listener, err := listenControlSocket(c.config.SocketPath, c.config.SocketMode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(c.config.SocketPath)
+	}()
+	lifecycle.Running()
+
+	ctx := lifecycle.Context()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	var acceptErr error
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				acceptErr = err
+			}
+			break
+		}
+		c.handle(ctx, conn)
+	}
+
+	lifecycle.Stopping()
+	_ = listener.Close()
+	c.mutex.Lock()
+	for connection := range c.connections {
+		_ = connection.conn.Close()
+	}
+	c.mutex.Unlock()
+	c.wg.Wait()
+	return acceptErr
+}
+
+// removeStaleSocket removes a socket left behind by a previous process. It refuses to remove anything but a socket,
+// and sockets another process is still listening on.
+func removeStaleSocket(path string) error {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if stat.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("failed to check if %s is in use (%w)", path, err)
+	}
+	return os.Remove(path)
+}
+
+// listenControlSocket listens on a Unix socket with the specified file mode. The socket is created in a private
+// directory and only moved to its path once its mode is set, so nobody can connect before access is restricted.
+func listenControlSocket(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".control")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	privatePath := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed by the control service once it has moved.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(privatePath, mode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(privatePath, path); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// handle starts serving a new connection in the background.
+func (c *controlService) handle(ctx context.Context, conn net.Conn) {
+	connection := &controlConnection{
+		conn: conn,
+		uid:  -1,
+	}
+	c.mutex.Lock()
+	c.connections[connection] = struct{}{}
+	c.wg.Add(1)
+	c.mutex.Unlock()
+	go func() {
+		defer func() {
+			c.mutex.Lock()
+			delete(c.connections, connection)
+			c.mutex.Unlock()
+			_ = conn.Close()
+			c.wg.Done()
+		}()
+		if c.authorize(connection) {
+			c.serve(ctx, connection)
+		}
+	}()
+}
+
+// authorize checks the peer credentials of the connection against the allowed users. Rejected peers receive an error
+// response.
+func (c *controlService) authorize(connection *controlConnection) bool {
+	uid, err := peerUID(connection.conn)
+	switch {
+	case err == nil:
+		connection.uid = uid
+		if c.allowed(uid) {
+			return true
+		}
+		c.logger.Warning(
+			log.NewMessage(
+				EServiceControlRejected,
+				"Rejected control connection from user %d",
+				uid,
+			).Label("uid", uid),
+		)
+		c.reject(connection.conn, fmt.Sprintf("user %d is not allowed to control the services", uid))
+		return false
+	case errors.Is(err, errPeerCredentialsUnsupported):
+		// Access is restricted by the file mode of the socket.
+		return true
+	default:
+		c.logger.Warning(
+			log.Wrap(
+				err,
+				EServiceControlRejected,
+				"Rejected control connection because the peer credentials could not be read",
+			),
+		)
+		c.reject(connection.conn, "failed to read peer credentials")
+		return false
+	}
+}
+
+func (c *controlService) allowed(uid int) bool {
+	for _, allowed := range c.config.AllowedUIDs {
+		if allowed == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *controlService) reject(conn net.Conn, message string) {
+	_ = json.NewEncoder(conn).Encode(ControlResponse{Error: message})
+}
+
+// serve reads requests from the connection, one per line, and writes one response per request.
+func (c *controlService) serve(ctx context.Context, connection *controlConnection) {
+	scanner := bufio.NewScanner(connection.conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxControlRequestSize)
+	encoder := json.NewEncoder(connection.conn)
+	for scanner.Scan() {
+		var request ControlRequest
+		var response ControlResponse
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Error = fmt.Sprintf("invalid request (%v)", err)
+		} else if request.Command == ControlCommandWatch {
+			c.watch(ctx, connection, encoder)
+			return
+		} else {
+			response = c.execute(ctx, connection, request)
+		}
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+func (c *controlService) execute(
+	ctx context.Context,
+	connection *controlConnection,
+	request ControlRequest,
+) ControlResponse {
+	if request.Command != ControlCommandList && request.Command != ControlCommandGraph {
+		c.logger.Info(
+			log.NewMessage(
+				MServiceControlCommand,
+				"Control command %s received for %s",
+				request.Command,
+				request.ID,
+			).Label("command", string(request.Command)).Label("id", request.ID).Label("uid", connection.uid),
+		)
+	}
+
+	commandContext, cancel := context.WithTimeout(ctx, c.config.CommandTimeout)
+	defer cancel()
+
+	var response ControlResponse
+	var err error
+	switch request.Command {
+	case ControlCommandList:
+		response.Services = controlServices(c.pool)
+	case ControlCommandStop:
+		timeout := c.config.CommandTimeout
+		if request.Timeout > 0 {
+			timeout = request.Timeout
+		}
+		// The shutdown must not be aborted when the control service itself is being stopped.
+		shutdownContext, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+		defer cancelShutdown()
+		if err = c.checkNotSelf(request.ID); err == nil {
+			err = c.pool.StopService(shutdownContext, request.ID)
+		}
+	case ControlCommandRestart:
+		if err = c.checkNotSelf(request.ID); err == nil {
+			err = c.pool.RestartService(commandContext, request.ID)
+		}
+	case ControlCommandPause:
+		err = c.pause(commandContext, request.ID)
+	case ControlCommandResume:
+		err = c.resume(commandContext, request.ID)
+	case ControlCommandReload:
+		err = c.reload(commandContext, request.ID)
+	case ControlCommandHistory:
+		response.History, err = c.pool.History(request.ID)
+	case ControlCommandGraph:
+		format := request.Format
+		if format == "" {
+			format = GraphFormatDOT
+		}
+		response.Graph, err = PoolGraph(c.pool, format)
+	default:
+		err = fmt.Errorf("unknown command: %q", request.Command)
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+// controlServices describes the services of the pool, including the services of nested pools.
+func controlServices(pool Pool) []ControlServiceInfo {
+	services := pool.Services()
+	result := make([]ControlServiceInfo, len(services))
+	for i, info := range services {
+		result[i] = ControlServiceInfo{
+			ID:         info.ID,
+			Name:       info.Service.String(),
+			State:      info.State,
+			Since:      info.Since,
+			ActiveWork: info.Lifecycle.ActiveWork(),
+			Labels:     info.Labels,
+			DependsOn:  info.DependsOn,
+			Optional:   info.Optional,
+
+			CircuitOpen: info.CircuitOpen,
+		}
+		if !info.CircuitOpenUntil.IsZero() {
+			until := info.CircuitOpenUntil
+			result[i].CircuitOpenUntil = &until
+		}
+		if err := info.Lifecycle.Error(); err != nil {
+			result[i].Error = err.Error()
+		}
+		if nested, ok := info.Service.(Pool); ok {
+			result[i].Children = controlServices(nested)
+		}
+	}
+	return result
+}
+
+// watch streams the events of the pool to the connection until the client disconnects or the control service stops.
+func (c *controlService) watch(ctx context.Context, connection *controlConnection, encoder *json.Encoder) {
+	watchContext, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// The client sends nothing after the watch command, so a finished read means it has disconnected.
+		_, _ = io.Copy(ioutil.Discard, connection.conn)
+		cancel()
+	}()
+
+	events := c.pool.Subscribe(watchContext)
+	// An empty response confirms the subscription so the client does not miss events that happen right after.
+	if err := encoder.Encode(ControlResponse{}); err != nil {
+		return
+	}
+	ids := map[Service]string{}
+	for event := range events {
+		id, ok := ids[event.Service]
+		if !ok {
+			collectServiceIDs(c.pool, ids)
+			id = ids[event.Service]
+		}
+		controlEvent := &ControlEvent{
+			Type:    event.Type,
+			Time:    event.Time,
+			ID:      id,
+			Name:    event.Service.String(),
+			State:   event.State,
+			Healthy: event.Healthy,
+		}
+		if event.Error != nil {
+			controlEvent.Error = event.Error.Error()
+		}
+		if err := encoder.Encode(ControlResponse{Event: controlEvent}); err != nil {
+			return
+		}
+	}
+}
+
+// collectServiceIDs stores the IDs of the services of the pool, including the services of nested pools, in ids.
+func collectServiceIDs(pool Pool, ids map[Service]string) {
+	for _, info := range pool.Services() {
+		ids[info.Service] = info.ID
+		if nested, ok := info.Service.(Pool); ok {
+			collectServiceIDs(nested, ids)
+		}
+	}
+}
+
+// lookup returns the service with the specified ID.
+func (c *controlService) lookup(id string) (Service, error) {
+	for _, info := range c.pool.Services() {
+		if info.ID == id {
+			return info.Service, nil
+		}
+	}
+	return nil, &ServiceNotFoundError{ID: id}
+}
+
+// checkNotSelf returns an error if the ID belongs to this control service, which cannot stop itself while it is serving
+// the request.
+func (c *controlService) checkNotSelf(id string) error {
+	if s, err := c.lookup(id); err == nil && s == Service(c) {
+		return fmt.Errorf("service %s is the control service and cannot be stopped through itself", id)
+	}
+	return nil
+}
+
+func (c *controlService) pauser(id string) (Pauser, error) {
+	s, err := c.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	pauser, ok := s.(Pauser)
+	if !ok {
+		return nil, fmt.Errorf("service %s does not support pausing", id)
+	}
+	return pauser, nil
+}
+
+func (c *controlService) pause(ctx context.Context, id string) error {
+	pauser, err := c.pauser(id)
+	if err != nil {
+		return err
+	}
+	return pauser.Pause(ctx)
+}
+
+func (c *controlService) resume(ctx context.Context, id string) error {
+	pauser, err := c.pauser(id)
+	if err != nil {
+		return err
+	}
+	return pauser.Resume(ctx)
+}
+
+func (c *controlService) reload(ctx context.Context, id string) error {
+	s, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	reloader, ok := s.(Reloader)
+	if !ok {
+		return fmt.Errorf("service %s does not support reloading", id)
+	}
+	return reloader.Reload(ctx)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package service
+
+import (
+	"net"
+)
+
+// peerUID is not supported on this platform. Access to the control socket is only restricted by its file mode.
+func peerUID(_ net.Conn) (int, error) {
+	return 0, errPeerCredentialsUnsupported
+}
@@ -0,0 +1,311 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+// controllableService is a test service that supports pausing and reloading.
+type controllableService struct {
+	*testService
+	mutex   sync.Mutex
+	paused  bool
+	reloads int
+}
+
+func (c *controllableService) Pause(_ context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.paused {
+		return errors.New("already paused")
+	}
+	c.paused = true
+	return nil
+}
+
+func (c *controllableService) Resume(_ context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.paused = false
+	return nil
+}
+
+func (c *controllableService) Reload(_ context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reloads++
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	socketPath := tempSocketPath(t)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	controllable := &controllableService{testService: newTestService("Controllable")}
+	_, err := pool.AddWithOptions(controllable, service.ServiceOptions{
+		ID:     "controllable",
+		Labels: map[string]string{"type": "test"},
+	})
+	assert.NoError(t, err)
+	plain, err := pool.AddWithOptions(newTestService("Plain"), service.ServiceOptions{ID: "plain"})
+	assert.NoError(t, err)
+	control, err := service.NewControlService(service.ControlConfig{SocketPath: socketPath}, pool, logger)
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(control, service.ServiceOptions{ID: "control"})
+	assert.NoError(t, err)
+
+	poolLifecycle, result := startPool(t, pool)
+	stat, err := os.Stat(socketPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	client := service.NewControlClient(socketPath)
+	ctx := context.Background()
+
+	services, err := client.List(ctx)
+	assert.NoError(t, err)
+	for i := range services {
+		assert.False(t, services[i].Since.IsZero())
+		services[i].Since = time.Time{}
+	}
+	assert.Equal(t, []service.ControlServiceInfo{
+		{
+			ID:     "controllable",
+			Name:   "Controllable",
+			State:  service.StateRunning,
+			Labels: map[string]string{"type": "test"},
+		},
+		{ID: "plain", Name: "Plain", State: service.StateRunning},
+		{ID: "control", Name: "Control socket", State: service.StateRunning},
+	}, services)
+
+	graph, err := client.Graph(ctx, "")
+	assert.NoError(t, err)
+	expectedGraph, err := service.PoolGraph(pool, service.GraphFormatDOT)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedGraph, graph)
+	_, err = client.Graph(ctx, "png")
+	assert.Error(t, err)
+
+	assert.NoError(t, client.Pause(ctx, "controllable"))
+	var controlErr *service.ControlError
+	assert.True(t, errors.As(client.Pause(ctx, "controllable"), &controlErr))
+	assert.Equal(t, "already paused", controlErr.Message)
+	assert.NoError(t, client.Resume(ctx, "controllable"))
+	assert.NoError(t, client.Reload(ctx, "controllable"))
+	controllable.mutex.Lock()
+	assert.Equal(t, 1, controllable.reloads)
+	controllable.mutex.Unlock()
+	assert.Error(t, client.Pause(ctx, "plain"))
+	assert.Error(t, client.Reload(ctx, "plain"))
+	assert.Error(t, client.Stop(ctx, "nonexistent"))
+	assert.True(t, errors.As(client.Stop(ctx, "control"), &controlErr), "the control service stopped itself")
+	assert.True(t, errors.As(client.Restart(ctx, "control"), &controlErr), "the control service restarted itself")
+	_, err = client.Send(ctx, service.ControlRequest{Command: "invalid"})
+	assert.Error(t, err)
+
+	assert.NoError(t, client.Stop(ctx, "plain"))
+	assert.Equal(t, service.StateStopped, plain.State())
+	assert.NoError(t, client.Restart(ctx, "plain"))
+	assert.Equal(t, service.StateRunning, plain.State())
+
+	history, err := client.History(ctx, "plain")
+	assert.NoError(t, err)
+	assert.Len(t, history, 6)
+	assert.Equal(t, service.StateStopped, history[3].State)
+
+	poolLifecycle.Stop(ctx)
+	assert.NoError(t, <-result)
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err), "the socket was not removed")
+	_, err = client.List(ctx)
+	assert.Error(t, err)
+}
+
+func TestControlStopTimeout(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	socketPath := tempSocketPath(t)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	release := make(chan struct{})
+	_, err := pool.AddWithOptions(newCallbackService("Stubborn", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		<-release
+		return nil
+	}), service.ServiceOptions{ID: "stubborn"})
+	assert.NoError(t, err)
+	control, err := service.NewControlService(service.ControlConfig{SocketPath: socketPath}, pool, logger)
+	assert.NoError(t, err)
+	pool.Add(control)
+	poolLifecycle, result := startPool(t, pool)
+
+	// The timeout of the service is reported by the control service instead of the connection timing out.
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = service.NewControlClient(socketPath).Stop(ctx, "stubborn")
+	var controlErr *service.ControlError
+	if assert.True(t, errors.As(err, &controlErr), "unexpected error: %v", err) {
+		assert.Contains(t, controlErr.Message, context.DeadlineExceeded.Error())
+	}
+
+	close(release)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestControlNestedPoolAndWatch(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	socketPath := tempSocketPath(t)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	nested := service.NewPool(service.NewLifecycleFactory(), logger)
+	crashing := newTestService("Crashing")
+	nested.Add(crashing)
+	_, err := pool.AddWithOptions(nested, service.ServiceOptions{ID: "nested"})
+	assert.NoError(t, err)
+	plain, err := pool.AddWithOptions(newTestService("Plain"), service.ServiceOptions{ID: "plain"})
+	assert.NoError(t, err)
+	control, err := service.NewControlService(service.ControlConfig{SocketPath: socketPath}, pool, logger)
+	assert.NoError(t, err)
+	pool.Add(control)
+	_, result := startPool(t, pool)
+
+	client := service.NewControlClient(socketPath)
+	services, err := client.List(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, services, 3) && assert.Len(t, services[0].Children, 1) {
+		assert.Equal(t, "Crashing", services[0].Children[0].ID)
+		assert.Equal(t, service.StateRunning, services[0].Children[0].State)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := client.Watch(ctx)
+	assert.NoError(t, err)
+
+	stopContext, cancelStop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelStop()
+	assert.NoError(t, client.Stop(stopContext, "plain"))
+	assert.Equal(t, service.StateStopped, plain.State())
+
+	var states []service.State
+	for event := range events {
+		if event.Type == service.EventTypeStateChange {
+			assert.Equal(t, "plain", event.ID)
+			assert.Equal(t, "Plain", event.Name)
+			states = append(states, event.State)
+		}
+		if len(states) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, []service.State{service.StateStopping, service.StateStopped}, states)
+
+	crashing.Crash()
+	assert.Error(t, <-result)
+	for event := range events {
+		if event.Type == service.EventTypeStateChange && event.Name == "Crashing" {
+			assert.Equal(t, "Crashing", event.ID)
+			assert.Equal(t, service.StateCrashed, event.State)
+			assert.Equal(t, "crash", event.Error)
+			break
+		}
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestControlRejectsUnknownUsers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+	logger := log.NewTestLogger(t)
+	socketPath := tempSocketPath(t)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	control, err := service.NewControlService(
+		service.ControlConfig{
+			SocketPath:  socketPath,
+			AllowedUIDs: []int{os.Getuid() + 1},
+		},
+		pool,
+		logger,
+	)
+	assert.NoError(t, err)
+	pool.Add(control)
+	poolLifecycle, result := startPool(t, pool)
+
+	_, err = service.NewControlClient(socketPath).List(context.Background())
+	var controlErr *service.ControlError
+	assert.True(t, errors.As(err, &controlErr))
+
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestControlSocketPath(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	dir, err := ioutil.TempDir("", "service-control-")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// Regular files must never be removed.
+	filePath := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte("test"), 0600))
+	control, err := service.NewControlService(service.ControlConfig{SocketPath: filePath}, nil, logger)
+	assert.NoError(t, err)
+	assert.Error(t, service.NewLifecycle(control).Run())
+	_, err = os.Stat(filePath)
+	assert.NoError(t, err)
+
+	// Sockets another process is listening on must not be taken over.
+	socketPath := filepath.Join(dir, "control.sock")
+	listener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	control, err = service.NewControlService(service.ControlConfig{SocketPath: socketPath}, nil, logger)
+	assert.NoError(t, err)
+	assert.Error(t, service.NewLifecycle(control).Run())
+	_, err = os.Stat(socketPath)
+	assert.NoError(t, err)
+
+	// Sockets left behind by a previous process are replaced.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, listener.Close())
+	l := service.NewLifecycle(control)
+	result := startLifecycle(t, l)
+	stat, err := os.Stat(socketPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+
+	_, err = service.NewControlService(service.ControlConfig{}, nil, logger)
+	assert.Error(t, err)
+}
+
+func tempSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "service-control-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, "control.sock")
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDrainDeadline is the reason work units are canceled with when the shutdown context expires before they finish.
+var ErrDrainDeadline = errors.New("the shutdown deadline was reached before the work finished")
+
+// DrainError is returned by Lifecycle.Drain() when work units had to be canceled because the shutdown context expired.
+type DrainError struct {
+	// Service is the name of the service that was draining.
+	Service string
+	// Canceled is the number of work units that were canceled.
+	Canceled int
+}
+
+// Error returns the error message.
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("%s canceled %d unfinished work units at the shutdown deadline", e.Service, e.Canceled)
+}
+
+// Unwrap returns ErrDrainDeadline.
+func (e *DrainError) Unwrap() error {
+	return ErrDrainDeadline
+}
+
+// WorkUnit is an in-flight unit of work, such as a connection or a session, registered with Lifecycle.Track().
+type WorkUnit interface {
+	// Context returns a context that is canceled when the unit is canceled at the end of a drain.
+	Context() context.Context
+	// Reason returns the reason the unit was canceled with, or nil if it has not been canceled.
+	Reason() error
+	// Finish marks the work as done. It must be called when the work ends, even if the unit has been canceled.
+	//        Calling it more than once has no effect.
+	Finish()
+}
+
+// drainTracker keeps track of the in-flight work units of a lifecycle.
+type drainTracker struct {
+	mutex *sync.Mutex
+	units map[*workUnit]struct{}
+	// idle is closed while no units are active and replaced when the first unit is added.
+	idle chan struct{}
+}
+
+func newDrainTracker() *drainTracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &drainTracker{
+		mutex: &sync.Mutex{},
+		units: map[*workUnit]struct{}{},
+		idle:  idle,
+	}
+}
+
+func (d *drainTracker) track(cancel func(reason error)) *workUnit {
+	ctx, cancelContext := context.WithCancel(context.Background())
+	unit := &workUnit{
+		tracker:       d,
+		ctx:           ctx,
+		cancelContext: cancelContext,
+		cancel:        cancel,
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.units) == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.units[unit] = struct{}{}
+	return unit
+}
+
+func (d *drainTracker) remove(unit *workUnit) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.units[unit]; !ok {
+		return
+	}
+	delete(d.units, unit)
+	if len(d.units) == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *drainTracker) count() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.units)
+}
+
+// wait waits until no units are active or the context ends. It returns true if no units are active.
+func (d *drainTracker) wait(ctx context.Context) bool {
+	d.mutex.Lock()
+	idle := d.idle
+	d.mutex.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return d.count() == 0
+	}
+}
+
+// cancelAll cancels all active units with the specified reason and returns the number of canceled units.
+func (d *drainTracker) cancelAll(reason error) int {
+	d.mutex.Lock()
+	units := make([]*workUnit, 0, len(d.units))
+	for unit := range d.units {
+		units = append(units, unit)
+	}
+	d.mutex.Unlock()
+	canceled := 0
+	for _, unit := range units {
+		if unit.cancelWith(reason) {
+			canceled++
+		}
+	}
+	return canceled
+}
+
+type workUnit struct {
+	tracker       *drainTracker
+	ctx           context.Context
+	cancelContext func()
+	cancel        func(reason error)
+	mutex         sync.Mutex
+	reason        error
+	finished      bool
+}
+
+func (w *workUnit) Context() context.Context {
+	return w.ctx
+}
+
+func (w *workUnit) Reason() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.reason
+}
+
+func (w *workUnit) Finish() {
+	w.mutex.Lock()
+	w.finished = true
+	w.mutex.Unlock()
+	w.cancelContext()
+	w.tracker.remove(w)
+}
+
+// cancelWith cancels the unit unless it has already finished or been canceled. It returns true if it canceled the
+// unit.
+func (w *workUnit) cancelWith(reason error) bool {
+	w.mutex.Lock()
+	if w.finished || w.reason != nil {
+		w.mutex.Unlock()
+		return false
+	}
+	w.reason = reason
+	w.mutex.Unlock()
+	w.cancelContext()
+	if w.cancel != nil {
+		w.cancel(reason)
+	}
+	return true
+}
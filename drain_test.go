@@ -0,0 +1,134 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func TestDrain(t *testing.T) {
+	drainResult := make(chan error, 1)
+	l := service.NewLifecycle(newDrainingService(drainResult))
+
+	unit1 := l.Track(nil)
+	unit2 := l.Track(nil)
+	assert.Equal(t, 2, l.ActiveWork())
+	unit1.Finish()
+	unit1.Finish()
+	assert.Equal(t, 1, l.ActiveWork())
+	assert.NoError(t, unit1.Reason())
+
+	result := startLifecycle(t, l)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		unit2.Finish()
+	}()
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.NoError(t, <-drainResult)
+	assert.Equal(t, 0, l.ActiveWork())
+	assert.NoError(t, unit2.Reason())
+}
+
+func TestDrainDeadline(t *testing.T) {
+	drainResult := make(chan error, 1)
+	l, err := service.NewLifecycleWithConfig(newDrainingService(drainResult), service.LifecycleConfig{
+		Logger: log.NewTestLogger(t),
+	})
+	assert.NoError(t, err)
+
+	var lock sync.Mutex
+	var reasons []error
+	unit := l.Track(func(reason error) {
+		lock.Lock()
+		defer lock.Unlock()
+		reasons = append(reasons, reason)
+	})
+	finished := l.Track(nil)
+	finished.Finish()
+
+	result := startLifecycle(t, l)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	l.Stop(ctx)
+	assert.NoError(t, <-result)
+
+	err = <-drainResult
+	var drainErr *service.DrainError
+	if assert.True(t, errors.As(err, &drainErr)) {
+		assert.Equal(t, 1, drainErr.Canceled)
+	}
+	assert.True(t, errors.Is(err, service.ErrDrainDeadline))
+	assert.Equal(t, service.ErrDrainDeadline, unit.Reason())
+	assert.Equal(t, []error{service.ErrDrainDeadline}, reasons)
+	assert.Error(t, unit.Context().Err())
+	assert.NoError(t, finished.Reason())
+
+	// Canceled units remain active until they are finished.
+	assert.Equal(t, 1, l.ActiveWork())
+	unit.Finish()
+	assert.Equal(t, 0, l.ActiveWork())
+}
+
+func TestDrainOnStopping(t *testing.T) {
+	stoppingReturned := make(chan int, 1)
+	l, err := service.NewLifecycleWithConfig(
+		newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			<-lifecycle.Context().Done()
+			lifecycle.Stopping()
+			stoppingReturned <- lifecycle.ActiveWork()
+			return nil
+		}),
+		service.LifecycleConfig{
+			DrainOnStopping: true,
+			ShutdownTimeout: 10 * time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	unit := l.Track(nil)
+	result := startLifecycle(t, l)
+	go func() {
+		_, _ = l.WaitForState(context.Background(), service.StateStopping)
+		time.Sleep(50 * time.Millisecond)
+		unit.Finish()
+	}()
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.Equal(t, 0, <-stoppingReturned, "Stopping() returned before the work was drained")
+}
+
+// newDrainingService creates a service that drains its lifecycle after calling Stopping() and reports the result.
+func newDrainingService(drainResult chan<- error) service.Service {
+	return newCallbackService("Draining service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		drainResult <- lifecycle.Drain()
+		return nil
+	})
+}
+
+// startLifecycle runs the lifecycle and waits for it to enter the "running" state.
+func startLifecycle(t *testing.T, l service.Lifecycle) <-chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Run()
+	}()
+	select {
+	case <-l.Ready():
+	case err := <-result:
+		t.Fatalf("the service exited during startup (%v)", err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout while waiting for the service to start")
+	}
+	return result
+}
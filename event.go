@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType describes what kind of lifecycle event has happened.
+type EventType string
+
+const (
+	// EventTypeStateChange is sent when the service has entered a new state. The State field contains the new state,
+	// the Error field the crash error when the new state is StateCrashed.
+	EventTypeStateChange EventType = "stateChange"
+	// EventTypeHookError is sent when a hook has failed. The Error field contains the HookError.
+	EventTypeHookError EventType = "hookError"
+	// EventTypeRestart is sent when a lifecycle that has already run before is started again.
+	EventTypeRestart EventType = "restart"
+	// EventTypeHealthChange is sent when the service starts or stops being ready to serve user requests. The Healthy
+	// field contains the new health.
+	EventTypeHealthChange EventType = "healthChange"
+)
+
+// Event is a lifecycle event delivered to subscribers.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+	// Service is the service the event happened to.
+	Service Service
+	// Lifecycle is the lifecycle of the service.
+	Lifecycle Lifecycle
+	// Time is the time the event happened at.
+	Time time.Time
+	// State is the state of the service after the event.
+	State State
+	// Healthy indicates if the service is ready to serve user requests after the event.
+	Healthy bool
+	// Generation is the run generation of the lifecycle at the time of the event.
+	Generation int
+	// Error is the error associated with the event, if any.
+	Error error
+}
+
+// EventPolicy determines what happens when a subscriber does not consume events fast enough and its buffer is full.
+type EventPolicy string
+
+const (
+	// EventPolicyDrop discards new events for a subscriber whose buffer is full. The lifecycle is never slowed down
+	// by a subscriber. This is the default.
+	EventPolicyDrop EventPolicy = "drop"
+	// EventPolicyBlock waits until the subscriber has room in its buffer, or until its context ends. A slow
+	// subscriber delays the lifecycle, including state changes of the service.
+	EventPolicyBlock EventPolicy = "block"
+)
+
+// Validate checks if the event policy is one of the supported values.
+func (p EventPolicy) Validate() error {
+	switch p {
+	case "", EventPolicyDrop, EventPolicyBlock:
+		return nil
+	default:
+		return fmt.Errorf("invalid event policy: %s", p)
+	}
+}
+
+// SubscribeOptions configures a single subscription to the events of a pool.
+type SubscribeOptions struct {
+	// BufferSize is the number of events buffered for the subscriber. Defaults to the buffer size of the pool.
+	BufferSize int
+	// Policy determines what happens to events when the buffer of the subscriber is full. Defaults to the policy of
+	// the pool.
+	Policy EventPolicy
+}
+
+// defaultEventBufferSize is the number of events buffered per subscriber if no buffer size is configured.
+const defaultEventBufferSize = 16
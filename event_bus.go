@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// eventSource is implemented by the lifecycles and pools of this package. It allows pools to receive the events of
+// their children synchronously, without a goroutine and a buffer per child.
+type eventSource interface {
+	addEventListener(listener func(event Event))
+}
+
+// eventBus distributes events to channel subscribers and synchronous listeners.
+type eventBus struct {
+	lock        sync.Mutex
+	bufferSize  int
+	policy      EventPolicy
+	subscribers map[*subscription]struct{}
+	listeners   []func(event Event)
+}
+
+func newEventBus(bufferSize int, policy EventPolicy) *eventBus {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventBufferSize
+	}
+	if policy == "" {
+		policy = EventPolicyDrop
+	}
+	return &eventBus{
+		bufferSize:  bufferSize,
+		policy:      policy,
+		subscribers: map[*subscription]struct{}{},
+	}
+}
+
+// subscribe returns a channel that receives all events until the context ends, at which point the channel is closed.
+func (b *eventBus) subscribe(ctx context.Context) <-chan Event {
+	return b.subscribeWithOptions(ctx, 0, "")
+}
+
+// subscribeWithOptions works like subscribe with a buffer size and policy of its own. Zero values fall back to the
+// settings of the bus.
+func (b *eventBus) subscribeWithOptions(ctx context.Context, bufferSize int, policy EventPolicy) <-chan Event {
+	if bufferSize <= 0 {
+		bufferSize = b.bufferSize
+	}
+	if policy == "" {
+		policy = b.policy
+	}
+	sub := &subscription{
+		ctx:    ctx,
+		policy: policy,
+		c:      make(chan Event, bufferSize),
+	}
+	b.lock.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.lock.Lock()
+		delete(b.subscribers, sub)
+		b.lock.Unlock()
+		sub.close()
+	}()
+	return sub.c
+}
+
+func (b *eventBus) addEventListener(listener func(event Event)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// publish delivers the event to all listeners and subscribers. It must not be called with any lock held that a
+// subscriber might need, since the block policy may wait for the subscriber.
+func (b *eventBus) publish(event Event) {
+	b.lock.Lock()
+	listeners := b.listeners
+	subscribers := make([]*subscription, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	b.lock.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+	for _, sub := range subscribers {
+		sub.send(event)
+	}
+}
+
+type subscription struct {
+	ctx    context.Context
+	policy EventPolicy
+	lock   sync.Mutex
+	closed bool
+	c      chan Event
+}
+
+func (s *subscription) send(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if s.policy == EventPolicyBlock {
+		select {
+		case s.c <- event:
+		case <-s.ctx.Done():
+		}
+		return
+	}
+	select {
+	case s.c <- event:
+	default:
+	}
+}
+
+func (s *subscription) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	close(s.c)
+}
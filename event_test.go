@@ -0,0 +1,296 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func TestSubscribe(t *testing.T) {
+	s := newTestService("Test service")
+	l := service.NewLifecycle(s)
+	hookErr := errors.New("hook failed")
+	l.AddHook(service.Hook{
+		Name: "failing",
+		OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+			return hookErr
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Subscribe(ctx)
+
+	running := make(chan struct{})
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		close(running)
+	})
+	result := make(chan error)
+	go func() {
+		result <- l.Run()
+	}()
+	<-running
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+
+	received := readEvents(t, events, 7)
+	assert.Equal(t, []service.EventType{
+		service.EventTypeStateChange,
+		service.EventTypeStateChange,
+		service.EventTypeHealthChange,
+		service.EventTypeStateChange,
+		service.EventTypeHealthChange,
+		service.EventTypeHookError,
+		service.EventTypeStateChange,
+	}, eventTypes(received))
+	assert.Equal(t, service.StateStarting, received[0].State)
+	assert.Equal(t, service.StateRunning, received[1].State)
+	assert.True(t, received[2].Healthy)
+	assert.Equal(t, service.StateStopping, received[3].State)
+	assert.False(t, received[4].Healthy)
+	assert.True(t, errors.Is(received[5].Error, hookErr))
+	assert.Equal(t, service.StateStopped, received[6].State)
+	for _, event := range received {
+		assert.Equal(t, service.Service(s), event.Service)
+		assert.Equal(t, l, event.Lifecycle)
+		assert.False(t, event.Time.IsZero())
+	}
+}
+
+func TestSubscribeCrashAndRestart(t *testing.T) {
+	crash := errors.New("crash")
+	shouldCrash := true
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		if shouldCrash {
+			return crash
+		}
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Subscribe(ctx)
+
+	assert.Equal(t, crash, l.Run())
+	shouldCrash = false
+	assert.NoError(t, l.Run())
+
+	received := readEvents(t, events, 5)
+	assert.Equal(t, []service.EventType{
+		service.EventTypeStateChange,
+		service.EventTypeStateChange,
+		service.EventTypeRestart,
+		service.EventTypeStateChange,
+		service.EventTypeStateChange,
+	}, eventTypes(received))
+	assert.Equal(t, service.StateCrashed, received[1].State)
+	assert.Equal(t, crash, received[1].Error)
+	assert.Equal(t, service.StateStopped, received[4].State)
+	assert.NoError(t, received[4].Error)
+}
+
+func TestSubscribeContextEnd(t *testing.T) {
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	events := l.Subscribe(ctx)
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the event channel was not closed after the context ended")
+	}
+	// Publishing to an ended subscription must not panic.
+	assert.NoError(t, l.Run())
+}
+
+func TestSubscribeDropPolicy(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(
+		newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			return nil
+		}),
+		service.LifecycleConfig{
+			EventBufferSize: 1,
+			EventPolicy:     service.EventPolicyDrop,
+		},
+	)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Subscribe(ctx)
+
+	assert.NoError(t, l.Run())
+	event := <-events
+	assert.Equal(t, service.EventTypeStateChange, event.Type)
+	assert.Equal(t, service.StateStarting, event.State)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event in the buffer: %v", event)
+	default:
+	}
+}
+
+func TestSubscribeBlockPolicy(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(
+		newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			return nil
+		}),
+		service.LifecycleConfig{
+			EventBufferSize: 1,
+			EventPolicy:     service.EventPolicyBlock,
+		},
+	)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Subscribe(ctx)
+
+	result := make(chan error)
+	go func() {
+		result <- l.Run()
+	}()
+	select {
+	case <-result:
+		t.Fatalf("the lifecycle did not wait for the subscriber")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	received := readEvents(t, events, 5)
+	assert.NoError(t, <-result)
+	assert.Equal(t, []service.EventType{
+		service.EventTypeStateChange,
+		service.EventTypeStateChange,
+		service.EventTypeHealthChange,
+		service.EventTypeStateChange,
+		service.EventTypeHealthChange,
+	}, eventTypes(received))
+}
+
+func TestEventConfig(t *testing.T) {
+	_, err := service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{
+		EventPolicy: "invalid",
+	})
+	assert.Error(t, err)
+	_, err = service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{
+		EventBufferSize: -1,
+	})
+	assert.Error(t, err)
+	_, err = service.NewPoolWithConfig(service.PoolConfig{
+		EventPolicy: "invalid",
+	}, service.NewLifecycleFactory(), log.NewTestLogger(t))
+	assert.Error(t, err)
+}
+
+func TestPoolSubscribe(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{EventBufferSize: 64},
+		service.NewLifecycleFactory(),
+		logger,
+	)
+	assert.NoError(t, err)
+	s1 := newTestService("Test service 1")
+	pool.Add(s1)
+	nested := service.NewPool(service.NewLifecycleFactory(), logger)
+	s2 := newTestService("Test service 2")
+	nested.Add(s2)
+	pool.Add(nested)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pool.Subscribe(ctx)
+
+	poolLifecycle := service.NewLifecycle(pool)
+	poolStarted := make(chan struct{})
+	poolLifecycle.OnRunning(func(s service.Service, l service.Lifecycle) {
+		close(poolStarted)
+	})
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	<-poolStarted
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+
+	states := map[service.Service][]service.State{}
+	for _, event := range readEvents(t, events, 18) {
+		if event.Type == service.EventTypeStateChange {
+			states[event.Service] = append(states[event.Service], event.State)
+		}
+	}
+	expected := []service.State{
+		service.StateStarting,
+		service.StateRunning,
+		service.StateStopping,
+		service.StateStopped,
+	}
+	assert.Equal(t, expected, states[s1])
+	assert.Equal(t, expected, states[s2])
+	assert.Equal(t, expected, states[nested])
+}
+
+func TestPoolSubscribeWithOptions(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	const services = 10
+	for i := 0; i < services; i++ {
+		pool.Add(newTestService("Test service"))
+	}
+
+	// A slow subscriber with a tiny buffer still receives every event, since it overrides the drop policy of the pool.
+	ctx, cancel := context.WithCancel(context.Background())
+	events := pool.SubscribeWithOptions(ctx, service.SubscribeOptions{BufferSize: 1, Policy: service.EventPolicyBlock})
+	stateChanges := make(chan int)
+	go func() {
+		count := 0
+		for event := range events {
+			if event.Type == service.EventTypeStateChange {
+				count++
+			}
+			time.Sleep(time.Millisecond)
+		}
+		stateChanges <- count
+	}()
+
+	poolLifecycle := service.NewLifecycle(pool)
+	result := startLifecycle(t, poolLifecycle)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	cancel()
+	assert.Equal(t, services*4, <-stateChanges)
+
+	assert.Panics(t, func() {
+		pool.SubscribeWithOptions(context.Background(), service.SubscribeOptions{Policy: "explode"})
+	})
+}
+
+func readEvents(t *testing.T, events <-chan service.Event, count int) []service.Event {
+	t.Helper()
+	var result []service.Event
+	for len(result) < count {
+		select {
+		case event := <-events:
+			result = append(result, event)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout while waiting for events, received: %v", eventTypes(result))
+		}
+	}
+	return result
+}
+
+func eventTypes(events []service.Event) []service.EventType {
+	result := make([]service.EventType, len(events))
+	for i, event := range events {
+		result[i] = event.Type
+	}
+	return result
+}
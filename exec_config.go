@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ExecConfig holds the settings for a service that runs an external process. If no readiness condition is set, the
+// service is running as soon as the process has started. If multiple conditions are set, all of them must be met.
+type ExecConfig struct {
+	// Name is the name of the service. Defaults to the program name.
+	Name string `yaml:"name"`
+
+	// Command is the program to run followed by its arguments. Required.
+	Command []string `yaml:"command"`
+
+	// Dir is the working directory of the process. Defaults to the working directory of this process.
+	Dir string `yaml:"dir"`
+
+	// Env is the environment of the process in the "key=value" format. Defaults to the environment of this process.
+	Env []string `yaml:"env"`
+
+	// ReadyLogPattern is a regular expression matched against each line the process writes to its standard output or
+	// standard error. The service is running once a line matches.
+	ReadyLogPattern string `yaml:"readyLogPattern"`
+
+	// ReadyTCPAddress is a TCP address such as "127.0.0.1:8080". The service is running once a connection to the
+	// address can be opened.
+	ReadyTCPAddress string `yaml:"readyTCPAddress"`
+
+	// ReadyFile is the path of a file. The service is running once the file exists.
+	ReadyFile string `yaml:"readyFile"`
+
+	// ReadyCheckInterval is the time between two checks of the TCP address and the file. Defaults to 100ms.
+	ReadyCheckInterval time.Duration `yaml:"readyCheckInterval"`
+
+	// Clock is used for the readiness checks and the wait for a killed process. Defaults to the system clock.
+	Clock Clock `yaml:"-"`
+}
+
+// Validate checks the exec configuration for errors.
+func (c *ExecConfig) Validate() error {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("no command provided")
+	}
+	if c.ReadyLogPattern != "" {
+		if _, err := regexp.Compile(c.ReadyLogPattern); err != nil {
+			return fmt.Errorf("invalid ready log pattern (%w)", err)
+		}
+	}
+	if c.ReadyCheckInterval < 0 {
+		return fmt.Errorf("the ready check interval must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+
+	"github.com/containerssh/log"
+)
+
+// maxOutputLineLength is the longest line of process output logged as a single message. Longer lines are split.
+const maxOutputLineLength = 64 * 1024
+
+// readySignal is closed once, when the first line of output matches the ready pattern.
+type readySignal struct {
+	once *sync.Once
+	c    chan struct{}
+}
+
+func newReadySignal() readySignal {
+	return readySignal{
+		once: &sync.Once{},
+		c:    make(chan struct{}),
+	}
+}
+
+func (r readySignal) signal() {
+	r.once.Do(func() {
+		close(r.c)
+	})
+}
+
+// outputWriter logs the output of a process line by line and signals when a line matches the ready pattern.
+type outputWriter struct {
+	logger  log.Logger
+	service string
+	stream  string
+	pattern *regexp.Regexp
+	ready   readySignal
+	mutex   *sync.Mutex
+	buffer  []byte
+}
+
+func newOutputWriter(
+	logger log.Logger,
+	service string,
+	stream string,
+	pattern *regexp.Regexp,
+	ready readySignal,
+) *outputWriter {
+	return &outputWriter{
+		logger:  logger,
+		service: service,
+		stream:  stream,
+		pattern: pattern,
+		ready:   ready,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.buffer = append(o.buffer, p...)
+	for {
+		i := bytes.IndexByte(o.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		o.line(o.buffer[:i])
+		o.buffer = o.buffer[i+1:]
+	}
+	for len(o.buffer) >= maxOutputLineLength {
+		o.line(o.buffer[:maxOutputLineLength])
+		o.buffer = o.buffer[maxOutputLineLength:]
+	}
+	return len(p), nil
+}
+
+// flush logs the last line if the output did not end with a newline.
+func (o *outputWriter) flush() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if len(o.buffer) > 0 {
+		o.line(o.buffer)
+		o.buffer = nil
+	}
+}
+
+// line logs a single line of output. It must be called with the mutex held.
+func (o *outputWriter) line(line []byte) {
+	text := string(bytes.TrimSuffix(line, []byte("\r")))
+	o.logger.Info(
+		log.NewMessage(
+			MServiceExecOutput,
+			"%s",
+			text,
+		).Label("service", o.service).Label("stream", o.stream),
+	)
+	if o.pattern != nil && o.pattern.MatchString(text) {
+		o.ready.signal()
+	}
+}
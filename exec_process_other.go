@@ -0,0 +1,23 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"os"
+	"os/exec"
+)
+
+// configureProcess does nothing, process groups are not supported on this platform.
+func configureProcess(_ *exec.Cmd) {
+}
+
+// terminateProcess asks the process to exit. This fails on platforms without signals.
+func terminateProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(os.Interrupt)
+}
+
+// killProcess kills the process.
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
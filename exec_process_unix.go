@@ -0,0 +1,24 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess starts the process in its own process group, so the processes it starts can be signaled with it.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcess sends a SIGTERM to the process group of the process.
+func terminateProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// killProcess kills the process group of the process.
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
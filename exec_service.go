@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+const defaultReadyCheckInterval = 100 * time.Millisecond
+
+// killTimeout is the time the service waits for a killed process to exit and close its output.
+const killTimeout = 5 * time.Second
+
+// ExecExitError is the error an exec service crashes with when its process exits with a non-zero exit code or is
+// killed by a signal it was not sent by the service.
+type ExecExitError struct {
+	// Service is the name of the service.
+	Service string
+	// ExitCode is the exit code of the process, or -1 if it was killed by a signal.
+	ExitCode int
+	// Signal is the name of the signal that killed the process, if any.
+	Signal string
+	// Cause is the error returned by the process.
+	Cause error
+}
+
+// Error returns the error message.
+func (e *ExecExitError) Error() string {
+	if e.Signal != "" {
+		return fmt.Sprintf("the process of %s was killed by signal %s", e.Service, e.Signal)
+	}
+	return fmt.Sprintf("the process of %s exited with code %d", e.Service, e.ExitCode)
+}
+
+// Unwrap returns the error returned by the process.
+func (e *ExecExitError) Unwrap() error {
+	return e.Cause
+}
+
+// ExecKilledError is the error an exec service crashes with when its process did not exit before the shutdown deadline
+// and had to be killed.
+type ExecKilledError struct {
+	// Service is the name of the service.
+	Service string
+	// Cause is the error returned by the killed process.
+	Cause error
+}
+
+// Error returns the error message.
+func (e *ExecKilledError) Error() string {
+	return fmt.Sprintf("the process of %s did not exit before the shutdown deadline and was killed", e.Service)
+}
+
+// Unwrap returns the error returned by the killed process.
+func (e *ExecKilledError) Unwrap() error {
+	return e.Cause
+}
+
+// NewExecService creates a service that runs an external process. The output of the process is logged line by line
+// with the SERVICE_EXEC_OUTPUT code. The service enters the "running" state once the readiness conditions in the
+// configuration are met.
+//
+// The process is started in its own process group. When stopped, the process group receives a SIGTERM, or the process
+// is killed on platforms without signals. If it does not exit by the time the shutdown context expires, the process
+// group is killed and the service crashes with an *ExecKilledError. A process exiting on its own with a non-zero exit
+// code crashes the service with an *ExecExitError. The configuration is validated and an error is returned if it is
+// invalid.
+func NewExecService(config ExecConfig, logger log.Logger) (Service, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Name == "" {
+		config.Name = filepath.Base(config.Command[0])
+	}
+	if config.ReadyCheckInterval == 0 {
+		config.ReadyCheckInterval = defaultReadyCheckInterval
+	}
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
+	var pattern *regexp.Regexp
+	if config.ReadyLogPattern != "" {
+		pattern = regexp.MustCompile(config.ReadyLogPattern)
+	}
+	return &execService{
+		config:  config,
+		pattern: pattern,
+		logger:  logger,
+	}, nil
+}
+
+type execService struct {
+	config  ExecConfig
+	pattern *regexp.Regexp
+	logger  log.Logger
+}
+
+func (e *execService) String() string {
+	return e.config.Name
+}
+
+func (e *execService) RunWithLifecycle(lifecycle Lifecycle) error {
+	ctx := lifecycle.Context()
+	logMatched := newReadySignal()
+	stdout := newOutputWriter(e.logger, e.config.Name, "stdout", e.pattern, logMatched)
+	stderr := newOutputWriter(e.logger, e.config.Name, "stderr", e.pattern, logMatched)
+	defer func() {
+		stdout.flush()
+		stderr.flush()
+	}()
+
+	cmd := exec.Command(e.config.Command[0], e.config.Command[1:]...)
+	cmd.Dir = e.config.Dir
+	cmd.Env = e.config.Env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	configureProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	if e.waitReady(ctx, logMatched.c, exited) {
+		lifecycle.Running()
+		select {
+		case err := <-exited:
+			return e.exitError(err)
+		case <-ctx.Done():
+		}
+	} else {
+		select {
+		case err := <-exited:
+			return e.exitError(err)
+		default:
+		}
+	}
+
+	shutdownContext := lifecycle.Stopping()
+	if err := terminateProcess(cmd); err != nil {
+		_ = killProcess(cmd)
+	}
+	select {
+	case err := <-exited:
+		return e.stoppedExitError(err)
+	case <-shutdownContext.Done():
+	}
+	e.logger.Warning(
+		log.NewMessage(
+			EServiceExecKilled,
+			"The process of %s did not exit before the shutdown deadline, killing it.",
+			e.config.Name,
+		).Label("service", e.config.Name),
+	)
+	_ = killProcess(cmd)
+	// Processes that left the process group may still hold the output open, which keeps Wait() from returning.
+	timer := e.config.Clock.NewTimer(killTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-exited:
+		return &ExecKilledError{Service: e.config.Name, Cause: err}
+	case <-timer.C():
+		return &ExecKilledError{Service: e.config.Name}
+	}
+}
+
+// waitReady waits until all readiness conditions are met. It returns false if the process exits or the context ends
+// first. In the former case the exit result is put back into the exited channel.
+func (e *execService) waitReady(ctx context.Context, logMatched <-chan struct{}, exited chan error) bool {
+	if e.pattern != nil {
+		select {
+		case <-logMatched:
+		case err := <-exited:
+			exited <- err
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if e.config.ReadyTCPAddress == "" && e.config.ReadyFile == "" {
+		return true
+	}
+	ticker := e.config.Clock.NewTicker(e.config.ReadyCheckInterval)
+	defer ticker.Stop()
+	for {
+		if e.checkReady() {
+			return true
+		}
+		select {
+		case <-ticker.C():
+		case err := <-exited:
+			exited <- err
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// checkReady checks the polled readiness conditions.
+func (e *execService) checkReady() bool {
+	if e.config.ReadyFile != "" {
+		if _, err := os.Stat(e.config.ReadyFile); err != nil {
+			return false
+		}
+	}
+	if e.config.ReadyTCPAddress != "" {
+		conn, err := net.DialTimeout("tcp", e.config.ReadyTCPAddress, e.config.ReadyCheckInterval)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+	}
+	return true
+}
+
+// exitError converts the result of a process that exited on its own.
+func (e *execService) exitError(err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	result := &ExecExitError{
+		Service:  e.config.Name,
+		ExitCode: exitErr.ExitCode(),
+		Cause:    err,
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal().String()
+	}
+	return result
+}
+
+// stoppedExitError converts the result of a process that was stopped by the service. Being killed by a signal is the
+// expected outcome, so only non-zero exit codes are reported.
+func (e *execService) stoppedExitError(err error) error {
+	err = e.exitError(err)
+	var exitErr *ExecExitError
+	if errors.As(err, &exitErr) && exitErr.Signal != "" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func TestExecServiceLogReadiness(t *testing.T) {
+	skipWithoutShell(t)
+	logger, output := newRecordingLogger(t)
+	script := "echo starting; echo warming up >&2; sleep 0.1; echo ready; exec sleep 60"
+	s, err := service.NewExecService(service.ExecConfig{
+		Name:            "Sidecar",
+		Command:         []string{"sh", "-c", script},
+		ReadyLogPattern: "^ready$",
+	}, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sidecar", s.String())
+
+	l := service.NewLifecycle(s)
+	result := startLifecycle(t, l)
+	assert.Contains(t, output.String(), `"ready"`)
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.Equal(t, service.StateStopped, l.State())
+
+	logs := output.String()
+	assert.Contains(t, logs, `"code":"SERVICE_EXEC_OUTPUT"`)
+	assert.Contains(t, logs, `"message":"starting"`)
+	assert.Contains(t, logs, `"message":"warming up"`)
+	assert.Contains(t, logs, `"stream":"stderr"`)
+	assert.Contains(t, logs, `"service":"Sidecar"`)
+}
+
+func TestExecServiceExitCode(t *testing.T) {
+	skipWithoutShell(t)
+	s, err := service.NewExecService(service.ExecConfig{
+		Command: []string{"sh", "-c", "exit 3"},
+	}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	assert.Equal(t, "sh", s.String())
+
+	err = service.NewLifecycle(s).Run()
+	var exitErr *service.ExecExitError
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, 3, exitErr.ExitCode)
+		assert.Equal(t, "sh", exitErr.Service)
+	}
+
+	// A clean exit stops the service.
+	s, err = service.NewExecService(service.ExecConfig{
+		Command: []string{"sh", "-c", "exit 0"},
+	}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	assert.NoError(t, service.NewLifecycle(s).Run())
+}
+
+func TestExecServiceExitBeforeReady(t *testing.T) {
+	skipWithoutShell(t)
+	s, err := service.NewExecService(service.ExecConfig{
+		Command:         []string{"sh", "-c", "echo failing; exit 1"},
+		ReadyLogPattern: "ready",
+	}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	l := service.NewLifecycle(s)
+	running := false
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		running = true
+	})
+	var exitErr *service.ExecExitError
+	assert.True(t, errors.As(l.Run(), &exitErr))
+	assert.False(t, running)
+}
+
+func TestExecServiceKill(t *testing.T) {
+	skipWithoutShell(t)
+	logger, output := newRecordingLogger(t)
+	s, err := service.NewExecService(service.ExecConfig{
+		Command:         []string{"sh", "-c", `trap "" TERM; echo ready; exec sleep 60`},
+		ReadyLogPattern: "ready",
+	}, logger)
+	assert.NoError(t, err)
+	l := service.NewLifecycle(s)
+	result := startLifecycle(t, l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	l.Stop(ctx)
+	err = <-result
+	var killedErr *service.ExecKilledError
+	if assert.True(t, errors.As(err, &killedErr), "unexpected error: %v", err) {
+		assert.Equal(t, "sh", killedErr.Service)
+	}
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.Contains(t, output.String(), `"code":"SERVICE_EXEC_KILLED"`)
+}
+
+func TestExecServiceStopsProcessGroup(t *testing.T) {
+	skipWithoutShell(t)
+	s, err := service.NewExecService(service.ExecConfig{
+		Command:         []string{"sh", "-c", "echo ready; sleep 60 & wait"},
+		ReadyLogPattern: "ready",
+	}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	l := service.NewLifecycle(s)
+	result := startLifecycle(t, l)
+
+	// The background sleep holds the output open, so the service only exits if it is stopped as well.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	l.Stop(ctx)
+	assert.NoError(t, <-result)
+	assert.True(t, time.Since(start) < 5*time.Second, "the service did not stop the background process")
+}
+
+func TestExecServiceFileAndTCPReadiness(t *testing.T) {
+	skipWithoutShell(t)
+	dir, err := ioutil.TempDir("", "service-exec-")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	readyFile := filepath.Join(dir, "ready")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	s, err := service.NewExecService(service.ExecConfig{
+		Command:            []string{"sh", "-c", "sleep 0.1; touch \"$READY_FILE\"; exec sleep 60"},
+		Env:                []string{"READY_FILE=" + readyFile, "PATH=" + os.Getenv("PATH")},
+		ReadyFile:          readyFile,
+		ReadyTCPAddress:    address,
+		ReadyCheckInterval: 10 * time.Millisecond,
+	}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	l := service.NewLifecycle(s)
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Run()
+	}()
+
+	// The file alone is not enough, the TCP port has to be open too.
+	_, err = os.Stat(readyFile)
+	for err != nil {
+		time.Sleep(10 * time.Millisecond)
+		_, err = os.Stat(readyFile)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, service.StateStarting, l.State())
+
+	listener, err = net.Listen("tcp", address)
+	assert.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	select {
+	case <-l.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the service did not become ready")
+	}
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestExecConfigValidation(t *testing.T) {
+	_, err := service.NewExecService(service.ExecConfig{}, log.NewTestLogger(t))
+	assert.Error(t, err)
+	_, err = service.NewExecService(service.ExecConfig{
+		Command:         []string{"true"},
+		ReadyLogPattern: "(",
+	}, log.NewTestLogger(t))
+	assert.Error(t, err)
+}
+
+func skipWithoutShell(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the exec tests require a POSIX shell")
+	}
+}
+
+// newRecordingLogger creates a logger that writes JSON lines into the returned buffer.
+func newRecordingLogger(t *testing.T) (log.Logger, *syncBuffer) {
+	t.Helper()
+	output := &syncBuffer{}
+	logger, err := log.NewLogger(log.Config{
+		Level:       log.LevelDebug,
+		Format:      log.FormatLJSON,
+		Destination: log.DestinationStdout,
+		Stdout:      output,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, output
+}
+
+// syncBuffer is a buffer that can be written and read from different goroutines.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.buffer.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.buffer.String()
+}
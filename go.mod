@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/containerssh/log v1.0.0
 	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 // Fixes CVE-2019-11254
@@ -17,4 +18,4 @@ replace (
 	gopkg.in/yaml.v2 v2.2.5 => gopkg.in/yaml.v2 v2.2.8
 	gopkg.in/yaml.v2 v2.2.6 => gopkg.in/yaml.v2 v2.2.8
 	gopkg.in/yaml.v2 v2.2.7 => gopkg.in/yaml.v2 v2.2.8
-)
\ No newline at end of file
+)
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// HookMode determines how a hook is executed relative to the other hooks in the same priority group.
+type HookMode string
+
+const (
+	// HookModeParallel runs the hook concurrently with the other parallel hooks in the same priority group. This is
+	// the default.
+	HookModeParallel HookMode = "parallel"
+	// HookModeSequential runs the hook on its own: it waits for the hooks registered before it in the same priority
+	// group to finish, and the hooks registered after it wait for it to finish.
+	HookModeSequential HookMode = "sequential"
+)
+
+// Validate checks if the hook mode is one of the supported values.
+func (m HookMode) Validate() error {
+	switch m {
+	case "", HookModeParallel, HookModeSequential:
+		return nil
+	default:
+		return fmt.Errorf("invalid hook mode: %s", m)
+	}
+}
+
+// HookType identifies the lifecycle event a hook handler is called for.
+type HookType string
+
+const (
+	// HookTypeStateChange identifies OnStateChange handlers.
+	HookTypeStateChange HookType = "stateChange"
+	// HookTypeStarting identifies OnStarting handlers.
+	HookTypeStarting HookType = "starting"
+	// HookTypeRunning identifies OnRunning handlers.
+	HookTypeRunning HookType = "running"
+	// HookTypeStopping identifies OnStopping handlers.
+	HookTypeStopping HookType = "stopping"
+	// HookTypeStopped identifies OnStopped handlers.
+	HookTypeStopped HookType = "stopped"
+	// HookTypeCrashed identifies OnCrashed handlers.
+	HookTypeCrashed HookType = "crashed"
+)
+
+// Hook is a set of handlers that are registered on a lifecycle together with their execution options. Any of the
+// handlers may be nil.
+//
+// When a lifecycle event happens, the handlers for that event are executed in groups of equal priority. Groups with a
+// lower priority run first, and a group only starts once the previous group has finished. Within a group the handlers
+// are executed according to their Mode. The On... methods of the Lifecycle register parallel hooks with priority 0.
+//
+// Handlers that panic are recovered and reported as a HookPanicError. Handlers may return an error. If an OnStarting
+// handler fails, the remaining starting hooks are skipped, the service is not started and goes into the "crashed"
+// state instead, unless the hook is marked as Optional. Errors of all other handlers are collected and reported
+// through Lifecycle.Error().
+type Hook struct {
+	// Name identifies the hook in errors and logs. Defaults to the name of the first handler function.
+	Name string
+	// Priority determines the order in which hooks are executed. Lower priorities run first.
+	Priority int
+	// Mode determines how the hook is executed within its priority group. Defaults to HookModeParallel.
+	Mode HookMode
+	// Optional prevents a failing OnStarting handler from aborting the startup. Its error is collected like the
+	// errors of the other handlers instead.
+	Optional bool
+	// Timeout is the maximum time each handler may run. If it expires the handler is abandoned and a HookTimeoutError
+	// is reported. Zero uses the HookTimeout from the lifecycle configuration, a negative value disables the timeout.
+	Timeout time.Duration
+
+	// OnStateChange is called on any state change.
+	OnStateChange func(s Service, l Lifecycle, state State) error
+	// OnStarting is called when the service is about to start.
+	OnStarting func(s Service, l Lifecycle) error
+	// OnRunning is called when the service is ready to serve user requests.
+	OnRunning func(s Service, l Lifecycle) error
+	// OnStopping is called when the service is starting to shut down.
+	OnStopping func(s Service, l Lifecycle, shutdownContext context.Context) error
+	// OnStopped is called after the service has stopped.
+	OnStopped func(s Service, l Lifecycle) error
+	// OnCrashed is called when the service exited with an error.
+	OnCrashed func(s Service, l Lifecycle, err error) error
+}
+
+// defaultName returns the name of the first handler function of the hook.
+func (h Hook) defaultName() string {
+	for _, handler := range []interface{}{
+		h.OnStateChange,
+		h.OnStarting,
+		h.OnRunning,
+		h.OnStopping,
+		h.OnStopped,
+		h.OnCrashed,
+	} {
+		if name := funcName(handler); name != "" {
+			return name
+		}
+	}
+	return "unnamed hook"
+}
+
+// funcName returns the name of the specified function, or an empty string if f is a nil function.
+func funcName(f interface{}) string {
+	value := reflect.ValueOf(f)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return ""
+	}
+	if fn := runtime.FuncForPC(value.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
@@ -42,18 +42,78 @@ type Lifecycle interface {
 	//      state it returns the error that caused the crash.
 	Wait() error
 
-	// Error returns the error that caused the service to go into the "crashed" state.
+	// WaitForState waits for the service to enter any of the specified states and returns the state it entered. If
+	//              the service is already in one of the states it returns immediately. If the context ends first it
+	//              returns the error of the context.
+	WaitForState(ctx context.Context, states ...State) (State, error)
+
+	// Ready returns a channel that is closed when the service enters the "running" state. Until then, and after the
+	//       service has exited, the returned channel belongs to the next run.
+	Ready() <-chan struct{}
+
+	// Done returns a channel that is closed when the service enters the "stopped" or "crashed" state. Before the
+	//      first run, and while the service is running, the returned channel belongs to the current or next run.
+	Done() <-chan struct{}
+
+	// Subscribe returns a channel that receives the events of this lifecycle, such as state changes and hook errors.
+	//           The subscription ends and the channel is closed when the context ends. What happens when the
+	//           subscriber does not keep up with the events depends on the event policy of the lifecycle.
+	Subscribe(ctx context.Context) <-chan Event
+
+	// Generation returns the number of the current or last run, starting with 1 for the first run. It returns 0 if
+	//            the lifecycle has not been run yet. Hooks can use it to tell runs of a restarted service apart.
+	Generation() int
+
+	// Error returns the error that caused the service to go into the "crashed" state. If hooks or cleanups have failed
+	//       during the last run, it returns an ErrorList containing the crash error, if any, followed by the hook
+	//       and cleanup errors.
 	Error() error
 
 	// endregion
 
+	// region Tasks and cleanups
+
+	// Go runs a task in a new goroutine owned by the current run of the service. The context passed to the task is
+	//    canceled when the service is stopped or exits. The lifecycle only enters the "stopped" or "crashed" state
+	//    once all tasks have exited. If a task fails or panics, the service is stopped and crashes with a *TaskError.
+	//    Tasks still running at the shutdown deadline after the service has exited crash it with a *TaskLeakError
+	//    listing their names. Must not be called after the service has exited, not even by the tasks that are still
+	//    running.
+	Go(name string, task func(ctx context.Context) error)
+
+	// Defer registers a cleanup, such as closing a listener or removing a temporary directory, for the current run
+	//       of the service. Cleanups run in reverse order of registration after the service has exited, also if
+	//       it crashed or panicked, and receive the shutdown context. Failed cleanups are reported through Error()
+	//       as *CleanupError. Must not be called after the service has exited.
+	Defer(name string, cleanup func(ctx context.Context) error)
+
+	// endregion
+
+	// region Draining
+
+	// Track registers an in-flight unit of work, such as a connection or a session, that should finish before the
+	//       service stops. The cancel function, if not nil, is called with the reason when the unit is canceled at
+	//       the end of a drain. The returned WorkUnit must be finished when the work ends.
+	Track(cancel func(reason error)) WorkUnit
+
+	// ActiveWork returns the number of tracked work units that have not finished yet.
+	ActiveWork() int
+
+	// Drain waits until all tracked work units have finished or the shutdown context expires. In the latter case it
+	//       cancels the remaining units with ErrDrainDeadline and returns a *DrainError. It should be called after
+	//       Stopping().
+	Drain() error
+
+	// endregion
+
 	// region Triggers
 
 	// Stop triggers a shutdown of the Service by setting the context to expire. A shutdownContext provides a
-	//      deadline for gracefully terminating existing processes.
+	//      deadline for gracefully terminating existing processes. Stop has no effect if the service is not running.
 	Stop(shutdownContext context.Context)
 
-	// Run runs the associated service and returns when complete.
+	// Run runs the associated service and returns when complete. A lifecycle can be run again after the service has
+	//     stopped or crashed. Each run gets fresh contexts and starts without errors.
 	Run() error
 
 	// endregion
@@ -70,6 +130,11 @@ type Lifecycle interface {
 
 	// region Hook setup
 
+	// AddHook registers a set of hook handlers with a priority and an execution mode. Hooks with a lower priority are
+	//         executed first, hooks with the same priority are executed according to their mode. Must be called before
+	//         Run.
+	AddHook(hook Hook) Lifecycle
+
 	// OnStateChange adds a function handler to be called on any state change.
 	OnStateChange(func(s Service, l Lifecycle, state State)) Lifecycle
 
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerssh/log"
+)
+
+// CleanupError is the error recorded when a cleanup registered with Lifecycle.Defer() fails. Cleanup errors are
+// reported through Lifecycle.Error() and do not change the final state of the service.
+type CleanupError struct {
+	// Service is the name of the service that registered the cleanup.
+	Service string
+	// Cleanup is the name of the failed cleanup.
+	Cleanup string
+	// Cause is the error returned by the cleanup, or a *CleanupPanicError if it panicked.
+	Cause error
+}
+
+// Error returns the error message.
+func (e *CleanupError) Error() string {
+	return fmt.Sprintf("cleanup %s of %s failed (%v)", e.Cleanup, e.Service, e.Cause)
+}
+
+// Unwrap returns the error returned by the cleanup.
+func (e *CleanupError) Unwrap() error {
+	return e.Cause
+}
+
+// CleanupPanicError is the cause of a CleanupError when the cleanup panicked.
+type CleanupPanicError struct {
+	*PanicError
+}
+
+// Error returns the error message.
+func (e *CleanupPanicError) Error() string {
+	return fmt.Sprintf("the cleanup panicked (%v)", e.Value)
+}
+
+// Unwrap returns the details of the panic.
+func (e *CleanupPanicError) Unwrap() error {
+	return e.PanicError
+}
+
+// cleanup is a function registered with Lifecycle.Defer().
+type cleanup struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+func (l *lifecycle) Defer(name string, cleanupFunc func(ctx context.Context) error) {
+	if cleanupFunc == nil {
+		panic(fmt.Sprintf("bug: nil cleanup %s passed to Defer", name))
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.state == StateStopped || l.state == StateCrashed {
+		panic(fmt.Sprintf("bug: cleanup %s registered on %s while it is not running", name, l.service.String()))
+	}
+	l.cleanups = append(l.cleanups, cleanup{name: name, run: cleanupFunc})
+}
+
+// runCleanups runs the registered cleanups in reverse order of registration with the shutdown context and records
+// the errors of failed cleanups so they are reported through Error().
+func (l *lifecycle) runCleanups() {
+	l.mutex.Lock()
+	cleanups := l.cleanups
+	l.cleanups = nil
+	l.mutex.Unlock()
+	if len(cleanups) == 0 {
+		return
+	}
+
+	ctx, cancel := l.shutdownDeadline()
+	defer cancel()
+	var errs []error
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		if err := callCleanup(ctx, cleanups[i].run); err != nil {
+			cleanupError := &CleanupError{
+				Service: l.service.String(),
+				Cleanup: cleanups[i].name,
+				Cause:   err,
+			}
+			l.logFailedCleanup(cleanupError)
+			errs = append(errs, cleanupError)
+		}
+	}
+	l.mutex.Lock()
+	l.cleanupErrors = append(l.cleanupErrors, errs...)
+	l.mutex.Unlock()
+}
+
+// callCleanup calls the cleanup and converts a panic into a CleanupPanicError.
+func callCleanup(ctx context.Context, cleanupFunc func(ctx context.Context) error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &CleanupPanicError{PanicError: newPanicError(value)}
+		}
+	}()
+	return cleanupFunc(ctx)
+}
+
+// logFailedCleanup logs a failed cleanup if a logger is configured.
+func (l *lifecycle) logFailedCleanup(cleanupError *CleanupError) {
+	if l.config.Logger == nil {
+		return
+	}
+	l.config.Logger.Warning(
+		log.Wrap(
+			cleanupError.Cause,
+			EServiceCleanupFailed,
+			"cleanup %s of %s failed",
+			cleanupError.Cleanup,
+			cleanupError.Service,
+		).Label("service", cleanupError.Service).Label("cleanup", cleanupError.Cleanup),
+	)
+}
@@ -0,0 +1,135 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func TestCleanupOrderAfterCrash(t *testing.T) {
+	var order []string
+	record := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	crash := errors.New("crash")
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Defer("listener", record("listener"))
+		lifecycle.Defer("temp dir", record("temp dir"))
+		lifecycle.Defer("file", record("file"))
+		return crash
+	})
+	l := service.NewLifecycle(s)
+	crashedOrder := make(chan []string, 1)
+	l.OnCrashed(func(s service.Service, l service.Lifecycle, err error) {
+		crashedOrder <- append([]string{}, order...)
+	})
+
+	assert.Equal(t, crash, l.Run())
+	assert.Equal(t, []string{"file", "temp dir", "listener"}, <-crashedOrder)
+	assert.Equal(t, crash, l.Error())
+}
+
+func TestCleanupAfterPanic(t *testing.T) {
+	cleaned := false
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Defer("resource", func(ctx context.Context) error {
+			cleaned = true
+			return nil
+		})
+		panic("test")
+	})
+	l := service.NewLifecycle(s)
+	assert.Error(t, l.Run())
+	assert.True(t, cleaned)
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestCleanupErrors(t *testing.T) {
+	logger, output := newRecordingLogger(t)
+	cleanupErr := errors.New("cleanup failed")
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Defer("failing", func(ctx context.Context) error {
+			return cleanupErr
+		})
+		lifecycle.Defer("panicking", func(ctx context.Context) error {
+			panic("test")
+		})
+		lifecycle.Running()
+		return nil
+	})
+	l, err := service.NewLifecycleWithConfig(s, service.LifecycleConfig{Logger: logger})
+	assert.NoError(t, err)
+
+	assert.NoError(t, l.Run())
+	assert.Equal(t, service.StateStopped, l.State())
+	errs := service.Errors(l.Error())
+	if assert.Len(t, errs, 2) {
+		var first *service.CleanupError
+		assert.True(t, errors.As(errs[0], &first))
+		assert.Equal(t, "panicking", first.Cleanup)
+		var panicError *service.CleanupPanicError
+		assert.True(t, errors.As(errs[0], &panicError))
+		assert.True(t, errors.Is(errs[1], cleanupErr))
+	}
+	assert.True(t, strings.Contains(output.String(), service.EServiceCleanupFailed))
+}
+
+func TestCleanupShutdownContext(t *testing.T) {
+	deadlines := make(chan bool, 1)
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Defer("resource", func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			deadlines <- ok
+			return nil
+		})
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		return nil
+	})
+	l := service.NewLifecycle(s)
+	result := startLifecycle(t, l)
+	shutdownContext, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	l.Stop(shutdownContext)
+	assert.NoError(t, <-result)
+	assert.True(t, <-deadlines, "the cleanup did not receive the shutdown context")
+}
+
+func TestCleanupsClearedOnRerun(t *testing.T) {
+	calls := 0
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		if lifecycle.Generation() == 1 {
+			lifecycle.Defer("first run", func(ctx context.Context) error {
+				calls++
+				return errors.New("cleanup failed")
+			})
+		}
+		lifecycle.Running()
+		return nil
+	})
+	l := service.NewLifecycle(s)
+	assert.NoError(t, l.Run())
+	assert.Error(t, l.Error())
+	assert.NoError(t, l.Run())
+	assert.NoError(t, l.Error())
+	assert.Equal(t, 1, calls)
+}
+
+func TestDeferOutsideRun(t *testing.T) {
+	l := service.NewLifecycle(newTestService("Test service"))
+	assert.Panics(t, func() {
+		l.Defer("orphan", func(ctx context.Context) error {
+			return nil
+		})
+	})
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// LifecycleConfig holds the settings for the default lifecycle implementation. The zero value is a valid
+// configuration.
+type LifecycleConfig struct {
+	// TransitionPolicy determines what happens when the service performs an illegal state transition. Defaults to
+	// TransitionPolicyError.
+	TransitionPolicy TransitionPolicy
+
+	// Logger is used to report problems with the service, such as illegal state transitions and misbehaving hooks.
+	// Required for TransitionPolicyLog.
+	Logger log.Logger
+
+	// Clock is the source of time for timeouts. Defaults to the system clock.
+	Clock Clock
+
+	// StartupTimeout is the time the service has to call Running() after it has been started. If it expires the
+	// lifecycle cancels the context and the service crashes with a StartupTimeoutError. Zero means no timeout.
+	StartupTimeout time.Duration
+
+	// ShutdownTimeout bounds the shutdown context handed to the service, including when the service stops on its
+	// own without a call to Stop(). Zero means the shutdown context passed to Stop() is used as-is.
+	ShutdownTimeout time.Duration
+
+	// PreStopDelay is the time the lifecycle waits after Stop() has been called on a running service before it cancels
+	// the context of the service. During the delay the service is already in the "stopping" state and its stopping
+	// hooks have run, but it keeps serving requests, giving load balancers time to stop sending new ones. The delay is
+	// bounded by the shutdown context. Zero means no delay.
+	PreStopDelay time.Duration
+
+	// HookTimeout is the default maximum time a hook handler may run. Individual hooks can override it. Zero means
+	// no timeout.
+	HookTimeout time.Duration
+
+	// DrainOnStopping makes Stopping() call Drain() after the stopping hooks, so it only returns once the tracked work
+	// units have finished or have been canceled at the shutdown deadline.
+	DrainOnStopping bool
+
+	// EventBufferSize is the number of events buffered for each subscriber. Defaults to 16.
+	EventBufferSize int
+
+	// EventPolicy determines what happens to events when the buffer of a subscriber is full. Defaults to
+	// EventPolicyDrop.
+	EventPolicy EventPolicy
+}
+
+// Validate checks the lifecycle configuration for errors.
+func (c *LifecycleConfig) Validate() error {
+	if err := c.TransitionPolicy.Validate(); err != nil {
+		return err
+	}
+	if c.TransitionPolicy == TransitionPolicyLog && c.Logger == nil {
+		return fmt.Errorf("the log transition policy requires a logger")
+	}
+	if c.StartupTimeout < 0 {
+		return fmt.Errorf("the startup timeout must not be negative")
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("the shutdown timeout must not be negative")
+	}
+	if c.PreStopDelay < 0 {
+		return fmt.Errorf("the pre-stop delay must not be negative")
+	}
+	if c.HookTimeout < 0 {
+		return fmt.Errorf("the hook timeout must not be negative")
+	}
+	if c.EventBufferSize < 0 {
+		return fmt.Errorf("the event buffer size must not be negative")
+	}
+	if err := c.EventPolicy.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
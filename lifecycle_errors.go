@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// StartupTimeoutError is the error a service crashes with when it does not call Running() within the configured
+// startup timeout.
+type StartupTimeoutError struct {
+	// Service is the name of the service that failed to start.
+	Service string
+	// Timeout is the startup timeout that expired.
+	Timeout time.Duration
+}
+
+// Error returns the error message.
+func (e *StartupTimeoutError) Error() string {
+	return fmt.Sprintf("%s did not start within %s", e.Service, e.Timeout)
+}
+
+// HookError is the error returned when a hook handler fails.
+type HookError struct {
+	// Service is the name of the service the hook was registered for.
+	Service string
+	// Hook is the name of the failed hook.
+	Hook string
+	// Type is the type of the failed handler.
+	Type HookType
+	// Cause is the error returned by the handler.
+	Cause error
+}
+
+// Error returns the error message.
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook %s of %s failed (%v)", e.Type, e.Hook, e.Service, e.Cause)
+}
+
+// Unwrap returns the error returned by the handler.
+func (e *HookError) Unwrap() error {
+	return e.Cause
+}
+
+// HookTimeoutError is the cause of a HookError when the hook handler did not return within its timeout.
+type HookTimeoutError struct {
+	// Timeout is the timeout that expired.
+	Timeout time.Duration
+}
+
+// Error returns the error message.
+func (e *HookTimeoutError) Error() string {
+	return fmt.Sprintf("the hook did not return within %s", e.Timeout)
+}
+
+// PanicError holds the details of a recovered panic. ServicePanicError, HookPanicError, TaskPanicError and
+// CleanupPanicError embed it, so errors.As finds it for any panic a lifecycle has recovered.
+type PanicError struct {
+	// Value is the value passed to panic().
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+// newPanicError records the value passed to panic() and the current stack. It must be called by the function that
+// recovered the panic.
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{
+		Value: value,
+		Stack: debug.Stack(),
+	}
+}
+
+// Error returns the error message.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic() if it was an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// ServicePanicError is the crash error of a service that panicked in RunWithLifecycle.
+type ServicePanicError struct {
+	*PanicError
+}
+
+// Error returns the error message.
+func (e *ServicePanicError) Error() string {
+	return fmt.Sprintf("service paniced (%v)", e.Value)
+}
+
+// Unwrap returns the details of the panic.
+func (e *ServicePanicError) Unwrap() error {
+	return e.PanicError
+}
+
+// HookPanicError is the cause of a HookError when the hook handler panicked.
+type HookPanicError struct {
+	*PanicError
+}
+
+// Error returns the error message.
+func (e *HookPanicError) Error() string {
+	return fmt.Sprintf("the hook panicked (%v)", e.Value)
+}
+
+// Unwrap returns the details of the panic.
+func (e *HookPanicError) Unwrap() error {
+	return e.PanicError
+}
+
+// ErrorList is a list of errors that occurred during a single run of a lifecycle. The crash error, if any, is always
+// the first element. errors.Is and errors.As match any of the errors in the list.
+type ErrorList []error
+
+// Error returns the messages of all errors in the list.
+func (e ErrorList) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is returns true if any of the errors in the list matches the target.
+func (e ErrorList) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error in the list that matches the target and sets the target to it.
+func (e ErrorList) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the individual errors contained in the error. If err is an ErrorList the list items are returned,
+// if it is nil an empty slice is returned, otherwise a slice with err as its only element.
+func Errors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	var list ErrorList
+	if errors.As(err, &list) {
+		return list
+	}
+	return []error{err}
+}
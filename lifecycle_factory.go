@@ -8,14 +8,37 @@ import (
 // NewLifecycle creates a new lifecycle for the specified service. The lifecycle is responsible for managing the start
 // and stop of the service.
 func NewLifecycle(service Service) Lifecycle {
+	return newLifecycle(service, LifecycleConfig{})
+}
+
+// NewLifecycleWithConfig creates a new lifecycle for the specified service with a custom configuration. It returns an
+// error if the configuration is invalid.
+func NewLifecycleWithConfig(service Service, config LifecycleConfig) (Lifecycle, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newLifecycle(service, config), nil
+}
+
+func newLifecycle(service Service, config LifecycleConfig) *lifecycle {
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	return &lifecycle{
 		service:         service,
+		config:          config,
 		state:           StateStopped,
 		mutex:           &sync.Mutex{},
 		runningContext:  ctx,
 		cancelRun:       cancelFunc,
 		shutdownContext: context.Background(),
+		events:          newEventBus(config.EventBufferSize, config.EventPolicy),
+		ready:           make(chan struct{}),
+		done:            make(chan struct{}),
+		stateWaiters:    map[*stateWaiter]struct{}{},
+		work:            newDrainTracker(),
+		tasks:           newTaskGroup(),
 	}
 }
 
@@ -24,14 +47,26 @@ func NewLifecycleFactory() LifecycleFactory {
 	return &lifecycleFactory{}
 }
 
+// NewLifecycleFactoryWithConfig creates a new factory that creates lifecycles with the specified configuration. It
+// returns an error if the configuration is invalid.
+func NewLifecycleFactoryWithConfig(config LifecycleConfig) (LifecycleFactory, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &lifecycleFactory{
+		config: config,
+	}, nil
+}
+
 // LifecycleFactory is an interface to create lifecycle objects in pools.
 type LifecycleFactory interface {
 	Make(service Service) Lifecycle
 }
 
 type lifecycleFactory struct {
+	config LifecycleConfig
 }
 
 func (l *lifecycleFactory) Make(service Service) Lifecycle {
-	return NewLifecycle(service)
+	return newLifecycle(service, l.config)
 }
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/containerssh/log"
+)
+
+// runHooks executes the handlers selected from the registered hooks in priority groups. The selector returns the
+// function to call for a hook, or nil if the hook has no handler for the current event. Errors of failed handlers are
+// wrapped in a HookError. If canVeto is set, the first failing hook that is not optional vetoes the event: the
+// remaining priority groups are skipped and its error is returned separately from the other errors.
+func (l *lifecycle) runHooks(
+	hookType HookType,
+	canVeto bool,
+	selector func(hook Hook) func() error,
+) (errs []error, veto error) {
+	sorted := l.getHooks()
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	results := &hookResults{}
+	for i := 0; i < len(sorted) && !results.vetoed(); {
+		priority := sorted[i].Priority
+		wg := &sync.WaitGroup{}
+		for ; i < len(sorted) && sorted[i].Priority == priority; i++ {
+			hook := sorted[i]
+			handler := selector(hook)
+			if handler == nil {
+				continue
+			}
+			vetoes := canVeto && !hook.Optional
+			if hook.Mode == HookModeSequential {
+				wg.Wait()
+				if results.vetoed() {
+					break
+				}
+				results.add(l.callHook(hookType, hook, handler), vetoes)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results.add(l.callHook(hookType, hook, handler), vetoes)
+			}()
+		}
+		wg.Wait()
+	}
+	return results.errors, results.veto
+}
+
+// callHook calls a single hook handler, isolating panics and enforcing the timeout. It returns a HookError if the
+// handler failed.
+func (l *lifecycle) callHook(hookType HookType, hook Hook, handler func() error) error {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = l.config.HookTimeout
+	}
+	var err error
+	if timeout <= 0 {
+		err = callHookHandler(handler)
+	} else {
+		result := make(chan error, 1)
+		go func() {
+			result <- callHookHandler(handler)
+		}()
+		timer := l.config.Clock.NewTimer(timeout)
+		select {
+		case err = <-result:
+			timer.Stop()
+		case <-timer.C():
+			err = &HookTimeoutError{Timeout: timeout}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	hookError := &HookError{
+		Service: l.service.String(),
+		Hook:    hook.Name,
+		Type:    hookType,
+		Cause:   err,
+	}
+	l.logMisbehavingHook(hookError)
+	l.publish(Event{Type: EventTypeHookError, Error: hookError})
+	return hookError
+}
+
+// callHookHandler calls the handler and converts a panic into a HookPanicError.
+func callHookHandler(handler func() error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &HookPanicError{PanicError: newPanicError(value)}
+		}
+	}()
+	return handler()
+}
+
+// logMisbehavingHook logs hooks that timed out or panicked if a logger is configured.
+func (l *lifecycle) logMisbehavingHook(hookError *HookError) {
+	if l.config.Logger == nil {
+		return
+	}
+	if message := misbehavingHookMessage(hookError); message != nil {
+		l.config.Logger.Error(message)
+	}
+}
+
+// hookResults collects the errors of hooks running in parallel.
+type hookResults struct {
+	lock   sync.Mutex
+	errors []error
+	veto   error
+}
+
+func (h *hookResults) add(err error, vetoes bool) {
+	if err == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if vetoes && h.veto == nil {
+		h.veto = err
+		return
+	}
+	h.errors = append(h.errors, err)
+}
+
+func (h *hookResults) vetoed() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.veto != nil
+}
+
+// misbehavingHookMessage returns the log message for a hook that timed out or panicked, or nil if the hook failed by
+// returning an error.
+func misbehavingHookMessage(hookError *HookError) log.Message {
+	var timeoutError *HookTimeoutError
+	if errors.As(hookError.Cause, &timeoutError) {
+		return log.Wrap(
+			hookError.Cause,
+			EServiceHookMisbehaved,
+			"%s hook %s of %s timed out",
+			hookError.Type,
+			hookError.Hook,
+			hookError.Service,
+		).Label("service", hookError.Service).Label("hook", hookError.Hook).Label("hookType", string(hookError.Type))
+	}
+	var panicError *HookPanicError
+	if errors.As(hookError.Cause, &panicError) {
+		return log.Wrap(
+			hookError.Cause,
+			EServiceHookMisbehaved,
+			"%s hook %s of %s panicked",
+			hookError.Type,
+			hookError.Hook,
+			hookError.Service,
+		).Label("service", hookError.Service).Label("hook", hookError.Hook).Label("hookType", string(hookError.Type)).
+			Label("stack", string(panicError.Stack))
+	}
+	return nil
+}
+
+// getHooks returns a copy of the registered hooks that is safe to iterate over without holding the mutex.
+func (l *lifecycle) getHooks() []Hook {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Hook{}, l.hooks...)
+}
+
+// addHookErrors stores the errors of failed hooks so they can be reported through Error().
+func (l *lifecycle) addHookErrors(errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.hookErrors = append(l.hookErrors, errs...)
+}
+
+func (l *lifecycle) stateChange(state State) {
+	l.mutex.Lock()
+	crashError := l.lastError
+	healthChanged := l.healthy != (state == StateRunning)
+	l.healthy = state == StateRunning
+	l.mutex.Unlock()
+	if state != StateCrashed {
+		crashError = nil
+	}
+	// The lifecycle may already have moved on to another state, so the events carry the state that caused them.
+	l.publish(Event{Type: EventTypeStateChange, State: state, Error: crashError})
+	if healthChanged {
+		l.publish(Event{Type: EventTypeHealthChange, State: state})
+	}
+
+	errs, _ := l.runHooks(HookTypeStateChange, false, func(hook Hook) func() error {
+		if hook.OnStateChange == nil {
+			return nil
+		}
+		return func() error {
+			return hook.OnStateChange(l.service, l, state)
+		}
+	})
+	l.addHookErrors(errs)
+}
+
+// startingHooks runs the starting hooks. It returns the error of the first failed hook that is not optional, which
+// should prevent the service from starting.
+func (l *lifecycle) startingHooks() error {
+	errs, veto := l.runHooks(HookTypeStarting, true, func(hook Hook) func() error {
+		if hook.OnStarting == nil {
+			return nil
+		}
+		return func() error {
+			return hook.OnStarting(l.service, l)
+		}
+	})
+	l.addHookErrors(errs)
+	return veto
+}
+
+func (l *lifecycle) runningHooks() {
+	errs, _ := l.runHooks(HookTypeRunning, false, func(hook Hook) func() error {
+		if hook.OnRunning == nil {
+			return nil
+		}
+		return func() error {
+			return hook.OnRunning(l.service, l)
+		}
+	})
+	l.addHookErrors(errs)
+}
+
+func (l *lifecycle) stoppingHooks(shutdownContext context.Context) {
+	errs, _ := l.runHooks(HookTypeStopping, false, func(hook Hook) func() error {
+		if hook.OnStopping == nil {
+			return nil
+		}
+		return func() error {
+			return hook.OnStopping(l.service, l, shutdownContext)
+		}
+	})
+	l.addHookErrors(errs)
+}
+
+func (l *lifecycle) stoppedHooks() {
+	errs, _ := l.runHooks(HookTypeStopped, false, func(hook Hook) func() error {
+		if hook.OnStopped == nil {
+			return nil
+		}
+		return func() error {
+			return hook.OnStopped(l.service, l)
+		}
+	})
+	l.addHookErrors(errs)
+}
+
+func (l *lifecycle) crashedHooks(err error) {
+	errs, _ := l.runHooks(HookTypeCrashed, false, func(hook Hook) func() error {
+		if hook.OnCrashed == nil {
+			return nil
+		}
+		return func() error {
+			return hook.OnCrashed(l.service, l, err)
+		}
+	})
+	l.addHookErrors(errs)
+}
+
+func (l *lifecycle) AddHook(hook Hook) Lifecycle {
+	if err := hook.Mode.Validate(); err != nil {
+		panic("bug: " + err.Error())
+	}
+	if hook.Name == "" {
+		hook.Name = hook.defaultName()
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.hooks = append(l.hooks, hook)
+	return l
+}
+
+func (l *lifecycle) OnStateChange(f func(s Service, l Lifecycle, state State)) Lifecycle {
+	return l.AddHook(Hook{Name: funcName(f), OnStateChange: func(s Service, l Lifecycle, state State) error {
+		f(s, l, state)
+		return nil
+	}})
+}
+
+func (l *lifecycle) OnStarting(f func(s Service, l Lifecycle)) Lifecycle {
+	return l.AddHook(Hook{Name: funcName(f), OnStarting: func(s Service, l Lifecycle) error {
+		f(s, l)
+		return nil
+	}})
+}
+
+func (l *lifecycle) OnRunning(f func(s Service, l Lifecycle)) Lifecycle {
+	return l.AddHook(Hook{Name: funcName(f), OnRunning: func(s Service, l Lifecycle) error {
+		f(s, l)
+		return nil
+	}})
+}
+
+func (l *lifecycle) OnStopping(f func(s Service, l Lifecycle, shutdownContext context.Context)) Lifecycle {
+	return l.AddHook(Hook{
+		Name: funcName(f),
+		OnStopping: func(s Service, l Lifecycle, shutdownContext context.Context) error {
+			f(s, l, shutdownContext)
+			return nil
+		},
+	})
+}
+
+func (l *lifecycle) OnStopped(f func(s Service, l Lifecycle)) Lifecycle {
+	return l.AddHook(Hook{Name: funcName(f), OnStopped: func(s Service, l Lifecycle) error {
+		f(s, l)
+		return nil
+	}})
+}
+
+func (l *lifecycle) OnCrashed(f func(s Service, l Lifecycle, err error)) Lifecycle {
+	return l.AddHook(Hook{Name: funcName(f), OnCrashed: func(s Service, l Lifecycle, err error) error {
+		f(s, l, err)
+		return nil
+	}})
+}
@@ -2,30 +2,49 @@ package service
 
 import (
 	"context"
-	"fmt"
 	"sync"
+
+	"github.com/containerssh/log"
 )
 
 type lifecycle struct {
 	service           Service
+	config            LifecycleConfig
 	state             State
 	mutex             *sync.Mutex
 	runningContext    context.Context
 	cancelRun         func()
 	shutdownContext   context.Context
+	cancelShutdown    func()
 	lastError         error
+	hookErrors        []error
+	cleanupErrors     []error
 	waitContext       context.Context
 	cancelWaitContext func()
-
-	onStateChange []func(s Service, l Lifecycle, state State)
-	onStarting    []func(s Service, l Lifecycle)
-	onRunning     []func(s Service, l Lifecycle)
-	onStopping    []func(s Service, l Lifecycle, shutdownContext context.Context)
-	onStopped     []func(s Service, l Lifecycle)
-	onCrashed     []func(s Service, l Lifecycle, err error)
+	transitionError   error
+	startupTimer      Timer
+	startupError      error
+	generation        int
+	healthy           bool
+	events            *eventBus
+	ready             chan struct{}
+	done              chan struct{}
+	stateWaiters      map[*stateWaiter]struct{}
+	work              *drainTracker
+	tasks             *taskGroup
+	cleanups          []cleanup
+	// preStopped is true if the current run has entered the "stopping" state through the pre-stop delay and the
+	// service has not called Stopping() since.
+	preStopped bool
+	// preStopDone is closed when the pre-stop phase of the current run has ended. Nil if there is none.
+	preStopDone chan struct{}
+
+	hooks []Hook
 }
 
 func (l *lifecycle) Context() context.Context {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.runningContext
 }
 
@@ -41,19 +60,32 @@ func (l *lifecycle) ShouldStop() bool {
 }
 
 func (l *lifecycle) ShutdownContext() context.Context {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.shutdownContext
 }
 
+func (l *lifecycle) Generation() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.generation
+}
+
 func (l *lifecycle) State() State {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.state
 }
 
 func (l *lifecycle) Wait() error {
 	l.mutex.Lock()
 	if l.state == StateCrashed {
-		return l.lastError
+		err := l.lastError
+		l.mutex.Unlock()
+		return err
 	}
 	if l.state == StateStopped {
+		l.mutex.Unlock()
 		return nil
 	}
 	waitContext := l.waitContext
@@ -61,37 +93,289 @@ func (l *lifecycle) Wait() error {
 	if waitContext != nil {
 		<-waitContext.Done()
 	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.lastError
 }
 
+func (l *lifecycle) WaitForState(ctx context.Context, states ...State) (State, error) {
+	if len(states) == 0 {
+		panic("bug: no states passed to WaitForState")
+	}
+	waiter := &stateWaiter{
+		states: states,
+		c:      make(chan State, 1),
+	}
+	l.mutex.Lock()
+	if waiter.matches(l.state) {
+		state := l.state
+		l.mutex.Unlock()
+		return state, nil
+	}
+	l.stateWaiters[waiter] = struct{}{}
+	l.mutex.Unlock()
+
+	select {
+	case state := <-waiter.c:
+		return state, nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		delete(l.stateWaiters, waiter)
+		l.mutex.Unlock()
+		select {
+		case state := <-waiter.c:
+			return state, nil
+		default:
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (l *lifecycle) Ready() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.ready
+}
+
+func (l *lifecycle) Done() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.done
+}
+
+// setState changes the state and notifies the channels and functions waiting for it. It must be called with the mutex
+// held.
+func (l *lifecycle) setState(state State) {
+	l.state = state
+	switch state {
+	case StateStarting:
+		if isClosed(l.done) {
+			l.done = make(chan struct{})
+		}
+	case StateRunning:
+		close(l.ready)
+	case StateStopped, StateCrashed:
+		if isClosed(l.ready) {
+			l.ready = make(chan struct{})
+		}
+		if !isClosed(l.done) {
+			close(l.done)
+		}
+	}
+	for waiter := range l.stateWaiters {
+		if waiter.matches(state) {
+			waiter.c <- state
+			delete(l.stateWaiters, waiter)
+		}
+	}
+}
+
+// isClosed returns true if the channel has been closed. The channel must only ever be closed, never written.
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// stateWaiter is a pending WaitForState call.
+type stateWaiter struct {
+	states []State
+	c      chan State
+}
+
+func (w *stateWaiter) matches(state State) bool {
+	for _, s := range w.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lifecycle) clock() Clock {
+	return l.config.Clock
+}
+
+func (l *lifecycle) Subscribe(ctx context.Context) <-chan Event {
+	return l.events.subscribe(ctx)
+}
+
+func (l *lifecycle) addEventListener(listener func(event Event)) {
+	l.events.addEventListener(listener)
+}
+
+// publish sends an event about this lifecycle to the subscribers. It must be called without the mutex held.
+func (l *lifecycle) publish(event Event) {
+	event.Service = l.service
+	event.Lifecycle = l
+	event.Time = l.config.Clock.Now()
+	l.mutex.Lock()
+	if event.State == "" {
+		event.State = l.state
+	}
+	event.Generation = l.generation
+	l.mutex.Unlock()
+	event.Healthy = event.State == StateRunning
+	l.events.publish(event)
+}
+
 func (l *lifecycle) Error() error {
-	return l.lastError
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.hookErrors) == 0 && len(l.cleanupErrors) == 0 {
+		return l.lastError
+	}
+	var errs ErrorList
+	if l.lastError != nil {
+		errs = append(errs, l.lastError)
+	}
+	errs = append(errs, l.hookErrors...)
+	return append(errs, l.cleanupErrors...)
+}
+
+func (l *lifecycle) Track(cancel func(reason error)) WorkUnit {
+	return l.work.track(cancel)
+}
+
+func (l *lifecycle) ActiveWork() int {
+	return l.work.count()
+}
+
+func (l *lifecycle) Drain() error {
+	if l.work.wait(l.ShutdownContext()) {
+		return nil
+	}
+	canceled := l.work.cancelAll(ErrDrainDeadline)
+	if canceled == 0 {
+		return nil
+	}
+	err := &DrainError{
+		Service:  l.service.String(),
+		Canceled: canceled,
+	}
+	if l.config.Logger != nil {
+		l.config.Logger.Warning(
+			log.Wrap(
+				err,
+				EServiceDrainTimeout,
+				"%s did not finish %d work units before the shutdown deadline",
+				err.Service,
+				canceled,
+			).Label("service", err.Service).Label("canceled", canceled),
+		)
+	}
+	return err
 }
 
 func (l *lifecycle) Stop(shutdownContext context.Context) {
+	if l.requestStop(shutdownContext) {
+		_ = l.Wait()
+	}
+}
+
+// requestStop triggers a shutdown without waiting for the service to exit. It returns false if the service is not
+// running or already stopping.
+func (l *lifecycle) requestStop(shutdownContext context.Context) bool {
 	l.mutex.Lock()
 	if l.state == StateStopping || l.state == StateStopped || l.state == StateCrashed {
 		l.mutex.Unlock()
-		return
+		return false
+	}
+	if l.cancelShutdown == nil {
+		l.shutdownContext, l.cancelShutdown = withClockTimeout(
+			shutdownContext,
+			l.config.Clock,
+			l.config.ShutdownTimeout,
+		)
+	}
+	cancelRun := l.cancelRun
+	if l.config.PreStopDelay > 0 && l.state == StateRunning {
+		l.setState(StateStopping)
+		l.preStopped = true
+		l.preStopDone = make(chan struct{})
+		go l.preStop(l.shutdownContext, l.runningContext, cancelRun, l.preStopDone)
+		l.mutex.Unlock()
+		return true
 	}
-	l.shutdownContext = shutdownContext
 	l.mutex.Unlock()
-	l.cancelRun()
-	_ = l.Wait()
+	cancelRun()
+	return true
 }
 
-func (l *lifecycle) Run() error {
+// preStop runs the stopping hooks and waits for the pre-stop delay before canceling the context of the service. The
+// wait ends early if the shutdown context expires or the service exits on its own.
+func (l *lifecycle) preStop(
+	shutdownContext context.Context,
+	runningContext context.Context,
+	cancelRun func(),
+	done chan struct{},
+) {
+	defer close(done)
+	l.stateChange(StateStopping)
+	l.stoppingHooks(shutdownContext)
+	delayContext, cancelDelay := withClockTimeout(shutdownContext, l.config.Clock, l.config.PreStopDelay)
+	defer cancelDelay()
+	select {
+	case <-delayContext.Done():
+	case <-runningContext.Done():
+	}
+	cancelRun()
+}
+
+// waitForPreStop waits for the pre-stop phase of the current run to end after the service has exited.
+func (l *lifecycle) waitForPreStop() {
+	l.mutex.Lock()
+	done := l.preStopDone
+	cancelRun := l.cancelRun
+	l.mutex.Unlock()
+	if done == nil {
+		return
+	}
+	cancelRun()
+	<-done
+}
+
+func (l *lifecycle) Run() (err error) {
+	if err := l.starting(); err != nil {
+		return err
+	}
+
 	defer func() {
 		if crash := recover(); crash != nil {
-			l.crashed(fmt.Errorf("service paniced (%v)", crash))
+			err = &ServicePanicError{PanicError: newPanicError(crash)}
+			l.waitForPreStop()
+			_ = l.waitForTasks()
+			l.runCleanups()
+			l.crashed(err)
+		}
+		l.mutex.Lock()
+		l.cancelRun()
+		if l.cancelShutdown != nil {
+			l.cancelShutdown()
 		}
-		l.cancelWaitContext()
+		cancelWaitContext := l.cancelWaitContext
+		l.mutex.Unlock()
+		cancelWaitContext()
 	}()
 
-	l.lastError = nil
-	l.waitContext, l.cancelWaitContext = context.WithCancel(context.Background())
-	l.starting()
-	err := l.service.RunWithLifecycle(l)
+	if err = l.startingHooks(); err != nil {
+		l.runCleanups()
+		l.crashed(err)
+		return err
+	}
+	err = l.service.RunWithLifecycle(l)
+	l.waitForPreStop()
+	leakErr := l.waitForTasks()
+	l.runCleanups()
+	if err == nil {
+		err = l.lifecycleError()
+	}
+	if err == nil {
+		err = leakErr
+	}
 	if err != nil {
 		l.crashed(err)
 		return err
@@ -100,48 +384,127 @@ func (l *lifecycle) Run() error {
 	return nil
 }
 
-func (l *lifecycle) callSimpleHook(hooks []func(s Service, l Lifecycle)) {
-	wg := &sync.WaitGroup{}
-	wg.Add(len(hooks))
-	for _, hook := range hooks {
-		handler := hook
-		go func() {
-			defer wg.Done()
-			handler(l.service, l)
-		}()
+// lifecycleError returns the error the lifecycle itself detected during the run, if any.
+func (l *lifecycle) lifecycleError() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.startupError != nil {
+		return l.startupError
 	}
-	wg.Wait()
+	if l.transitionError != nil {
+		return l.transitionError
+	}
+	return l.tasks.failure()
 }
 
-func (l *lifecycle) stateChange(state State) {
-	wg := &sync.WaitGroup{}
-	stateChangeHandlers := l.onStateChange
-	wg.Add(len(stateChangeHandlers))
-	for _, hook := range stateChangeHandlers {
-		handler := hook
-		go func() {
-			defer wg.Done()
-			handler(l.service, l, state)
-		}()
+func (l *lifecycle) starting() error {
+	l.mutex.Lock()
+	if err := l.transition(StateStarting); err != nil {
+		l.mutex.Unlock()
+		return err
+	}
+	// Each run gets fresh contexts so a previous Stop() does not affect it.
+	restart := l.generation > 0
+	l.generation++
+	l.runningContext, l.cancelRun = context.WithCancel(context.Background())
+	l.shutdownContext = context.Background()
+	l.cancelShutdown = nil
+	l.preStopped = false
+	l.preStopDone = nil
+	l.tasks = newTaskGroup()
+	l.lastError = nil
+	l.hookErrors = nil
+	l.cleanupErrors = nil
+	l.cleanups = nil
+	l.transitionError = nil
+	l.startupError = nil
+	l.waitContext, l.cancelWaitContext = context.WithCancel(context.Background())
+	if l.config.StartupTimeout > 0 {
+		l.startupTimer = l.config.Clock.NewTimer(l.config.StartupTimeout)
+		go l.watchStartup(l.startupTimer, l.waitContext, l.generation)
+	}
+	l.mutex.Unlock()
+	if restart {
+		l.publish(Event{Type: EventTypeRestart})
 	}
-	wg.Wait()
+	l.stateChange(StateStarting)
+	return nil
 }
 
-func (l *lifecycle) starting() {
-	l.mutex.Lock()
+// transition moves the lifecycle into the specified state if the state machine allows it. It must be called with the
+// mutex held.
+func (l *lifecycle) transition(newState State) *IllegalTransitionError {
+	if !CanTransition(l.state, newState) {
+		return &IllegalTransitionError{
+			Service: l.service.String(),
+			From:    l.state,
+			To:      newState,
+		}
+	}
+	l.setState(newState)
+	return nil
+}
+
+// illegalTransition applies the configured transition policy to an illegal transition. It must be called without the
+// mutex held.
+func (l *lifecycle) illegalTransition(err *IllegalTransitionError) {
+	switch l.config.TransitionPolicy {
+	case TransitionPolicyPanic:
+		panic(err)
+	case TransitionPolicyLog:
+		l.config.Logger.Error(
+			log.Wrap(
+				err,
+				EServiceIllegalTransition,
+				"%s attempted an illegal state transition",
+				err.Service,
+			).Label("service", err.Service).Label("from", string(err.From)).Label("to", string(err.To)),
+		)
+	default:
+		l.mutex.Lock()
+		if l.transitionError == nil {
+			l.transitionError = err
+		}
+		l.mutex.Unlock()
+	}
+}
 
-	l.state = StateStarting
+// watchStartup crashes the service if it does not call Running() before the startup timer fires.
+func (l *lifecycle) watchStartup(timer Timer, waitContext context.Context, generation int) {
+	select {
+	case <-timer.C():
+	case <-waitContext.Done():
+		timer.Stop()
+		return
+	}
+	l.mutex.Lock()
+	if l.state != StateStarting || l.generation != generation {
+		l.mutex.Unlock()
+		return
+	}
+	l.startupError = &StartupTimeoutError{
+		Service: l.service.String(),
+		Timeout: l.config.StartupTimeout,
+	}
+	cancelRun := l.cancelRun
 	l.mutex.Unlock()
-	l.stateChange(StateStarting)
-	l.callSimpleHook(l.onStarting)
+	cancelRun()
 }
 
 func (l *lifecycle) Running() {
 	l.mutex.Lock()
-	l.state = StateRunning
+	if err := l.transition(StateRunning); err != nil {
+		l.mutex.Unlock()
+		l.illegalTransition(err)
+		return
+	}
+	if l.startupTimer != nil {
+		l.startupTimer.Stop()
+		l.startupTimer = nil
+	}
 	l.mutex.Unlock()
 	l.stateChange(StateRunning)
-	l.callSimpleHook(l.onRunning)
+	l.runningHooks()
 }
 
 func (l *lifecycle) Stopping() context.Context {
@@ -149,98 +512,54 @@ func (l *lifecycle) Stopping() context.Context {
 	if l.shutdownContext == nil {
 		l.shutdownContext = context.Background()
 	}
+	if l.cancelShutdown == nil {
+		l.shutdownContext, l.cancelShutdown = withClockTimeout(
+			l.shutdownContext,
+			l.config.Clock,
+			l.config.ShutdownTimeout,
+		)
+	}
 	shutdownContext := l.shutdownContext
 
-	l.state = StateStopping
-	handlers := l.onStopping
+	if l.preStopped && l.state == StateStopping {
+		// The stopping hooks have already run during the pre-stop phase. Only the first call is expected.
+		l.preStopped = false
+		l.mutex.Unlock()
+		if l.config.DrainOnStopping {
+			_ = l.Drain()
+		}
+		return shutdownContext
+	}
+	if err := l.transition(StateStopping); err != nil {
+		l.mutex.Unlock()
+		l.illegalTransition(err)
+		return shutdownContext
+	}
 	l.mutex.Unlock()
 
 	l.stateChange(StateStopping)
-	wg := &sync.WaitGroup{}
-	wg.Add(len(handlers))
-	for _, onShutdown := range handlers {
-		shutdownHandler := onShutdown
-		go func() {
-			defer wg.Done()
-			shutdownHandler(l.service, l, shutdownContext)
-		}()
-	}
-	wg.Wait()
+	l.stoppingHooks(shutdownContext)
+	if l.config.DrainOnStopping {
+		_ = l.Drain()
+	}
 	return shutdownContext
 }
 
 func (l *lifecycle) stopped() {
 	l.mutex.Lock()
-	l.state = StateStopped
+	l.setState(StateStopped)
 	l.mutex.Unlock()
 
 	l.stateChange(StateStopped)
-	l.callSimpleHook(l.onStopped)
+	l.stoppedHooks()
 }
 
 func (l *lifecycle) crashed(err error) {
 	l.mutex.Lock()
 	l.lastError = err
-	l.state = StateCrashed
+	l.setState(StateCrashed)
 	l.mutex.Unlock()
 
 	l.stateChange(StateCrashed)
-	wg := &sync.WaitGroup{}
-	wg.Add(len(l.onCrashed))
-	for _, onCrashed := range l.onCrashed {
-		crashHandler := onCrashed
-		go func() {
-			defer wg.Done()
-			crashHandler(l.service, l, err)
-		}()
-	}
-	wg.Wait()
-}
-
-func (l *lifecycle) OnStateChange(f func(s Service, l Lifecycle, state State)) Lifecycle {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	l.onStateChange = append(l.onStateChange, f)
-	return l
-}
-
-func (l *lifecycle) OnStarting(f func(s Service, l Lifecycle)) Lifecycle {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	l.onStarting = append(l.onStarting, f)
-	return l
-}
-
-func (l *lifecycle) OnRunning(f func(s Service, l Lifecycle)) Lifecycle {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	l.onRunning = append(l.onRunning, f)
-	return l
-}
-
-func (l *lifecycle) OnStopping(f func(s Service, l Lifecycle, shutdownContext context.Context)) Lifecycle {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	l.onStopping = append(l.onStopping, f)
-	return l
-}
-
-func (l *lifecycle) OnStopped(f func(s Service, l Lifecycle)) Lifecycle {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	l.onStopped = append(l.onStopped, f)
-	return l
-}
-
-func (l *lifecycle) OnCrashed(f func(s Service, l Lifecycle, err error)) Lifecycle {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	l.onCrashed = append(l.onCrashed, f)
-	return l
+	l.crashedHooks(err)
 }
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containerssh/log"
+)
+
+// TaskError is the error a service crashes with when a task started with Lifecycle.Go() fails.
+type TaskError struct {
+	// Service is the name of the service that started the task.
+	Service string
+	// Task is the name of the failed task.
+	Task string
+	// Cause is the error returned by the task, or a *TaskPanicError if it panicked.
+	Cause error
+}
+
+// Error returns the error message.
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %s of %s failed (%v)", e.Task, e.Service, e.Cause)
+}
+
+// Unwrap returns the error returned by the task.
+func (e *TaskError) Unwrap() error {
+	return e.Cause
+}
+
+// TaskPanicError is the cause of a TaskError when the task panicked.
+type TaskPanicError struct {
+	*PanicError
+}
+
+// Error returns the error message.
+func (e *TaskPanicError) Error() string {
+	return fmt.Sprintf("the task panicked (%v)", e.Value)
+}
+
+// Unwrap returns the details of the panic.
+func (e *TaskPanicError) Unwrap() error {
+	return e.PanicError
+}
+
+// TaskLeakError is the error a service crashes with when tasks started with Lifecycle.Go() are still running when the
+// shutdown deadline is reached after the service has exited.
+type TaskLeakError struct {
+	// Service is the name of the service that started the tasks.
+	Service string
+	// Tasks are the names of the tasks that were still running, in alphabetical order.
+	Tasks []string
+}
+
+// Error returns the error message.
+func (e *TaskLeakError) Error() string {
+	return fmt.Sprintf("%s leaked %d tasks: %s", e.Service, len(e.Tasks), strings.Join(e.Tasks, ", "))
+}
+
+// taskGroup tracks the tasks started during a single run of a lifecycle.
+type taskGroup struct {
+	mutex   *sync.Mutex
+	running map[*string]struct{}
+	// idle is closed while no tasks are running and replaced when a task is started.
+	idle chan struct{}
+	// waiting is set once the lifecycle waits for the tasks to exit. No new tasks are accepted afterwards.
+	waiting bool
+	err     error
+}
+
+func newTaskGroup() *taskGroup {
+	idle := make(chan struct{})
+	close(idle)
+	return &taskGroup{
+		mutex:   &sync.Mutex{},
+		running: map[*string]struct{}{},
+		idle:    idle,
+	}
+}
+
+// add registers a new task. It returns false if the tasks are already being waited for.
+func (g *taskGroup) add(name string) (*string, bool) {
+	task := &name
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.waiting {
+		return nil, false
+	}
+	if len(g.running) == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.running[task] = struct{}{}
+	return task, true
+}
+
+func (g *taskGroup) remove(task *string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.running, task)
+	if len(g.running) == 0 {
+		close(g.idle)
+	}
+}
+
+// fail records the error of a failed task. Only the first failure is kept.
+func (g *taskGroup) fail(err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+func (g *taskGroup) failure() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.err
+}
+
+// seal rejects new tasks, since the lifecycle is about to wait for the running ones.
+func (g *taskGroup) seal() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.waiting = true
+}
+
+// wait waits until all tasks have exited or the context ends. It returns the sorted names of the tasks still running.
+func (g *taskGroup) wait(ctx context.Context) []string {
+	g.mutex.Lock()
+	idle := g.idle
+	g.mutex.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var names []string
+	for task := range g.running {
+		names = append(names, *task)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *lifecycle) Go(name string, task func(ctx context.Context) error) {
+	l.mutex.Lock()
+	if l.state == StateStopped || l.state == StateCrashed {
+		l.mutex.Unlock()
+		panic(fmt.Sprintf("bug: task %s started on %s while it is not running", name, l.service.String()))
+	}
+	tasks := l.tasks
+	ctx := l.runningContext
+	cancelRun := l.cancelRun
+	l.mutex.Unlock()
+
+	handle, ok := tasks.add(name)
+	if !ok {
+		panic(fmt.Sprintf("bug: task %s started on %s after it has exited", name, l.service.String()))
+	}
+	go func() {
+		defer tasks.remove(handle)
+		err := runTask(ctx, task)
+		if err == nil || (errors.Is(err, context.Canceled) && ctx.Err() != nil) {
+			return
+		}
+		tasks.fail(&TaskError{
+			Service: l.service.String(),
+			Task:    name,
+			Cause:   err,
+		})
+		cancelRun()
+	}()
+}
+
+// runTask runs a task and converts a panic into an error.
+func runTask(ctx context.Context, task func(ctx context.Context) error) (err error) {
+	defer func() {
+		if crash := recover(); crash != nil {
+			err = &TaskPanicError{PanicError: newPanicError(crash)}
+		}
+	}()
+	return task(ctx)
+}
+
+// waitForTasks cancels the context of the tasks after the service has exited and waits for them to exit until the
+// shutdown deadline. It returns a *TaskLeakError if tasks are still running at the deadline.
+func (l *lifecycle) waitForTasks() error {
+	l.mutex.Lock()
+	tasks := l.tasks
+	cancelRun := l.cancelRun
+	l.mutex.Unlock()
+	shutdownContext, cancelShutdown := l.shutdownDeadline()
+	defer cancelShutdown()
+
+	tasks.seal()
+	cancelRun()
+	leaked := tasks.wait(shutdownContext)
+	if len(leaked) == 0 {
+		return nil
+	}
+	err := &TaskLeakError{
+		Service: l.service.String(),
+		Tasks:   leaked,
+	}
+	if l.config.Logger != nil {
+		l.config.Logger.Warning(
+			log.Wrap(
+				err,
+				EServiceTaskLeaked,
+				"%s has tasks still running after the shutdown deadline: %s",
+				err.Service,
+				strings.Join(leaked, ", "),
+			).Label("service", err.Service),
+		)
+	}
+	return err
+}
+
+// shutdownDeadline returns the shutdown context for waiting on tasks and cleanups after the service has exited. If
+// the service has exited without a shutdown, only the configured shutdown timeout bounds the context.
+func (l *lifecycle) shutdownDeadline() (context.Context, context.CancelFunc) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.cancelShutdown == nil {
+		return withClockTimeout(l.shutdownContext, l.config.Clock, l.config.ShutdownTimeout)
+	}
+	return l.shutdownContext, func() {}
+}
@@ -0,0 +1,129 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func TestTaskLeak(t *testing.T) {
+	logger, output := newRecordingLogger(t)
+	release := make(chan struct{})
+	defer close(release)
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Go("stubborn", func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+		lifecycle.Go("well-behaved", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		return nil
+	})
+	l, err := service.NewLifecycleWithConfig(s, service.LifecycleConfig{
+		Logger:          logger,
+		ShutdownTimeout: 50 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	result := startLifecycle(t, l)
+	l.Stop(context.Background())
+
+	err = <-result
+	var leakError *service.TaskLeakError
+	if assert.True(t, errors.As(err, &leakError), "the error is not a *service.TaskLeakError: %v", err) {
+		assert.Equal(t, []string{"stubborn"}, leakError.Tasks)
+	}
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.True(t, strings.Contains(output.String(), service.EServiceTaskLeaked))
+	assert.True(t, strings.Contains(output.String(), "stubborn"))
+}
+
+func TestTaskPanic(t *testing.T) {
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		lifecycle.Go("panicking", func(ctx context.Context) error {
+			panic("test")
+		})
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		return nil
+	})
+	l := service.NewLifecycle(s)
+	err := l.Run()
+	var taskError *service.TaskError
+	if assert.True(t, errors.As(err, &taskError), "the error is not a *service.TaskError: %v", err) {
+		assert.Equal(t, "panicking", taskError.Task)
+		var panicError *service.TaskPanicError
+		if assert.True(t, errors.As(err, &panicError)) {
+			assert.Contains(t, string(panicError.Stack), "goroutine")
+		}
+	}
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestTaskPanicUnwrap(t *testing.T) {
+	cause := errors.New("out of coffee")
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Go("panicking", func(ctx context.Context) error {
+			panic(cause)
+		})
+		<-lifecycle.Context().Done()
+		return nil
+	}))
+	assert.ErrorIs(t, l.Run(), cause)
+}
+
+func TestTaskStartedAfterServiceExit(t *testing.T) {
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Go("spawner", func(ctx context.Context) error {
+			<-ctx.Done()
+			// The lifecycle is already waiting for its tasks, so the new task is rejected.
+			lifecycle.Go("late", func(ctx context.Context) error {
+				return nil
+			})
+			return nil
+		})
+		lifecycle.Running()
+		return nil
+	}))
+	err := l.Run()
+	var panicError *service.TaskPanicError
+	if assert.True(t, errors.As(err, &panicError), "the late task was not rejected: %v", err) {
+		assert.Contains(t, panicError.Error(), "after it has exited")
+	}
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestTaskFailureAfterServiceExit(t *testing.T) {
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Go("cleanup", func(ctx context.Context) error {
+			<-ctx.Done()
+			return errors.New("cleanup failed")
+		})
+		lifecycle.Running()
+		return nil
+	})
+	l := service.NewLifecycle(s)
+	err := l.Run()
+	assert.Error(t, err)
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestTaskOutsideRun(t *testing.T) {
+	l := service.NewLifecycle(newTestService("Test service"))
+	assert.Panics(t, func() {
+		l.Go("orphan", func(ctx context.Context) error {
+			return nil
+		})
+	})
+}
@@ -2,11 +2,16 @@ package service_test
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/containerssh/log"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/containerssh/service"
+	"github.com/containerssh/service/servicetest"
 )
 
 func TestLifecycle(t *testing.T) {
@@ -79,3 +84,702 @@ func TestCrash(t *testing.T) {
 	s.Crash()
 	<-crashed
 }
+
+func doubleStoppingService() service.Service {
+	return newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		lifecycle.Stopping()
+		lifecycle.Stopping()
+		lifecycle.Running()
+		return nil
+	})
+}
+
+func TestIllegalTransitionError(t *testing.T) {
+	l := service.NewLifecycle(doubleStoppingService())
+	var states []service.State
+	l.OnStateChange(func(s service.Service, l service.Lifecycle, state service.State) {
+		states = append(states, state)
+	})
+
+	err := l.Run()
+	assert.Error(t, err)
+	var transitionError *service.IllegalTransitionError
+	assert.True(t, errors.As(err, &transitionError))
+	assert.Equal(t, service.StateStopping, transitionError.From)
+	assert.Equal(t, service.StateStopping, transitionError.To)
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.Equal(t, []service.State{
+		service.StateStarting,
+		service.StateRunning,
+		service.StateStopping,
+		service.StateCrashed,
+	}, states)
+}
+
+func TestIllegalTransitionLog(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(doubleStoppingService(), service.LifecycleConfig{
+		TransitionPolicy: service.TransitionPolicyLog,
+		Logger:           log.NewTestLogger(t),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, l.Run())
+	assert.Equal(t, service.StateStopped, l.State())
+}
+
+func TestIllegalTransitionPanic(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(doubleStoppingService(), service.LifecycleConfig{
+		TransitionPolicy: service.TransitionPolicyPanic,
+	})
+	assert.NoError(t, err)
+	err = l.Run()
+	var transitionError *service.IllegalTransitionError
+	assert.True(t, errors.As(err, &transitionError))
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestIllegalTransitionConfig(t *testing.T) {
+	_, err := service.NewLifecycleWithConfig(doubleStoppingService(), service.LifecycleConfig{
+		TransitionPolicy: service.TransitionPolicyLog,
+	})
+	assert.Error(t, err)
+	_, err = service.NewLifecycleFactoryWithConfig(service.LifecycleConfig{
+		TransitionPolicy: "invalid",
+	})
+	assert.Error(t, err)
+}
+
+func TestCanTransition(t *testing.T) {
+	assert.True(t, service.CanTransition(service.StateStopped, service.StateStarting))
+	assert.True(t, service.CanTransition(service.StateRunning, service.StateStopping))
+	assert.True(t, service.CanTransition(service.StateCrashed, service.StateStarting))
+	assert.False(t, service.CanTransition(service.StateStopping, service.StateRunning))
+	assert.False(t, service.CanTransition(service.StateStopping, service.StateStopping))
+	assert.False(t, service.CanTransition(service.StateStopped, service.StateRunning))
+}
+
+func TestStartupTimeout(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	hangingService := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		return nil
+	})
+	l, err := service.NewLifecycleWithConfig(hangingService, service.LifecycleConfig{
+		Clock:          clock,
+		StartupTimeout: 10 * time.Second,
+	})
+	assert.NoError(t, err)
+	result := make(chan error)
+	go func() {
+		result <- l.Run()
+	}()
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+	clock.Advance(10 * time.Second)
+	err = <-result
+	var timeoutError *service.StartupTimeoutError
+	assert.True(t, errors.As(err, &timeoutError))
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	shutdownErr := make(chan error, 1)
+	slowService := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		shutdownContext := lifecycle.Stopping()
+		<-shutdownContext.Done()
+		shutdownErr <- shutdownContext.Err()
+		return nil
+	})
+	l, err := service.NewLifecycleWithConfig(slowService, service.LifecycleConfig{
+		Clock:           clock,
+		ShutdownTimeout: 5 * time.Second,
+	})
+	assert.NoError(t, err)
+	running := make(chan struct{})
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		close(running)
+	})
+	go func() {
+		_ = l.Run()
+	}()
+	<-running
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop(context.Background())
+		close(stopped)
+	}()
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+	clock.Advance(4 * time.Second)
+	select {
+	case <-stopped:
+		t.Fatalf("the shutdown context expired too early")
+	default:
+	}
+	clock.Advance(time.Second)
+	<-stopped
+	assert.Equal(t, context.DeadlineExceeded, <-shutdownErr)
+	assert.Equal(t, service.StateStopped, l.State())
+}
+
+func TestHookPriority(t *testing.T) {
+	lock := &sync.Mutex{}
+	var order []int
+	l := service.NewLifecycle(newTestService("Test service"))
+	for _, priority := range []int{20, -5, 10, 0} {
+		p := priority
+		l.AddHook(service.Hook{
+			Priority: p,
+			OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+				lock.Lock()
+				defer lock.Unlock()
+				order = append(order, p)
+				return nil
+			},
+		})
+	}
+	running := make(chan bool, 1)
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		running <- true
+	})
+	go func() {
+		_ = l.Run()
+	}()
+	<-running
+	l.Stop(context.Background())
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []int{-5, 0, 10, 20}, order)
+}
+
+func TestHookSequentialMode(t *testing.T) {
+	lock := &sync.Mutex{}
+	var order []string
+	l := service.NewLifecycle(newTestService("Test service"))
+	for _, name := range []string{"deregister", "drain", "flush"} {
+		n := name
+		l.AddHook(service.Hook{
+			Mode: service.HookModeSequential,
+			OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+				// Give a misbehaving parallel implementation the chance to reorder the hooks.
+				time.Sleep(time.Millisecond)
+				lock.Lock()
+				defer lock.Unlock()
+				order = append(order, n)
+				return nil
+			},
+		})
+	}
+	running := make(chan bool, 1)
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		running <- true
+	})
+	go func() {
+		_ = l.Run()
+	}()
+	<-running
+	l.Stop(context.Background())
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"deregister", "drain", "flush"}, order)
+}
+
+func TestHookParallelMode(t *testing.T) {
+	// The two hooks wait for each other, which only works if they are executed in parallel.
+	barrier := &sync.WaitGroup{}
+	barrier.Add(2)
+	l := service.NewLifecycle(newTestService("Test service"))
+	for i := 0; i < 2; i++ {
+		l.AddHook(service.Hook{
+			Priority: 1,
+			Mode:     service.HookModeParallel,
+			OnStarting: func(s service.Service, l service.Lifecycle) error {
+				barrier.Done()
+				barrier.Wait()
+				return nil
+			},
+		})
+	}
+	running := make(chan bool, 1)
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		running <- true
+	})
+	go func() {
+		_ = l.Run()
+	}()
+	select {
+	case <-running:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("parallel hooks were not executed in parallel")
+	}
+	l.Stop(context.Background())
+}
+
+func TestStartingHookVeto(t *testing.T) {
+	serviceStarted := false
+	s := newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		serviceStarted = true
+		return nil
+	})
+	hookErr := errors.New("service discovery registration failed")
+	laterHookCalled := false
+	crashed := false
+	l := service.NewLifecycle(s).
+		AddHook(service.Hook{
+			OnStarting: func(s service.Service, l service.Lifecycle) error {
+				return hookErr
+			},
+		}).
+		AddHook(service.Hook{
+			Priority: 1,
+			OnStarting: func(s service.Service, l service.Lifecycle) error {
+				laterHookCalled = true
+				return nil
+			},
+		}).
+		OnCrashed(func(s service.Service, l service.Lifecycle, err error) {
+			crashed = true
+		})
+
+	err := l.Run()
+	assert.True(t, errors.Is(err, hookErr))
+	var hookError *service.HookError
+	assert.True(t, errors.As(err, &hookError))
+	assert.Equal(t, service.HookTypeStarting, hookError.Type)
+	assert.False(t, serviceStarted)
+	assert.False(t, laterHookCalled)
+	assert.True(t, crashed)
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.True(t, errors.Is(l.Wait(), hookErr))
+}
+
+func TestOptionalStartingHook(t *testing.T) {
+	hookErr := errors.New("optional registration failed")
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		lifecycle.Stopping()
+		return nil
+	})).AddHook(service.Hook{
+		Optional: true,
+		OnStarting: func(s service.Service, l service.Lifecycle) error {
+			return hookErr
+		},
+	})
+
+	assert.NoError(t, l.Run())
+	assert.Equal(t, service.StateStopped, l.State())
+	assert.True(t, errors.Is(l.Error(), hookErr))
+}
+
+func TestHookErrorsCollected(t *testing.T) {
+	runningErr := errors.New("running hook failed")
+	stoppedErr := errors.New("stopped hook failed")
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		lifecycle.Stopping()
+		return nil
+	})).AddHook(service.Hook{
+		OnRunning: func(s service.Service, l service.Lifecycle) error {
+			return runningErr
+		},
+		OnStopped: func(s service.Service, l service.Lifecycle) error {
+			return stoppedErr
+		},
+	})
+
+	assert.NoError(t, l.Run())
+	assert.Equal(t, service.StateStopped, l.State())
+	assert.NoError(t, l.Wait())
+	errs := service.Errors(l.Error())
+	assert.Len(t, errs, 2)
+	assert.True(t, errors.Is(l.Error(), runningErr))
+	assert.True(t, errors.Is(l.Error(), stoppedErr))
+	for _, err := range errs {
+		var hookError *service.HookError
+		assert.True(t, errors.As(err, &hookError))
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	l, err := service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{
+		Clock:       clock,
+		HookTimeout: 5 * time.Second,
+		Logger:      log.NewTestLogger(t),
+	})
+	assert.NoError(t, err)
+	release := make(chan struct{})
+	defer close(release)
+	stopping := make(chan struct{})
+	l.AddHook(service.Hook{
+		Name: "blocking hook",
+		OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+			close(stopping)
+			<-release
+			return nil
+		},
+	})
+	running := make(chan bool, 1)
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		running <- true
+	})
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Run()
+	}()
+	<-running
+	go l.Stop(context.Background())
+	<-stopping
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+	clock.Advance(5 * time.Second)
+	assert.NoError(t, <-result)
+
+	var hookError *service.HookError
+	assert.True(t, errors.As(l.Error(), &hookError))
+	assert.Equal(t, "blocking hook", hookError.Hook)
+	assert.Equal(t, service.HookTypeStopping, hookError.Type)
+	var timeoutError *service.HookTimeoutError
+	assert.True(t, errors.As(l.Error(), &timeoutError))
+}
+
+func TestHookPanic(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(
+		newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			lifecycle.Stopping()
+			return nil
+		}),
+		service.LifecycleConfig{
+			Logger: log.NewTestLogger(t),
+		},
+	)
+	assert.NoError(t, err)
+	l.OnRunning(panickingHook)
+
+	assert.NoError(t, l.Run())
+	assert.Equal(t, service.StateStopped, l.State())
+	var hookError *service.HookError
+	assert.True(t, errors.As(l.Error(), &hookError))
+	assert.Contains(t, hookError.Hook, "panickingHook")
+	var panicError *service.HookPanicError
+	assert.True(t, errors.As(l.Error(), &panicError))
+	assert.Equal(t, "hook bug", panicError.Value)
+	assert.Contains(t, string(panicError.Stack), "panickingHook")
+}
+
+func panickingHook(_ service.Service, _ service.Lifecycle) {
+	panic("hook bug")
+}
+
+func TestServicePanic(t *testing.T) {
+	crash := errors.New("crash")
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		panic(crash)
+	}))
+
+	err := l.Run()
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.EqualError(t, err, "service paniced (crash)")
+	assert.True(t, errors.Is(err, crash))
+	var panicError *service.ServicePanicError
+	if assert.True(t, errors.As(err, &panicError)) {
+		assert.Equal(t, crash, panicError.Value)
+		assert.Contains(t, string(panicError.Stack), "TestServicePanic")
+	}
+	var shared *service.PanicError
+	if assert.True(t, errors.As(err, &shared)) {
+		assert.Same(t, panicError.PanicError, shared)
+	}
+}
+
+func TestReadyAndDoneOnStartupCrash(t *testing.T) {
+	crash := errors.New("crash")
+	l := service.NewLifecycle(newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+		return crash
+	}))
+	result := make(chan error)
+	go func() {
+		result <- l.Run()
+	}()
+	select {
+	case <-l.Ready():
+		t.Fatalf("the service became ready despite crashing during startup")
+	case <-l.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout while waiting for the service to exit")
+	}
+	assert.Equal(t, crash, <-result)
+	state, err := l.WaitForState(context.Background(), service.StateStopped, service.StateCrashed)
+	assert.NoError(t, err)
+	assert.Equal(t, service.StateCrashed, state)
+}
+
+func TestWaitForStateTimeout(t *testing.T) {
+	l := service.NewLifecycle(newTestService("Test service"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := l.WaitForState(ctx, service.StateRunning)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Panics(t, func() {
+		_, _ = l.WaitForState(context.Background())
+	})
+}
+
+func TestRerun(t *testing.T) {
+	l := service.NewLifecycle(newTestService("Test service"))
+	assert.Equal(t, 0, l.Generation())
+	generations := make(chan int, 3)
+	l.OnStarting(func(s service.Service, l service.Lifecycle) {
+		generations <- l.Generation()
+	})
+	var shutdownErrors []error
+	l.OnStopping(func(s service.Service, l service.Lifecycle, shutdownContext context.Context) {
+		shutdownErrors = append(shutdownErrors, shutdownContext.Err())
+	})
+	for i := 1; i <= 3; i++ {
+		result := make(chan error)
+		go func() {
+			result <- l.Run()
+		}()
+		select {
+		case <-l.Ready():
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout while waiting for run %d to start", i)
+		}
+		assert.Equal(t, i, <-generations)
+		assert.Equal(t, i, l.Generation())
+		assert.False(t, l.ShouldStop(), "run %d started with a stopped context", i)
+		assert.NoError(t, l.Context().Err())
+		assert.NoError(t, l.ShutdownContext().Err())
+		l.Stop(context.Background())
+		assert.NoError(t, <-result)
+		assert.Equal(t, service.StateStopped, l.State())
+		assert.True(t, l.ShouldStop())
+	}
+	assert.Equal(t, []error{nil, nil, nil}, shutdownErrors, "a stopping hook received a stale shutdown context")
+}
+
+func TestRerunAfterCrash(t *testing.T) {
+	s := newTestService("Test service")
+	l := service.NewLifecycle(s)
+	hookErr := errors.New("hook failed")
+	l.AddHook(service.Hook{
+		OnStarting: func(s service.Service, l service.Lifecycle) error {
+			if l.Generation() == 1 {
+				return hookErr
+			}
+			return nil
+		},
+		Optional: true,
+	})
+	result := make(chan error)
+	go func() {
+		result <- l.Run()
+	}()
+	<-l.Ready()
+	s.Crash()
+	assert.Error(t, <-result)
+	assert.Len(t, service.Errors(l.Error()), 2)
+
+	go func() {
+		result <- l.Run()
+	}()
+	<-l.Ready()
+	assert.NoError(t, l.Error(), "the errors of the previous run were not reset")
+	l.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.NoError(t, l.Error())
+}
+
+func TestPreStopDelay(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	l, err := service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{
+		Clock:        clock,
+		PreStopDelay: 10 * time.Second,
+	})
+	assert.NoError(t, err)
+	stoppingHooks := 0
+	var lock sync.Mutex
+	l.OnStopping(func(s service.Service, l service.Lifecycle, shutdownContext context.Context) {
+		lock.Lock()
+		defer lock.Unlock()
+		stoppingHooks++
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Subscribe(ctx)
+	result := startLifecycle(t, l)
+
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop(context.Background())
+		close(stopped)
+	}()
+	_, err = l.WaitForState(context.Background(), service.StateStopping)
+	assert.NoError(t, err)
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+
+	// During the delay the service is unhealthy, but keeps running.
+	received := readEvents(t, events, 5)
+	assert.Equal(t, service.EventTypeHealthChange, received[4].Type)
+	assert.False(t, received[4].Healthy)
+	assert.False(t, l.ShouldStop())
+	assert.NoError(t, l.Context().Err())
+	lock.Lock()
+	assert.Equal(t, 1, stoppingHooks)
+	lock.Unlock()
+	select {
+	case <-stopped:
+		t.Fatalf("Stop() returned before the pre-stop delay expired")
+	default:
+	}
+
+	clock.Advance(10 * time.Second)
+	<-stopped
+	assert.NoError(t, <-result)
+	assert.Equal(t, service.StateStopped, l.State())
+	lock.Lock()
+	assert.Equal(t, 1, stoppingHooks, "the stopping hooks ran twice")
+	lock.Unlock()
+}
+
+func TestPreStopDelayDoubleStopping(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(
+		newCallbackService("Test service", func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			<-lifecycle.Context().Done()
+			lifecycle.Stopping()
+			lifecycle.Stopping()
+			return nil
+		}),
+		service.LifecycleConfig{PreStopDelay: time.Millisecond},
+	)
+	assert.NoError(t, err)
+	result := startLifecycle(t, l)
+	go l.Stop(context.Background())
+	err = <-result
+	var transitionError *service.IllegalTransitionError
+	if assert.True(t, errors.As(err, &transitionError), "the second Stopping() call was accepted") {
+		assert.Equal(t, service.StateStopping, transitionError.From)
+		assert.Equal(t, service.StateStopping, transitionError.To)
+	}
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func TestPreStopDelayShutdownContext(t *testing.T) {
+	l, err := service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{
+		PreStopDelay: time.Hour,
+	})
+	assert.NoError(t, err)
+	result := startLifecycle(t, l)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	l.Stop(ctx)
+	assert.NoError(t, <-result)
+}
+
+func TestPreStopDelayServiceExit(t *testing.T) {
+	s := newTestService("Test service")
+	l, err := service.NewLifecycleWithConfig(s, service.LifecycleConfig{
+		PreStopDelay: time.Hour,
+	})
+	assert.NoError(t, err)
+	result := startLifecycle(t, l)
+	go l.Stop(context.Background())
+	_, err = l.WaitForState(context.Background(), service.StateStopping)
+	assert.NoError(t, err)
+	s.Crash()
+	assert.Error(t, <-result)
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+// gatedClock blocks the callers of Now() while it is armed until the test releases them.
+type gatedClock struct {
+	*servicetest.FakeClock
+	lock    sync.Mutex
+	armed   bool
+	blocked chan chan struct{}
+}
+
+func (c *gatedClock) arm(armed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.armed = armed
+}
+
+func (c *gatedClock) Now() time.Time {
+	c.lock.Lock()
+	armed := c.armed
+	c.lock.Unlock()
+	if armed {
+		release := make(chan struct{})
+		c.blocked <- release
+		<-release
+	}
+	return c.FakeClock.Now()
+}
+
+func TestStateChangeEventsDuringTransition(t *testing.T) {
+	clock := &gatedClock{
+		FakeClock: servicetest.NewFakeClock(time.Now()),
+		blocked:   make(chan chan struct{}, 2),
+	}
+	l, err := service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{
+		Clock:        clock,
+		PreStopDelay: 10 * time.Second,
+	})
+	assert.NoError(t, err)
+	l.OnStarting(func(s service.Service, l service.Lifecycle) {
+		clock.arm(true)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Subscribe(ctx)
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Run()
+	}()
+
+	// The running event is held up until the lifecycle has already moved on to stopping.
+	running := <-clock.blocked
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop(context.Background())
+		close(stopped)
+	}()
+	_, err = l.WaitForState(context.Background(), service.StateStopping)
+	assert.NoError(t, err)
+	stopping := <-clock.blocked
+	clock.arm(false)
+	close(running)
+	states := stateChanges(t, events, 2)
+	close(stopping)
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+	clock.Advance(10 * time.Second)
+	<-stopped
+	assert.NoError(t, <-result)
+
+	states = append(states, stateChanges(t, events, 2)...)
+	assert.Equal(
+		t,
+		[]service.State{service.StateStarting, service.StateRunning, service.StateStopping, service.StateStopped},
+		states,
+	)
+}
+
+// stateChanges reads events until count state change events have been received and returns their states.
+func stateChanges(t *testing.T, events <-chan service.Event, count int) []service.State {
+	t.Helper()
+	var states []service.State
+	for len(states) < count {
+		for _, event := range readEvents(t, events, 1) {
+			if event.Type == service.EventTypeStateChange {
+				states = append(states, event.State)
+			}
+		}
+	}
+	return states
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+)
+
+// stateTransitions is the table of legal state transitions. The key is the state the lifecycle is currently in, the
+// value is the list of states the lifecycle may move to from there.
+var stateTransitions = map[State][]State{
+	StateStopped:  {StateStarting},
+	StateStarting: {StateRunning, StateStopping, StateStopped, StateCrashed},
+	StateRunning:  {StateStopping, StateStopped, StateCrashed},
+	StateStopping: {StateStopped, StateCrashed},
+	StateCrashed:  {StateStarting},
+}
+
+// CanTransition returns true if a lifecycle is allowed to move from the "from" state to the "to" state.
+func CanTransition(from State, to State) bool {
+	for _, allowed := range stateTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IllegalTransitionError is the error raised when a service attempts to move its lifecycle into a state that is not
+// reachable from the current state, for example by calling Running() after Stopping(). This always indicates a bug
+// in the service.
+type IllegalTransitionError struct {
+	// Service is the name of the service that attempted the transition.
+	Service string
+	// From is the state the lifecycle was in.
+	From State
+	// To is the state the service attempted to move to.
+	To State
+}
+
+// Error returns the error message.
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("illegal state transition of %s from %s to %s", e.Service, e.From, e.To)
+}
+
+// TransitionPolicy determines how the default lifecycle reacts to an illegal state transition.
+type TransitionPolicy string
+
+const (
+	// TransitionPolicyError rejects the illegal transition and crashes the service with an IllegalTransitionError
+	// once RunWithLifecycle returns. This is the default.
+	TransitionPolicyError TransitionPolicy = "error"
+	// TransitionPolicyLog rejects the illegal transition and logs it, but otherwise lets the service continue.
+	TransitionPolicyLog TransitionPolicy = "log"
+	// TransitionPolicyPanic panics with an IllegalTransitionError in the goroutine performing the transition.
+	TransitionPolicyPanic TransitionPolicy = "panic"
+)
+
+// Validate checks if the transition policy is one of the supported values.
+func (p TransitionPolicy) Validate() error {
+	switch p {
+	case "", TransitionPolicyError, TransitionPolicyLog, TransitionPolicyPanic:
+		return nil
+	default:
+		return fmt.Errorf("invalid transition policy: %s", p)
+	}
+}
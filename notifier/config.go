@@ -0,0 +1,99 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/containerssh/service"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 3
+	defaultRetryDelay  = time.Second
+	defaultBatchSize   = 16
+	defaultQueueSize   = 256
+)
+
+// Config holds the settings of the notifier.
+type Config struct {
+	// Endpoints are the HTTP or HTTPS URLs the notifications are POSTed to. At least one endpoint is required.
+	Endpoints []string
+
+	// Headers are added to every request, for example to authenticate with the endpoints.
+	Headers map[string]string
+
+	// States are the states of services that trigger a notification. Defaults to StateCrashed.
+	States []service.State
+
+	// Hostname is sent with every notification to identify this process. Defaults to the hostname of the machine.
+	Hostname string
+
+	// Timeout bounds a single request. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// MaxAttempts is the number of times a batch is sent to an endpoint before it is given up. Defaults to 3.
+	MaxAttempts int
+
+	// RetryDelay is the wait before the first retry of a failed request. It is doubled for every further retry.
+	// Defaults to 1 second.
+	RetryDelay time.Duration
+
+	// BatchSize is the maximum number of notifications sent in a single request. Defaults to 16.
+	BatchSize int
+
+	// BatchDelay is the time the notifier waits for more notifications before sending a batch. Zero sends
+	// notifications as soon as possible.
+	BatchDelay time.Duration
+
+	// QueueSize is the maximum number of notifications waiting to be sent. When the queue is full, the oldest
+	// notifications are discarded. Defaults to 256.
+	QueueSize int
+
+	// Clock is used for the batch and retry delays. Defaults to the system clock.
+	Clock service.Clock
+}
+
+// Validate checks the notifier configuration for errors.
+func (c *Config) Validate() error {
+	if len(c.Endpoints) == 0 {
+		return fmt.Errorf("no notification endpoints provided")
+	}
+	for _, endpoint := range c.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid notification endpoint %s (%w)", endpoint, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid notification endpoint %s: only http and https URLs are supported", endpoint)
+		}
+	}
+	for _, state := range c.States {
+		switch state {
+		case service.StateStopped, service.StateStarting, service.StateRunning, service.StateStopping,
+			service.StateCrashed:
+		default:
+			return fmt.Errorf("invalid state: %s", state)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("the timeout must not be negative")
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("the maximum number of attempts must not be negative")
+	}
+	if c.RetryDelay < 0 {
+		return fmt.Errorf("the retry delay must not be negative")
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("the batch size must not be negative")
+	}
+	if c.BatchDelay < 0 {
+		return fmt.Errorf("the batch delay must not be negative")
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("the queue size must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+// Package notifier sends webhook notifications about the services of a pool, such as crashes, to HTTP endpoints.
+package notifier
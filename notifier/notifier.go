@@ -0,0 +1,387 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+
+	"github.com/containerssh/service"
+)
+
+// Notification describes a state change of a service. Each request POSTs a JSON array of notifications.
+type Notification struct {
+	// ID is the ID of the service in the pool. It is empty for the services of nested pools.
+	ID string `json:"id,omitempty"`
+	// Service is the name of the service as returned by String().
+	Service string `json:"service"`
+	// State is the state the service has entered.
+	State service.State `json:"state"`
+	// Error is the error of the service, if any.
+	Error string `json:"error,omitempty"`
+	// Stack is the stack trace of the panic that crashed the service, if any.
+	Stack string `json:"stack,omitempty"`
+	// Host is the hostname of the machine running the service.
+	Host string `json:"host"`
+	// Timestamp is the time the service entered the state.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier POSTs notifications about the state changes of the services in a pool to HTTP endpoints. It watches the
+// pool from its creation until it is closed.
+type Notifier interface {
+	// Close stops watching the pool and sends the notifications that are still queued. Call it after the pool has
+	//       stopped, so the shutdown of the services is reported as well. Sending ends when the shutdown context
+	//       ends, in which case the remaining notifications are discarded and an error is returned.
+	Close(shutdownContext context.Context) error
+}
+
+// New creates a notifier that watches the services of the pool, including the services of nested pools. It returns
+// an error if the configuration is invalid.
+func New(config Config, pool service.Pool, logger log.Logger) (Notifier, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if len(config.States) == 0 {
+		config.States = []service.State{service.StateCrashed}
+	}
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = defaultRetryDelay
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Clock == nil {
+		config.Clock = service.NewRealClock()
+	}
+	states := make(map[service.State]struct{}, len(config.States))
+	for _, state := range config.States {
+		states[state] = struct{}{}
+	}
+
+	subscriptionContext, cancelSubscription := context.WithCancel(context.Background())
+	sendContext, cancelSend := context.WithCancel(context.Background())
+	n := &notifier{
+		config:             config,
+		pool:               pool,
+		logger:             logger,
+		client:             &http.Client{},
+		states:             states,
+		mutex:              &sync.Mutex{},
+		wake:               make(chan struct{}, 1),
+		cancelSubscription: cancelSubscription,
+		cancelSend:         cancelSend,
+		received:           make(chan struct{}),
+		flush:              make(chan struct{}),
+		sent:               make(chan struct{}),
+		closeOnce:          &sync.Once{},
+	}
+	// The subscription blocks instead of dropping events during bursts. Receiving is quick, since the queue discards
+	// and counts the oldest notifications itself when it is full.
+	events := pool.SubscribeWithOptions(subscriptionContext, service.SubscribeOptions{Policy: service.EventPolicyBlock})
+	go n.receiveAll(events)
+	go n.send(sendContext)
+	return n, nil
+}
+
+type notifier struct {
+	config Config
+	pool   service.Pool
+	logger log.Logger
+	client *http.Client
+	states map[service.State]struct{}
+
+	mutex *sync.Mutex
+	queue []Notification
+	// dropped is the number of notifications discarded since it was last logged.
+	dropped int
+	// wake receives a value when notifications have been queued.
+	wake chan struct{}
+	// aborted is set when the sender has exited because its context ended while delivering a batch.
+	aborted bool
+
+	cancelSubscription func()
+	cancelSend         func()
+	// received is closed when the subscription has ended and all events have been queued.
+	received chan struct{}
+	// flush is closed when the sender should send the remaining notifications and exit.
+	flush chan struct{}
+	// sent is closed when the sender has exited.
+	sent      chan struct{}
+	closeOnce *sync.Once
+	closeErr  error
+}
+
+func (n *notifier) Close(shutdownContext context.Context) error {
+	n.closeOnce.Do(func() {
+		// The events published before the subscription ended are still buffered and queued before flushing.
+		n.cancelSubscription()
+		<-n.received
+		close(n.flush)
+
+		select {
+		case <-n.sent:
+		case <-shutdownContext.Done():
+			n.cancelSend()
+			<-n.sent
+		}
+		n.cancelSend()
+		n.mutex.Lock()
+		incomplete := n.aborted || len(n.queue) > 0
+		n.dropped += len(n.queue)
+		n.queue = nil
+		n.mutex.Unlock()
+		n.logDropped()
+		if incomplete {
+			n.closeErr = fmt.Errorf(
+				"failed to send all notifications before the shutdown deadline (%w)",
+				shutdownContext.Err(),
+			)
+		}
+	})
+	return n.closeErr
+}
+
+// receiveAll queues the notifications for the events until the subscription ends.
+func (n *notifier) receiveAll(events <-chan service.Event) {
+	defer close(n.received)
+	ids := map[service.Service]string{}
+	for event := range events {
+		n.receive(event, ids)
+	}
+}
+
+// receive queues a notification for the event if it is a state change to one of the configured states. The IDs of
+// the services in the pool are looked up and cached in ids.
+func (n *notifier) receive(event service.Event, ids map[service.Service]string) {
+	if event.Type != service.EventTypeStateChange {
+		return
+	}
+	if _, ok := n.states[event.State]; !ok {
+		return
+	}
+	id, ok := ids[event.Service]
+	if !ok {
+		for _, info := range n.pool.Services() {
+			ids[info.Service] = info.ID
+		}
+		id = ids[event.Service]
+	}
+	notification := Notification{
+		ID:        id,
+		Service:   event.Service.String(),
+		State:     event.State,
+		Host:      n.config.Hostname,
+		Timestamp: event.Time,
+	}
+	if event.Error != nil {
+		notification.Error = event.Error.Error()
+		notification.Stack = panicStack(event.Error)
+	}
+	n.enqueue(notification)
+}
+
+// enqueue adds the notification to the queue, discarding the oldest notification if the queue is full.
+func (n *notifier) enqueue(notification Notification) {
+	n.mutex.Lock()
+	if len(n.queue) >= n.config.QueueSize {
+		n.queue = n.queue[1:]
+		n.dropped++
+	}
+	n.queue = append(n.queue, notification)
+	n.mutex.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next removes the next batch from the queue. It returns nil if the queue is empty.
+func (n *notifier) next() []Notification {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if len(n.queue) == 0 {
+		return nil
+	}
+	size := n.config.BatchSize
+	if size > len(n.queue) {
+		size = len(n.queue)
+	}
+	batch := append([]Notification{}, n.queue[:size]...)
+	n.queue = n.queue[size:]
+	return batch
+}
+
+// send delivers the queued notifications in batches. It returns once the flush channel is closed and the queue is
+// empty, or when the context ends.
+func (n *notifier) send(ctx context.Context) {
+	defer close(n.sent)
+	flush := n.flush
+	for {
+		flushing := false
+		select {
+		case <-n.wake:
+		case <-flush:
+			flushing = true
+		}
+		if !flushing && n.config.BatchDelay > 0 {
+			timer := n.config.Clock.NewTimer(n.config.BatchDelay)
+			select {
+			case <-timer.C():
+			case <-flush:
+				timer.Stop()
+			}
+		}
+		n.logDropped()
+		for batch := n.next(); batch != nil; batch = n.next() {
+			if !n.deliver(ctx, batch) {
+				n.mutex.Lock()
+				n.dropped += len(batch)
+				n.aborted = true
+				n.mutex.Unlock()
+				return
+			}
+		}
+		if flushing {
+			return
+		}
+	}
+}
+
+// deliver sends the batch to all endpoints. Failures are logged. It returns false if the context ended before the
+// batch was delivered.
+func (n *notifier) deliver(ctx context.Context, batch []Notification) bool {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		panic("bug: failed to encode notifications: " + err.Error())
+	}
+	for _, endpoint := range n.config.Endpoints {
+		if err := n.post(ctx, endpoint, body); err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			n.logger.Warning(
+				log.Wrap(
+					err,
+					service.EServiceNotificationFailed,
+					"failed to send %d notifications to %s",
+					len(batch),
+					endpoint,
+				).Label("endpoint", endpoint),
+			)
+		}
+	}
+	return true
+}
+
+// post sends the body to the endpoint, retrying failed requests with an increasing delay.
+func (n *notifier) post(ctx context.Context, endpoint string, body []byte) error {
+	delay := n.config.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := n.request(ctx, endpoint, body)
+		var statusErr *statusError
+		if err == nil || attempt >= n.config.MaxAttempts || (errors.As(err, &statusErr) && !statusErr.retryable()) {
+			return err
+		}
+		timer := n.config.Clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// request POSTs the body to the endpoint once.
+func (n *notifier) request(ctx context.Context, endpoint string, body []byte) error {
+	requestContext, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(requestContext, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range n.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &statusError{code: response.StatusCode, status: response.Status}
+	}
+	return nil
+}
+
+// logDropped logs the notifications discarded since the last call, if any.
+func (n *notifier) logDropped() {
+	n.mutex.Lock()
+	dropped := n.dropped
+	n.dropped = 0
+	n.mutex.Unlock()
+	if dropped > 0 {
+		n.logger.Warning(
+			log.NewMessage(
+				service.EServiceNotificationsDropped,
+				"%d notifications have been discarded",
+				dropped,
+			),
+		)
+	}
+}
+
+// statusError is returned when an endpoint responds with a status other than 2xx.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response status %s", e.status)
+}
+
+// retryable returns false for client errors that will not go away by sending the same request again.
+func (e *statusError) retryable() bool {
+	if e.code == http.StatusRequestTimeout || e.code == http.StatusTooManyRequests {
+		return true
+	}
+	return e.code < 400 || e.code > 499
+}
+
+// panicStack returns the stack trace of the panic that caused the error, or an empty string if the error was not
+// caused by a panic.
+func panicStack(err error) string {
+	var panicError *service.PanicError
+	if errors.As(err, &panicError) {
+		return string(panicError.Stack)
+	}
+	return ""
+}
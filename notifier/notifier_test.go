@@ -0,0 +1,344 @@
+package notifier_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+	"github.com/containerssh/service/notifier"
+	"github.com/containerssh/service/servicetest"
+)
+
+// webhook is an HTTP endpoint recording the notifications it receives.
+type webhook struct {
+	mutex    sync.Mutex
+	attempts int
+	batches  [][]notifier.Notification
+	headers  []http.Header
+	// status returns the response status for the specified attempt, starting with 1.
+	status func(attempt int) int
+	// received receives every batch that was accepted.
+	received chan []notifier.Notification
+}
+
+func newWebhook(t *testing.T, status func(attempt int) int) (*webhook, *httptest.Server) {
+	w := &webhook{
+		status:   status,
+		received: make(chan []notifier.Notification, 16),
+	}
+	server := httptest.NewServer(w)
+	t.Cleanup(server.Close)
+	return w, server
+}
+
+func (w *webhook) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var batch []notifier.Notification
+	if err := json.NewDecoder(request.Body).Decode(&batch); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.mutex.Lock()
+	w.attempts++
+	status := http.StatusNoContent
+	if w.status != nil {
+		status = w.status(w.attempts)
+	}
+	if status < 300 {
+		w.batches = append(w.batches, batch)
+		w.headers = append(w.headers, request.Header)
+	}
+	w.mutex.Unlock()
+	writer.WriteHeader(status)
+	if status < 300 {
+		w.received <- batch
+	}
+}
+
+func (w *webhook) wait(t *testing.T) []notifier.Notification {
+	t.Helper()
+	select {
+	case batch := <-w.received:
+		return batch
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout while waiting for a notification")
+		return nil
+	}
+}
+
+// testService runs until it is stopped, or panics when panicking is closed.
+type testService struct {
+	name      string
+	panicking chan struct{}
+}
+
+func newTestService(name string) *testService {
+	return &testService{
+		name:      name,
+		panicking: make(chan struct{}),
+	}
+}
+
+func (s *testService) String() string {
+	return s.name
+}
+
+func (s *testService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Running()
+	select {
+	case <-lifecycle.Context().Done():
+		lifecycle.Stopping()
+		return nil
+	case <-s.panicking:
+		panic("out of coffee")
+	}
+}
+
+func startPool(t *testing.T, pool service.Pool) (service.Lifecycle, <-chan error) {
+	t.Helper()
+	lifecycle := service.NewLifecycle(pool)
+	result := make(chan error, 1)
+	go func() {
+		result <- lifecycle.Run()
+	}()
+	select {
+	case <-lifecycle.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout while waiting for the pool to start")
+	}
+	return lifecycle, result
+}
+
+func TestCrashNotification(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	hook, server := newWebhook(t, nil)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	worker := newTestService("Worker")
+	_, err := pool.AddWithOptions(worker, service.ServiceOptions{ID: "worker"})
+	assert.NoError(t, err)
+	n, err := notifier.New(notifier.Config{
+		Endpoints: []string{server.URL + "/hooks/crash"},
+		Headers:   map[string]string{"Authorization": "Bearer secret"},
+		Hostname:  "test-host",
+	}, pool, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, result := startPool(t, pool)
+	close(worker.panicking)
+	assert.Error(t, <-result)
+
+	batch := hook.wait(t)
+	if assert.Len(t, batch, 1) {
+		notification := batch[0]
+		assert.Equal(t, "worker", notification.ID)
+		assert.Equal(t, "Worker", notification.Service)
+		assert.Equal(t, service.StateCrashed, notification.State)
+		assert.Contains(t, notification.Error, "out of coffee")
+		assert.Contains(t, notification.Stack, "goroutine")
+		assert.Equal(t, "test-host", notification.Host)
+		assert.False(t, notification.Timestamp.IsZero())
+	}
+	hook.mutex.Lock()
+	assert.Equal(t, "Bearer secret", hook.headers[0].Get("Authorization"))
+	assert.Equal(t, "application/json", hook.headers[0].Get("Content-Type"))
+	hook.mutex.Unlock()
+	assert.NoError(t, n.Close(context.Background()))
+}
+
+func TestRetries(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	flaky, flakyServer := newWebhook(t, func(attempt int) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	rejecting, rejectingServer := newWebhook(t, func(int) int {
+		return http.StatusBadRequest
+	})
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	pool.Add(newTestService("Web server"))
+	n, err := notifier.New(notifier.Config{
+		Endpoints:  []string{flakyServer.URL, rejectingServer.URL},
+		States:     []service.State{service.StateRunning},
+		RetryDelay: time.Millisecond,
+	}, pool, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	poolLifecycle, result := startPool(t, pool)
+	batch := flaky.wait(t)
+	assert.Len(t, batch, 1)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.NoError(t, n.Close(context.Background()))
+
+	flaky.mutex.Lock()
+	assert.Equal(t, 3, flaky.attempts)
+	flaky.mutex.Unlock()
+	rejecting.mutex.Lock()
+	assert.Equal(t, 1, rejecting.attempts, "client errors must not be retried")
+	rejecting.mutex.Unlock()
+}
+
+func TestBatchingAndFlush(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	hook, server := newWebhook(t, nil)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		pool.Add(newTestService(name))
+	}
+	// The batch delay never expires on the fake clock, so all notifications are sent when the notifier is closed.
+	n, err := notifier.New(notifier.Config{
+		Endpoints:  []string{server.URL},
+		States:     []service.State{service.StateRunning},
+		BatchSize:  2,
+		BatchDelay: time.Hour,
+		QueueSize:  3,
+		Clock:      servicetest.NewFakeClock(time.Now()),
+	}, pool, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	poolLifecycle, result := startPool(t, pool)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	hook.mutex.Lock()
+	assert.Empty(t, hook.batches, "notifications were sent before the batch delay")
+	hook.mutex.Unlock()
+	assert.NoError(t, n.Close(context.Background()))
+
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	if assert.Len(t, hook.batches, 2) {
+		// The queue only holds 3 notifications, the oldest one is discarded.
+		assert.Len(t, hook.batches[0], 2)
+		assert.Len(t, hook.batches[1], 1)
+	}
+}
+
+func TestCloseShutdownDeadline(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-release:
+		case <-request.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	pool.Add(newTestService("Web server"))
+	n, err := notifier.New(notifier.Config{
+		Endpoints: []string{server.URL},
+		States:    []service.State{service.StateRunning, service.StateStopped},
+	}, pool, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	poolLifecycle, result := startPool(t, pool)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = n.Close(ctx)
+	assert.True(t, time.Since(start) < 5*time.Second, "Close did not respect the shutdown context")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// slowPool delays the first lookup of the services by the notifier until release is closed.
+type slowPool struct {
+	service.Pool
+	once    sync.Once
+	release chan struct{}
+}
+
+func (p *slowPool) Services() []service.ServiceInfo {
+	p.once.Do(func() {
+		<-p.release
+	})
+	return p.Pool.Services()
+}
+
+func TestEventBurst(t *testing.T) {
+	logger := log.NewTestLogger(t)
+	hook, server := newWebhook(t, nil)
+	pool := &slowPool{
+		Pool:    service.NewPool(service.NewLifecycleFactory(), logger),
+		release: make(chan struct{}),
+	}
+	const services = 40
+	for i := 0; i < services; i++ {
+		_, err := pool.AddWithOptions(newTestService("Worker"), service.ServiceOptions{ID: fmt.Sprintf("worker-%d", i)})
+		assert.NoError(t, err)
+	}
+	n, err := notifier.New(notifier.Config{
+		Endpoints: []string{server.URL},
+		States:    []service.State{service.StateRunning},
+	}, pool, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The notifier is stuck on the first event while the services keep starting, which overflows any event buffer.
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error, 1)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(pool.release)
+	select {
+	case <-poolLifecycle.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout while waiting for the pool to start")
+	}
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.NoError(t, n.Close(context.Background()))
+
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	ids := map[string]struct{}{}
+	for _, batch := range hook.batches {
+		for _, notification := range batch {
+			ids[notification.ID] = struct{}{}
+		}
+	}
+	assert.Len(t, ids, services, "notifications were lost")
+}
+
+func TestConfigValidation(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	for name, config := range map[string]notifier.Config{
+		"no endpoints":   {},
+		"invalid scheme": {Endpoints: []string{"ftp://example.com"}},
+		"no host":        {Endpoints: []string{"http://"}},
+		"invalid state":  {Endpoints: []string{"http://example.com"}, States: []service.State{"exploded"}},
+		"negative delay": {Endpoints: []string{"http://example.com"}, RetryDelay: -time.Second},
+		"negative queue": {Endpoints: []string{"http://example.com"}, QueueSize: -1},
+	} {
+		_, err := notifier.New(config, pool, log.NewTestLogger(t))
+		assert.Error(t, err, name)
+	}
+	n, err := notifier.New(notifier.Config{Endpoints: []string{"https://example.com/hook"}}, pool, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	assert.NoError(t, n.Close(context.Background()))
+}
@@ -1,9 +1,52 @@
 package service
 
+import "context"
+
 // Pool is a handler for multiple services at once. It will run services in parallel in goroutines and terminate all
-//      services once a single one has exited.
+//      services once a single one has exited, unless the service is optional or restarted according to its restart
+//      policy.
 type Pool interface {
 	Service
 
+	// Add adds a service to the pool with the default options and returns its lifecycle. The service is registered
+	//     with an ID generated from its name. Must be called before the pool is run.
 	Add(s Service) Lifecycle
+
+	// AddWithOptions adds a service to the pool with the specified options and returns its lifecycle. It returns a
+	//                *DuplicateServiceIDError if a service with the same ID is already registered. Must be called
+	//                before the pool is run.
+	AddWithOptions(s Service, options ServiceOptions) (Lifecycle, error)
+
+	// Services returns the services registered in the pool in the order they were added.
+	Services() []ServiceInfo
+
+	// ServicesByLabels returns the services that have all of the specified labels with the specified values.
+	ServicesByLabels(labels map[string]string) []ServiceInfo
+
+	// StopService stops a single service without shutting down the pool and waits for it to exit. The shutdown
+	//             context is passed to the service and bounds the wait, returning its error if it ends first. It
+	//             returns a *ServiceNotFoundError if the ID is not registered, and ErrPoolNotRunning if the pool is
+	//             not running.
+	StopService(shutdownContext context.Context, id string) error
+
+	// RestartService stops a single service without shutting down the pool, starts it again and waits until it is
+	//                running. Services stopped with StopService can be started again this way. The context is used as
+	//                the shutdown context and bounds the wait for the service to become running.
+	RestartService(ctx context.Context, id string) error
+
+	// History returns the most recent state changes of a service, oldest first.
+	History(id string) ([]StateChange, error)
+
+	// Lifecycle returns the lifecycle of the service with the specified ID, or false if no such service is
+	//           registered.
+	Lifecycle(id string) (Lifecycle, bool)
+
+	// Subscribe returns a channel that receives the events of all services in the pool, including the services of
+	// nested pools. The subscription ends and the channel is closed when the context ends.
+	Subscribe(ctx context.Context) <-chan Event
+
+	// SubscribeWithOptions works like Subscribe, but the buffer size and the policy of the subscription can be set
+	//                      independently of the pool configuration, for example to receive every event with
+	//                      EventPolicyBlock. It panics if the options are invalid.
+	SubscribeWithOptions(ctx context.Context, options SubscribeOptions) <-chan Event
 }
@@ -0,0 +1,74 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+
+	"github.com/containerssh/service"
+)
+
+// BenchmarkPool measures the time it takes a pool to start and to shut down a large number of services. The startup
+// and shutdown latency of each run is reported as separate metrics.
+func BenchmarkPool(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		for _, concurrency := range []int{0, 64} {
+			b.Run(fmt.Sprintf("services=%d/concurrency=%d", size, concurrency), func(b *testing.B) {
+				benchmarkPool(b, size, concurrency)
+			})
+		}
+	}
+}
+
+func benchmarkPool(b *testing.B, size int, concurrency int) {
+	logger, err := log.NewLogger(log.Config{
+		Level:       log.LevelError,
+		Format:      log.FormatLJSON,
+		Destination: log.DestinationStdout,
+		Stdout:      ioutil.Discard,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	var startup, shutdown time.Duration
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pool, err := service.NewPoolWithConfig(
+			service.PoolConfig{
+				StartConcurrency: concurrency,
+				StopConcurrency:  concurrency,
+			},
+			service.NewLifecycleFactory(),
+			logger,
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < size; j++ {
+			pool.Add(newTestService(fmt.Sprintf("Test service %d", j)))
+		}
+		l := service.NewLifecycle(pool)
+		result := make(chan error)
+		b.StartTimer()
+
+		start := time.Now()
+		go func() {
+			result <- l.Run()
+		}()
+		<-l.Ready()
+		startup += time.Since(start)
+
+		start = time.Now()
+		l.Stop(context.Background())
+		if err := <-result; err != nil {
+			b.Fatal(err)
+		}
+		shutdown += time.Since(start)
+	}
+	b.ReportMetric(float64(startup.Nanoseconds())/float64(b.N), "startup-ns/op")
+	b.ReportMetric(float64(shutdown.Nanoseconds())/float64(b.N), "shutdown-ns/op")
+}
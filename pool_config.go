@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// PoolConfig holds the settings for a service pool. The zero value is a valid configuration.
+type PoolConfig struct {
+	// Clock is the source of time for timeouts. Defaults to the system clock.
+	Clock Clock
+
+	// ShutdownTimeout bounds the shutdown context handed to the remaining services when the pool shuts down because
+	// one of its services has exited. Zero means no timeout.
+	ShutdownTimeout time.Duration
+
+	// StartConcurrency is the maximum number of services that may be starting at the same time. A service occupies a
+	// slot from the moment it is launched until it is running or has exited. Zero means no limit.
+	StartConcurrency int
+
+	// StartDelay is the time the pool waits between starting two services. Zero means no delay.
+	StartDelay time.Duration
+
+	// StartJitter is the upper bound of a random delay added to StartDelay, spreading out the start of services.
+	// Zero means no jitter.
+	StartJitter time.Duration
+
+	// StopConcurrency is the maximum number of services that may be stopping at the same time. A service occupies a
+	// slot from the moment the pool stops it until it has exited. Zero means no limit.
+	StopConcurrency int
+
+	// PreStopDelay is the time the pool keeps its services running after it has been asked to stop and has entered the
+	// "stopping" state, before it starts stopping the services. This gives load balancers watching the state of the
+	// pool time to stop sending requests. The delay is bounded by the shutdown context and ends early if a service
+	// exits. It adds to the pre-stop delays of the lifecycles. Zero means no delay.
+	PreStopDelay time.Duration
+
+	// StateHistorySize is the number of state changes kept per service for History(). Defaults to 32.
+	StateHistorySize int
+
+	// EventBufferSize is the number of events buffered for each subscriber of the pool. Defaults to 16.
+	EventBufferSize int
+
+	// EventPolicy determines what happens to events when the buffer of a subscriber of the pool is full. Defaults
+	// to EventPolicyDrop.
+	EventPolicy EventPolicy
+}
+
+// Validate checks the pool configuration for errors.
+func (c *PoolConfig) Validate() error {
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("the shutdown timeout must not be negative")
+	}
+	if c.StartConcurrency < 0 {
+		return fmt.Errorf("the start concurrency must not be negative")
+	}
+	if c.StartDelay < 0 {
+		return fmt.Errorf("the start delay must not be negative")
+	}
+	if c.StartJitter < 0 {
+		return fmt.Errorf("the start jitter must not be negative")
+	}
+	if c.StopConcurrency < 0 {
+		return fmt.Errorf("the stop concurrency must not be negative")
+	}
+	if c.PreStopDelay < 0 {
+		return fmt.Errorf("the pre-stop delay must not be negative")
+	}
+	if c.StateHistorySize < 0 {
+		return fmt.Errorf("the state history size must not be negative")
+	}
+	if c.EventBufferSize < 0 {
+		return fmt.Errorf("the event buffer size must not be negative")
+	}
+	return c.EventPolicy.Validate()
+}
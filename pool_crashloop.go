@@ -0,0 +1,67 @@
+package service
+
+import (
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// detectCrashLoop records a crash of the service and opens its circuit if the service has crashed too often within
+// the crash loop window. It returns true if the circuit has been opened. It must be called with the mutex held.
+func (p *pool) detectCrashLoop(entry *poolEntry) bool {
+	config := entry.options.CrashLoop
+	if config.MaxCrashes <= 0 || entry.state != StateCrashed {
+		return false
+	}
+	now := p.config.Clock.Now()
+	crashes := entry.crashes[:0]
+	for _, crash := range entry.crashes {
+		if now.Sub(crash) < config.Window {
+			crashes = append(crashes, crash)
+		}
+	}
+	entry.crashes = append(crashes, now)
+	if len(entry.crashes) < config.MaxCrashes {
+		return false
+	}
+	entry.crashes = nil
+	entry.circuitOpen = true
+	if !config.StopPool || entry.options.Optional {
+		entry.circuitOpenUntil = now.Add(config.Cooldown)
+	}
+	return true
+}
+
+// closeCircuit lets the service be restarted normally again. It must be called with the mutex held.
+func (e *poolEntry) closeCircuit() {
+	e.circuitOpen = false
+	e.circuitOpenUntil = time.Time{}
+}
+
+// logCrashLoop logs that the circuit of a service has been opened.
+func (p *pool) logCrashLoop(entry *poolEntry, exitError error, stop bool) {
+	config := entry.options.CrashLoop
+	name := entry.service.String()
+	var message log.Message
+	if stop {
+		message = log.Wrap(
+			exitError,
+			EServiceCrashLoop,
+			"%s has crashed %d times within %s, stopping all services",
+			name,
+			config.MaxCrashes,
+			config.Window,
+		)
+	} else {
+		message = log.Wrap(
+			exitError,
+			EServiceCrashLoop,
+			"%s has crashed %d times within %s and will be restarted in %s",
+			name,
+			config.MaxCrashes,
+			config.Window,
+			config.Cooldown,
+		)
+	}
+	p.logger.Error(message.Label("service", name))
+}
@@ -0,0 +1,192 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+	"github.com/containerssh/service/servicetest"
+)
+
+// crashLoopService crashes once for every value sent to crash. It reports every run on running once it is running.
+type crashLoopService struct {
+	crash   chan struct{}
+	running chan struct{}
+}
+
+func newCrashLoopService() *crashLoopService {
+	return &crashLoopService{
+		crash:   make(chan struct{}, 10),
+		running: make(chan struct{}, 10),
+	}
+}
+
+func (c *crashLoopService) String() string {
+	return "Crash loop"
+}
+
+func (c *crashLoopService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Running()
+	c.running <- struct{}{}
+	select {
+	case <-c.crash:
+		return errors.New("crash")
+	case <-lifecycle.Context().Done():
+		lifecycle.Stopping()
+		return nil
+	}
+}
+
+// waitRunning waits for the next run of the service to be running.
+func (c *crashLoopService) waitRunning(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.running:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout while waiting for the service to run")
+	}
+}
+
+func newCrashLoopPool(
+	t *testing.T,
+	logger log.Logger,
+	clock service.Clock,
+	config service.CrashLoopConfig,
+) (service.Pool, *crashLoopService) {
+	pool, err := service.NewPoolWithConfig(service.PoolConfig{Clock: clock}, service.NewLifecycleFactory(), logger)
+	assert.NoError(t, err)
+	s := newCrashLoopService()
+	_, err = pool.AddWithOptions(s, service.ServiceOptions{
+		ID:        "looping",
+		Restart:   service.RestartOnFailure,
+		CrashLoop: config,
+	})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Other service"), service.ServiceOptions{ID: "other"})
+	assert.NoError(t, err)
+	return pool, s
+}
+
+func TestPoolCrashLoopCooldown(t *testing.T) {
+	start := time.Now()
+	clock := servicetest.NewFakeClock(start)
+	logger, output := newRecordingLogger(t)
+	pool, s := newCrashLoopPool(t, logger, clock, service.CrashLoopConfig{
+		MaxCrashes: 3,
+		Window:     time.Minute,
+		Cooldown:   30 * time.Second,
+	})
+	l, _ := pool.Lifecycle("looping")
+
+	poolLifecycle, result := startPool(t, pool)
+	s.waitRunning(t)
+	for i := 0; i < 2; i++ {
+		s.crash <- struct{}{}
+		s.waitRunning(t)
+	}
+	s.crash <- struct{}{}
+	assert.True(t, clock.WaitForWaiters(1, 5*time.Second), "the cooldown was not scheduled")
+	assert.Equal(t, 3, l.Generation(), "the service was restarted while the circuit was open")
+	info := pool.Services()[0]
+	assert.True(t, info.CircuitOpen)
+	assert.Equal(t, start.Add(30*time.Second), info.CircuitOpenUntil)
+	assert.Equal(t, service.StateCrashed, info.State)
+	assert.Contains(t, output.String(), service.EServiceCrashLoop)
+	assert.Equal(t, service.StateRunning, poolLifecycle.State(), "a crash loop stopped the pool")
+
+	clock.Advance(30 * time.Second)
+	s.waitRunning(t)
+	assert.Equal(t, 4, l.Generation())
+	info = pool.Services()[0]
+	assert.False(t, info.CircuitOpen)
+	assert.True(t, info.CircuitOpenUntil.IsZero())
+
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestPoolCrashLoopWindow(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	pool, s := newCrashLoopPool(t, log.NewTestLogger(t), clock, service.CrashLoopConfig{
+		MaxCrashes: 2,
+		Window:     time.Minute,
+		Cooldown:   time.Minute,
+	})
+
+	poolLifecycle, result := startPool(t, pool)
+	s.waitRunning(t)
+	for i := 0; i < 3; i++ {
+		s.crash <- struct{}{}
+		s.waitRunning(t)
+		clock.Advance(2 * time.Minute)
+	}
+	assert.False(t, pool.Services()[0].CircuitOpen, "crashes outside of the window opened the circuit")
+
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestPoolCrashLoopStopPool(t *testing.T) {
+	pool, s := newCrashLoopPool(t, log.NewTestLogger(t), service.NewRealClock(), service.CrashLoopConfig{
+		MaxCrashes: 2,
+		Window:     time.Minute,
+		Cooldown:   time.Minute,
+		StopPool:   true,
+	})
+
+	_, result := startPool(t, pool)
+	s.waitRunning(t)
+	s.crash <- struct{}{}
+	s.waitRunning(t)
+	s.crash <- struct{}{}
+
+	err := <-result
+	var crashLoopError *service.CrashLoopError
+	if assert.True(t, errors.As(err, &crashLoopError), "unexpected error: %v", err) {
+		assert.Equal(t, "looping", crashLoopError.ID)
+		assert.Equal(t, 2, crashLoopError.Crashes)
+		assert.EqualError(t, crashLoopError.Cause, "crash")
+	}
+	info := pool.Services()[0]
+	assert.True(t, info.CircuitOpen)
+	assert.True(t, info.CircuitOpenUntil.IsZero())
+}
+
+func TestPoolCrashLoopStopService(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	pool, s := newCrashLoopPool(t, log.NewTestLogger(t), clock, service.CrashLoopConfig{
+		MaxCrashes: 1,
+		Window:     time.Minute,
+		Cooldown:   time.Minute,
+	})
+	l, _ := pool.Lifecycle("looping")
+
+	poolLifecycle, result := startPool(t, pool)
+	s.waitRunning(t)
+	s.crash <- struct{}{}
+	assert.True(t, clock.WaitForWaiters(1, 5*time.Second), "the cooldown was not scheduled")
+
+	// Stopping the service cancels the pending restart, restarting it closes the circuit right away.
+	assert.NoError(t, pool.StopService(context.Background(), "looping"))
+	clock.Advance(time.Minute)
+	assert.NoError(t, pool.RestartService(context.Background(), "looping"))
+	s.waitRunning(t)
+	assert.Equal(t, 2, l.Generation())
+	assert.False(t, pool.Services()[0].CircuitOpen)
+
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestCrashLoopConfigValidation(t *testing.T) {
+	assert.NoError(t, service.CrashLoopConfig{}.Validate())
+	assert.Error(t, service.CrashLoopConfig{MaxCrashes: -1}.Validate())
+	assert.Error(t, service.CrashLoopConfig{MaxCrashes: 3, Cooldown: time.Second}.Validate())
+	assert.Error(t, service.CrashLoopConfig{MaxCrashes: 3, Window: time.Second}.Validate())
+	assert.NoError(t, service.CrashLoopConfig{MaxCrashes: 3, Window: time.Second, Cooldown: time.Second}.Validate())
+}
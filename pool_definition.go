@@ -0,0 +1,345 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/containerssh/log"
+	"gopkg.in/yaml.v3"
+)
+
+// PoolDefinition describes a pool and its services declaratively. It is usually parsed from a YAML or JSON document
+// with ParsePoolDefinition() and turned into a pool with Build().
+type PoolDefinition struct {
+	// ShutdownTimeout corresponds to PoolConfig.ShutdownTimeout.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// StartConcurrency corresponds to PoolConfig.StartConcurrency.
+	StartConcurrency int `yaml:"startConcurrency"`
+	// StartDelay corresponds to PoolConfig.StartDelay.
+	StartDelay time.Duration `yaml:"startDelay"`
+	// StartJitter corresponds to PoolConfig.StartJitter.
+	StartJitter time.Duration `yaml:"startJitter"`
+	// StopConcurrency corresponds to PoolConfig.StopConcurrency.
+	StopConcurrency int `yaml:"stopConcurrency"`
+	// PreStopDelay corresponds to PoolConfig.PreStopDelay.
+	PreStopDelay time.Duration `yaml:"preStopDelay"`
+	// StateHistorySize corresponds to PoolConfig.StateHistorySize.
+	StateHistorySize int `yaml:"stateHistorySize"`
+	// Services are the services of the pool. At least one service is required.
+	Services []ServiceDefinition `yaml:"services"`
+}
+
+// ServiceDefinition describes a single service of a pool definition.
+type ServiceDefinition struct {
+	// ID is the unique ID of the service within the pool. Required.
+	ID string `yaml:"id"`
+	// Type is the name of the factory in the ServiceRegistry that creates the service. Required.
+	Type string `yaml:"type"`
+	// Labels corresponds to ServiceOptions.Labels.
+	Labels map[string]string `yaml:"labels"`
+	// StartPriority corresponds to ServiceOptions.StartPriority.
+	StartPriority int `yaml:"startPriority"`
+	// DependsOn corresponds to ServiceOptions.DependsOn.
+	DependsOn []string `yaml:"dependsOn"`
+	// Optional corresponds to ServiceOptions.Optional.
+	Optional bool `yaml:"optional"`
+	// Restart corresponds to ServiceOptions.Restart.
+	Restart RestartPolicy `yaml:"restart"`
+	// RestartDelay corresponds to ServiceOptions.RestartDelay.
+	RestartDelay time.Duration `yaml:"restartDelay"`
+	// CrashLoop corresponds to ServiceOptions.CrashLoop.
+	CrashLoop CrashLoopConfig `yaml:"crashLoop"`
+	// StartupTimeout corresponds to LifecycleConfig.StartupTimeout.
+	StartupTimeout time.Duration `yaml:"startupTimeout"`
+	// ShutdownTimeout corresponds to LifecycleConfig.ShutdownTimeout.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// PreStopDelay corresponds to LifecycleConfig.PreStopDelay.
+	PreStopDelay time.Duration `yaml:"preStopDelay"`
+	// TransitionPolicy corresponds to LifecycleConfig.TransitionPolicy. Illegal transitions are logged to the logger
+	// passed to Build().
+	TransitionPolicy TransitionPolicy `yaml:"transitionPolicy"`
+	// Config holds the settings specific to the service type. Factories decode it with DecodeConfig().
+	Config yaml.Node `yaml:"config"`
+
+	// line is the line of the definition in the document, or 0 if it was not parsed from a document.
+	line int
+}
+
+// UnmarshalYAML decodes the definition of a pool and rejects unknown fields.
+func (d *PoolDefinition) UnmarshalYAML(value *yaml.Node) error {
+	type plain PoolDefinition
+	return decodeStrict(value, (*plain)(d))
+}
+
+// UnmarshalYAML decodes the definition of a service and rejects unknown fields.
+func (d *ServiceDefinition) UnmarshalYAML(value *yaml.Node) error {
+	type plain ServiceDefinition
+	if err := decodeStrict(value, (*plain)(d)); err != nil {
+		return err
+	}
+	d.line = value.Line
+	return nil
+}
+
+// DecodeConfig decodes the settings specific to the service type into the target, which must be a pointer. Fields
+// of the settings that do not exist in the target are reported as errors with their line numbers. If the definition
+// has no settings the target is left unchanged.
+func (d ServiceDefinition) DecodeConfig(target interface{}) error {
+	if d.Config.Kind == 0 {
+		return nil
+	}
+	return decodeStrict(&d.Config, target)
+}
+
+// Validate checks the definition for errors that can be detected without creating the services, such as missing
+// IDs, invalid settings and unsatisfiable dependencies. It returns a *PoolDefinitionError listing all problems.
+func (d *PoolDefinition) Validate() error {
+	var problems []PoolDefinitionProblem
+	config := d.poolConfig()
+	if err := config.Validate(); err != nil {
+		problems = append(problems, PoolDefinitionProblem{Message: err.Error()})
+	}
+	if len(d.Services) == 0 {
+		problems = append(problems, PoolDefinitionProblem{Path: "services", Message: "no services defined"})
+	}
+
+	ids := map[string]int{}
+	for i, service := range d.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		problem := func(field string, format string, args ...interface{}) {
+			problems = append(problems, PoolDefinitionProblem{
+				Path:    path + field,
+				Line:    service.line,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+		if service.ID == "" {
+			problem(".id", "the service ID is required")
+		} else if first, ok := ids[service.ID]; ok {
+			problem(".id", "duplicate service ID %s, first defined in services[%d]", service.ID, first)
+		} else {
+			ids[service.ID] = i
+		}
+		if service.Type == "" {
+			problem(".type", "the service type is required")
+		}
+		options := service.serviceOptions()
+		if err := options.Validate(); err != nil {
+			problem("", "%v", err)
+		}
+		lifecycleConfig := service.lifecycleConfig(nil)
+		if lifecycleConfig.TransitionPolicy == TransitionPolicyLog {
+			// The logger required by the log policy is only passed to Build().
+			lifecycleConfig.TransitionPolicy = TransitionPolicyError
+		}
+		if err := lifecycleConfig.Validate(); err != nil {
+			problem("", "%v", err)
+		}
+	}
+	for i, service := range d.Services {
+		for j, dependency := range service.DependsOn {
+			if _, ok := ids[dependency]; !ok && dependency != "" {
+				problems = append(problems, PoolDefinitionProblem{
+					Path:    fmt.Sprintf("services[%d].dependsOn[%d]", i, j),
+					Line:    service.line,
+					Message: fmt.Sprintf("unknown service %s", dependency),
+				})
+			}
+		}
+	}
+	if len(problems) == 0 {
+		problems = d.dependencyProblems()
+	}
+
+	if len(problems) > 0 {
+		return &PoolDefinitionError{Problems: problems}
+	}
+	return nil
+}
+
+// dependencyProblems reports dependency cycles between the services. The services must have unique IDs and only
+// depend on existing services.
+func (d *PoolDefinition) dependencyProblems() []PoolDefinitionProblem {
+	nodes := make([]dependencyNode, len(d.Services))
+	for i, service := range d.Services {
+		nodes[i] = dependencyNode{
+			id:        service.ID,
+			priority:  service.StartPriority,
+			dependsOn: service.DependsOn,
+		}
+	}
+	_, err := dependencyWaves(nodes)
+	var cycleError *DependencyCycleError
+	if !errors.As(err, &cycleError) {
+		return nil
+	}
+	line := 0
+	for _, service := range d.Services {
+		if service.ID == cycleError.Cycle[0] {
+			line = service.line
+		}
+	}
+	return []PoolDefinitionProblem{{Path: "services", Line: line, Message: cycleError.Error()}}
+}
+
+func (d *PoolDefinition) poolConfig() PoolConfig {
+	return PoolConfig{
+		ShutdownTimeout:  d.ShutdownTimeout,
+		StartConcurrency: d.StartConcurrency,
+		StartDelay:       d.StartDelay,
+		StartJitter:      d.StartJitter,
+		StopConcurrency:  d.StopConcurrency,
+		PreStopDelay:     d.PreStopDelay,
+		StateHistorySize: d.StateHistorySize,
+	}
+}
+
+func (d *ServiceDefinition) serviceOptions() ServiceOptions {
+	return ServiceOptions{
+		ID:            d.ID,
+		Labels:        d.Labels,
+		StartPriority: d.StartPriority,
+		DependsOn:     d.DependsOn,
+		Optional:      d.Optional,
+		Restart:       d.Restart,
+		RestartDelay:  d.RestartDelay,
+		CrashLoop:     d.CrashLoop,
+	}
+}
+
+// lifecycleConfig returns the lifecycle settings of the service, which report problems to the logger.
+func (d *ServiceDefinition) lifecycleConfig(logger log.Logger) LifecycleConfig {
+	return LifecycleConfig{
+		TransitionPolicy: d.TransitionPolicy,
+		Logger:           logger,
+		StartupTimeout:   d.StartupTimeout,
+		ShutdownTimeout:  d.ShutdownTimeout,
+		PreStopDelay:     d.PreStopDelay,
+	}
+}
+
+// PoolDefinitionError is returned when a pool definition is invalid. It lists all problems found.
+type PoolDefinitionError struct {
+	// Problems are the problems found in the definition, in the order they were found.
+	Problems []PoolDefinitionProblem
+}
+
+// Error returns the error message.
+func (e *PoolDefinitionError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.String()
+	}
+	return "invalid pool definition: " + strings.Join(messages, "; ")
+}
+
+// PoolDefinitionProblem is a single problem in a pool definition.
+type PoolDefinitionProblem struct {
+	// Path is the location of the problem in the definition, such as "services[2].dependsOn[0]". It is empty if the
+	// location is only known by its line.
+	Path string
+	// Line is the line of the problem in the document, or 0 if it is not known.
+	Line int
+	// Message describes the problem.
+	Message string
+}
+
+// String returns the problem with its location.
+func (p PoolDefinitionProblem) String() string {
+	location := p.Path
+	if p.Line > 0 {
+		location = strings.TrimSuffix(fmt.Sprintf("line %d: %s", p.Line, p.Path), ": ")
+	}
+	if location == "" {
+		return p.Message
+	}
+	return location + ": " + p.Message
+}
+
+// yamlProblems converts an error returned by the YAML decoder into problems. Decoder messages starting with a line
+// number get the line as their location, other errors are reported with the fallback location.
+func yamlProblems(err error, path string, line int) []PoolDefinitionProblem {
+	messages := []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		messages = typeError.Errors
+	}
+	problems := make([]PoolDefinitionProblem, len(messages))
+	for i, message := range messages {
+		problem := PoolDefinitionProblem{Path: path, Line: line, Message: message}
+		var messageLine int
+		var rest string
+		if n, _ := fmt.Sscanf(message, "line %d:", &messageLine); n == 1 {
+			rest = strings.TrimSpace(message[strings.Index(message, ":")+1:])
+			problem = PoolDefinitionProblem{Line: messageLine, Message: rest}
+		}
+		problems[i] = problem
+	}
+	return problems
+}
+
+// decodeStrict decodes the node into the target like yaml.Node.Decode(), but also reports the fields of a mapping
+// that do not exist in the target struct. All errors are returned together as a *yaml.TypeError.
+func decodeStrict(node *yaml.Node, target interface{}) error {
+	var errs []string
+	if node.Kind == yaml.MappingNode {
+		if known, ok := yamlFields(reflect.TypeOf(target)); ok {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				if _, exists := known[key.Value]; !exists && key.Value != "<<" {
+					errs = append(errs, fmt.Sprintf("line %d: unknown field %s", key.Line, key.Value))
+				}
+			}
+		}
+	}
+	if err := node.Decode(target); err != nil {
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			return err
+		}
+		errs = append(errs, typeError.Errors...)
+	}
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+	return nil
+}
+
+// yamlFields returns the field names the YAML decoder accepts for the type, which must be a struct or a pointer to
+// a struct. It returns false for other types.
+func yamlFields(t reflect.Type) (map[string]struct{}, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	fields := map[string]struct{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" {
+			if inline, ok := yamlFields(field.Type); ok {
+				for name := range inline {
+					fields[name] = struct{}{}
+				}
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = struct{}{}
+	}
+	return fields, true
+}
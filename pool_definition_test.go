@@ -0,0 +1,269 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+const testPoolDefinition = `
+shutdownTimeout: 30s
+startConcurrency: 2
+services:
+  - id: db
+    type: test
+    labels:
+      tier: backend
+    shutdownTimeout: 10s
+    config:
+      greeting: hello
+  - id: app
+    type: test
+    dependsOn: [db]
+    restart: on-failure
+    restartDelay: 1s
+  - id: metrics
+    type: test
+    optional: true
+`
+
+// testServiceConfig is the configuration of the "test" service type in the pool definition tests.
+type testServiceConfig struct {
+	Greeting string `yaml:"greeting"`
+}
+
+func newTestRegistry(configs map[string]testServiceConfig) service.ServiceRegistry {
+	registry := service.NewServiceRegistry()
+	registry.Register("test", func(definition service.ServiceDefinition, logger log.Logger) (service.Service, error) {
+		config := testServiceConfig{}
+		if err := definition.DecodeConfig(&config); err != nil {
+			return nil, err
+		}
+		if configs != nil {
+			configs[definition.ID] = config
+		}
+		return newTestService(definition.ID), nil
+	})
+	return registry
+}
+
+func TestLoadPool(t *testing.T) {
+	configs := map[string]testServiceConfig{}
+	pool, err := service.LoadPool([]byte(testPoolDefinition), newTestRegistry(configs), log.NewTestLogger(t))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "hello", configs["db"].Greeting)
+
+	services := pool.Services()
+	var ids []string
+	for _, info := range services {
+		ids = append(ids, info.ID)
+	}
+	assert.Equal(t, []string{"db", "app", "metrics"}, ids)
+	assert.Equal(t, map[string]string{"tier": "backend"}, services[0].Labels)
+
+	poolLifecycle, result := startPool(t, pool)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestParsePoolDefinitionJSON(t *testing.T) {
+	definition, err := service.ParsePoolDefinition([]byte(`{
+		"startDelay": "100ms",
+		"services": [
+			{"id": "db", "type": "test", "config": {"greeting": "hi"}},
+			{"id": "app", "type": "test", "dependsOn": ["db"], "restart": "always", "startupTimeout": "5s",
+				"crashLoop": {"maxCrashes": 3, "window": "1m", "cooldown": "30s", "stopPool": true}}
+		]
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 100*time.Millisecond, definition.StartDelay)
+	if assert.Len(t, definition.Services, 2) {
+		assert.Equal(t, []string{"db"}, definition.Services[1].DependsOn)
+		assert.Equal(t, service.RestartAlways, definition.Services[1].Restart)
+		assert.Equal(t, 5*time.Second, definition.Services[1].StartupTimeout)
+		assert.Equal(t, service.CrashLoopConfig{
+			MaxCrashes: 3,
+			Window:     time.Minute,
+			Cooldown:   30 * time.Second,
+			StopPool:   true,
+		}, definition.Services[1].CrashLoop)
+		config := testServiceConfig{}
+		assert.NoError(t, definition.Services[0].DecodeConfig(&config))
+		assert.Equal(t, "hi", config.Greeting)
+	}
+}
+
+func TestPoolDefinitionValidation(t *testing.T) {
+	_, err := service.ParsePoolDefinition([]byte(`
+startDelay: -1s
+services:
+  - id: db
+    type: test
+    restart: sometimes
+  - id: db
+  - type: test
+    dependsOn: [cache]
+    colour: blue
+`))
+	var definitionError *service.PoolDefinitionError
+	if !assert.True(t, errors.As(err, &definitionError), "unexpected error: %v", err) {
+		return
+	}
+	assert.Equal(t, []service.PoolDefinitionProblem{
+		{Line: 10, Message: "unknown field colour"},
+	}, definitionError.Problems, "decoding errors must be reported without validating the definition")
+
+	_, err = service.ParsePoolDefinition([]byte(`
+startDelay: -1s
+services:
+  - id: db
+    type: test
+    restart: sometimes
+  - id: db
+  - type: test
+    dependsOn: [cache]
+`))
+	if !assert.True(t, errors.As(err, &definitionError), "unexpected error: %v", err) {
+		return
+	}
+	assert.Equal(t, []service.PoolDefinitionProblem{
+		{Message: "the start delay must not be negative"},
+		{Path: "services[0]", Line: 4, Message: "invalid restart policy: sometimes"},
+		{Path: "services[1].id", Line: 7, Message: "duplicate service ID db, first defined in services[0]"},
+		{Path: "services[1].type", Line: 7, Message: "the service type is required"},
+		{Path: "services[2].id", Line: 8, Message: "the service ID is required"},
+		{Path: "services[2].dependsOn[0]", Line: 8, Message: "unknown service cache"},
+	}, definitionError.Problems)
+	assert.Contains(t, err.Error(), "line 7: services[1].id: duplicate service ID db")
+}
+
+func TestPoolDefinitionCycle(t *testing.T) {
+	_, err := service.ParsePoolDefinition([]byte(`
+services:
+  - id: a
+    type: test
+    dependsOn: [b]
+  - id: b
+    type: test
+    dependsOn: [a]
+`))
+	assert.EqualError(
+		t,
+		err,
+		"invalid pool definition: line 3: services: dependency cycle between services: a -> b -> a",
+	)
+}
+
+func TestPoolDefinitionSyntaxError(t *testing.T) {
+	_, err := service.ParsePoolDefinition([]byte("services:\n  - id: [a\n"))
+	var definitionError *service.PoolDefinitionError
+	assert.True(t, errors.As(err, &definitionError), "unexpected error: %v", err)
+
+	_, err = service.ParsePoolDefinition([]byte("  \n"))
+	assert.EqualError(t, err, "invalid pool definition: the pool definition is empty")
+}
+
+func TestPoolDefinitionBuildErrors(t *testing.T) {
+	_, err := service.LoadPool([]byte(`
+services:
+  - id: db
+    type: database
+  - id: app
+    type: test
+    config:
+      greeting: hello
+      farewell: bye
+  - id: worker
+    type: exec
+`), newTestRegistry(nil), log.NewTestLogger(t))
+	var definitionError *service.PoolDefinitionError
+	if !assert.True(t, errors.As(err, &definitionError), "unexpected error: %v", err) {
+		return
+	}
+	assert.Equal(t, []service.PoolDefinitionProblem{
+		{Path: "services[0].type", Line: 3, Message: "unknown service type database, expected one of: exec, test"},
+		{Line: 9, Message: "unknown field farewell"},
+		{Path: "services[2].config", Line: 10, Message: "no command provided"},
+	}, definitionError.Problems)
+}
+
+func TestLoadPoolLogger(t *testing.T) {
+	logger, output := newRecordingLogger(t)
+	registry := newTestRegistry(nil)
+	registry.Register("double-stopping", func(service.ServiceDefinition, log.Logger) (service.Service, error) {
+		return doubleStoppingService(), nil
+	})
+	pool, err := service.LoadPool([]byte(`
+services:
+  - id: sloppy
+    type: double-stopping
+    transitionPolicy: log
+  - id: plain
+    type: test
+`), registry, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	plain, ok := pool.Lifecycle("plain")
+	if !assert.True(t, ok) {
+		return
+	}
+	plain.AddHook(service.Hook{
+		Name: "panicking",
+		OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+			panic("out of coffee")
+		},
+	})
+
+	// The sloppy service exits on its own, which stops the pool.
+	assert.NoError(t, service.NewLifecycle(pool).Run())
+	assert.Contains(t, output.String(), `"code":"SERVICE_ILLEGAL_TRANSITION"`)
+	assert.Equal(t, 1, strings.Count(output.String(), `"code":"SERVICE_HOOK_MISBEHAVED"`))
+}
+
+func TestLoadPoolFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool-definition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	file := filepath.Join(dir, "pool.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+services:
+  - id: sleeper
+    type: exec
+    config:
+      command: [sleep, "60"]
+`), 0600))
+
+	pool, err := service.LoadPoolFile(file, service.NewServiceRegistry(), log.NewTestLogger(t))
+	if assert.NoError(t, err) {
+		services := pool.Services()
+		if assert.Len(t, services, 1) {
+			assert.Equal(t, "sleeper", services[0].Service.String())
+		}
+	}
+
+	_, err = service.LoadPoolFile(
+		filepath.Join(dir, "missing.yaml"),
+		service.NewServiceRegistry(),
+		log.NewTestLogger(t),
+	)
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package service
+
+// dependencyNode is a service in a dependency graph.
+type dependencyNode struct {
+	id        string
+	priority  int
+	dependsOn []string
+}
+
+// Marks used while walking a dependency graph.
+const (
+	dependencyUnvisited = iota
+	dependencyVisiting
+	dependencyVisited
+)
+
+// dependencyWaves determines the start wave of every node from its start priority and its dependencies. A node is
+// placed in a later wave than all of its dependencies, so it is started after and stopped before them. It returns an
+// *UnknownDependencyError or a *DependencyCycleError if the dependencies cannot be satisfied.
+func dependencyWaves(nodes []dependencyNode) ([]int, error) {
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node.id] = i
+	}
+	waves := make([]int, len(nodes))
+	marks := make([]int, len(nodes))
+	var path []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case dependencyVisited:
+			return nil
+		case dependencyVisiting:
+			return dependencyCycle(nodes, path, i)
+		}
+		marks[i] = dependencyVisiting
+		path = append(path, i)
+		wave := nodes[i].priority
+		for _, id := range nodes[i].dependsOn {
+			dependency, ok := index[id]
+			if !ok {
+				return &UnknownDependencyError{ID: nodes[i].id, Dependency: id}
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+			if waves[dependency] >= wave {
+				wave = waves[dependency] + 1
+			}
+		}
+		waves[i] = wave
+		path = path[:len(path)-1]
+		marks[i] = dependencyVisited
+		return nil
+	}
+	for i := range nodes {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return waves, nil
+}
+
+// dependencyCycle returns the error for a cycle that closes at the specified node, which is part of the path.
+func dependencyCycle(nodes []dependencyNode, path []int, closing int) error {
+	start := 0
+	for i, node := range path {
+		if node == closing {
+			start = i
+			break
+		}
+	}
+	cycle := make([]string, 0, len(path)-start+1)
+	for _, node := range path[start:] {
+		cycle = append(cycle, nodes[node].id)
+	}
+	return &DependencyCycleError{Cycle: append(cycle, nodes[closing].id)}
+}
+
+// computeWaves determines the start wave of every service of the pool. It must be called with the mutex held.
+func (p *pool) computeWaves() error {
+	nodes := make([]dependencyNode, len(p.entries))
+	for i, entry := range p.entries {
+		nodes[i] = dependencyNode{
+			id:        entry.options.ID,
+			priority:  entry.options.StartPriority,
+			dependsOn: entry.options.DependsOn,
+		}
+	}
+	waves, err := dependencyWaves(nodes)
+	if err != nil {
+		return err
+	}
+	for i, entry := range p.entries {
+		entry.wave = waves[i]
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrPoolNotRunning is returned when a service operation is requested while the pool is not fully running, for
+// example during startup or shutdown.
+var ErrPoolNotRunning = errors.New("the pool is not running")
+
+// ErrServiceExited is returned when a restarted service exits before it is running.
+var ErrServiceExited = errors.New("the service exited before it was running")
+
+// DuplicateServiceIDError is returned when a service is added to a pool with an ID that is already taken.
+type DuplicateServiceIDError struct {
+	// ID is the duplicate service ID.
+	ID string
+}
+
+// Error returns the error message.
+func (e *DuplicateServiceIDError) Error() string {
+	return fmt.Sprintf("a service with the ID %s is already registered", e.ID)
+}
+
+// ServiceNotFoundError is returned when a service ID is not registered in a pool.
+type ServiceNotFoundError struct {
+	// ID is the service ID that was not found.
+	ID string
+}
+
+// Error returns the error message.
+func (e *ServiceNotFoundError) Error() string {
+	return fmt.Sprintf("no service with the ID %s is registered", e.ID)
+}
+
+// UnknownDependencyError is returned when a pool is run with a service that depends on an ID that is not registered.
+type UnknownDependencyError struct {
+	// ID is the ID of the service with the dependency.
+	ID string
+	// Dependency is the unknown service ID.
+	Dependency string
+}
+
+// Error returns the error message.
+func (e *UnknownDependencyError) Error() string {
+	return fmt.Sprintf("the service %s depends on %s, which is not registered", e.ID, e.Dependency)
+}
+
+// DependencyCycleError is returned when a pool is run with services that depend on each other in a cycle.
+type DependencyCycleError struct {
+	// Cycle lists the IDs of the services in the cycle. The first ID is repeated at the end.
+	Cycle []string
+}
+
+// Error returns the error message.
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("dependency cycle between services: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// CrashLoopError is returned by a pool that has shut down because a critical service crashed in a loop.
+type CrashLoopError struct {
+	// ID is the ID of the crashing service.
+	ID string
+	// Crashes is the number of crashes within the window.
+	Crashes int
+	// Window is the period in which the crashes happened.
+	Window time.Duration
+	// Cause is the error of the last crash.
+	Cause error
+}
+
+// Error returns the error message.
+func (e *CrashLoopError) Error() string {
+	return fmt.Sprintf("the service %s crashed %d times within %s (%v)", e.ID, e.Crashes, e.Window, e.Cause)
+}
+
+// Unwrap returns the error of the last crash.
+func (e *CrashLoopError) Unwrap() error {
+	return e.Cause
+}
@@ -1,21 +1,39 @@
 package service
 
 import (
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/containerssh/log"
 )
 
 // NewPool creates a new service pool that can be used to run and manage multiple services in parallel.
 func NewPool(lifecycleFactory LifecycleFactory, logger log.Logger) Pool {
+	return newPool(PoolConfig{}, lifecycleFactory, logger)
+}
+
+// NewPoolWithConfig creates a new service pool with a custom configuration. It returns an error if the configuration
+// is invalid.
+func NewPoolWithConfig(config PoolConfig, lifecycleFactory LifecycleFactory, logger log.Logger) (Pool, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newPool(config, lifecycleFactory, logger), nil
+}
+
+func newPool(config PoolConfig, lifecycleFactory LifecycleFactory, logger log.Logger) *pool {
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
 	return &pool{
+		config:           config,
 		mutex:            &sync.Mutex{},
-		services:         []Service{},
 		lifecycleFactory: lifecycleFactory,
-		lifecycles:       map[Service]Lifecycle{},
-		serviceStates:    map[Service]State{},
-		startupComplete:  make(chan struct{}, 1),
-		stopComplete:     make(chan struct{}, 1),
+		ids:              map[string]*poolEntry{},
 		logger:           logger,
+		changed:          make(chan struct{}),
+		random:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		events:           newEventBus(config.EventBufferSize, config.EventPolicy),
 	}
 }
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GraphFormat is the output format of a diagram.
+type GraphFormat string
+
+const (
+	// GraphFormatDOT renders diagrams in the Graphviz DOT language.
+	GraphFormatDOT GraphFormat = "dot"
+	// GraphFormatMermaid renders diagrams as Mermaid flowcharts and state diagrams.
+	GraphFormatMermaid GraphFormat = "mermaid"
+)
+
+// Validate checks if the graph format is one of the supported values.
+func (f GraphFormat) Validate() error {
+	switch f {
+	case GraphFormatDOT, GraphFormatMermaid:
+		return nil
+	default:
+		return fmt.Errorf("invalid graph format: %s", f)
+	}
+}
+
+// PoolGraph renders the structure of the pool as a diagram. Each service is shown with its ID, name, current state,
+// criticality and restart policy, and dependencies are drawn as edges from the dependent service to its dependency.
+// The services of nested pools are grouped in a cluster of the nested pool and their IDs are prefixed with the ID of
+// the nested pool, such as "nested/child". Critical services are drawn with a bold border in DOT and as rectangles in
+// Mermaid, optional services with a dashed border in DOT and as rounded boxes in Mermaid.
+func PoolGraph(pool Pool, format GraphFormat) (string, error) {
+	if err := format.Validate(); err != nil {
+		return "", err
+	}
+	nodes := graphNodes(pool, "")
+	if format == GraphFormatDOT {
+		return poolDOT(nodes), nil
+	}
+	return poolMermaid(nodes), nil
+}
+
+// StateGraph renders the state machine of lifecycles as a diagram, showing all legal state transitions.
+func StateGraph(format GraphFormat) (string, error) {
+	if err := format.Validate(); err != nil {
+		return "", err
+	}
+	b := &strings.Builder{}
+	if format == GraphFormatDOT {
+		b.WriteString("digraph states {\n    rankdir=LR;\n    node [shape=ellipse];\n    start [shape=point];\n")
+		_, _ = fmt.Fprintf(b, "    start -> %s;\n", dotString(string(StateStopped)))
+		for _, from := range graphStates {
+			for _, to := range stateTransitions[from] {
+				_, _ = fmt.Fprintf(b, "    %s -> %s;\n", dotString(string(from)), dotString(string(to)))
+			}
+		}
+		b.WriteString("}\n")
+		return b.String(), nil
+	}
+	b.WriteString("stateDiagram-v2\n")
+	_, _ = fmt.Fprintf(b, "    [*] --> %s\n", StateStopped)
+	for _, from := range graphStates {
+		for _, to := range stateTransitions[from] {
+			_, _ = fmt.Fprintf(b, "    %s --> %s\n", from, to)
+		}
+	}
+	return b.String(), nil
+}
+
+// graphStates are the states in the order they are rendered.
+var graphStates = []State{StateStopped, StateStarting, StateRunning, StateStopping, StateCrashed}
+
+// graphStateColors are the fill and stroke colors of the services in each state.
+var graphStateColors = map[State][2]string{
+	StateStopped:  {"#eeeeee", "#9e9e9e"},
+	StateStarting: {"#fff9c4", "#f9a825"},
+	StateRunning:  {"#c8e6c9", "#2e7d32"},
+	StateStopping: {"#ffe0b2", "#ef6c00"},
+	StateCrashed:  {"#ffcdd2", "#c62828"},
+}
+
+// graphNode is a service in a pool diagram.
+type graphNode struct {
+	// id is the ID of the service, prefixed with the IDs of the nested pools it is in.
+	id        string
+	label     []string
+	state     State
+	optional  bool
+	dependsOn []string
+	children  []graphNode
+}
+
+// graphNodes describes the services of the pool, including the services of nested pools. The prefix is added to all
+// IDs.
+func graphNodes(pool Pool, prefix string) []graphNode {
+	services := pool.Services()
+	nodes := make([]graphNode, len(services))
+	for i, info := range services {
+		label := []string{info.ID}
+		if name := info.Service.String(); name != info.ID {
+			label = append(label, name)
+		}
+		label = append(label, string(info.State))
+		if info.Optional {
+			label = append(label, "optional")
+		} else {
+			label = append(label, "critical")
+		}
+		if info.Restart != "" && info.Restart != RestartNever {
+			label = append(label, "restart: "+string(info.Restart))
+		}
+		if info.CircuitOpen {
+			label = append(label, "circuit open")
+		}
+		node := graphNode{
+			id:       prefix + info.ID,
+			label:    label,
+			state:    info.State,
+			optional: info.Optional,
+		}
+		for _, dependency := range info.DependsOn {
+			node.dependsOn = append(node.dependsOn, prefix+dependency)
+		}
+		if nested, ok := info.Service.(Pool); ok {
+			node.children = graphNodes(nested, node.id+"/")
+		}
+		nodes[i] = node
+	}
+	return nodes
+}
+
+func poolDOT(nodes []graphNode) string {
+	b := &strings.Builder{}
+	b.WriteString("digraph pool {\n    rankdir=LR;\n    node [shape=box, style=filled];\n")
+	writeDOTNodes(b, nodes, "    ")
+	walkGraph(nodes, func(node graphNode) {
+		for _, dependency := range node.dependsOn {
+			_, _ = fmt.Fprintf(b, "    %s -> %s;\n", dotString(node.id), dotString(dependency))
+		}
+	})
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func writeDOTNodes(b *strings.Builder, nodes []graphNode, indent string) {
+	for _, node := range nodes {
+		nodeIndent := indent
+		if node.children != nil {
+			_, _ = fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotString("cluster_"+node.id))
+			_, _ = fmt.Fprintf(b, "%s    label=%s;\n", indent, dotString(node.id))
+			nodeIndent += "    "
+		}
+		colors := graphStateColors[node.state]
+		style := "penwidth=2"
+		if node.optional {
+			style = "style=\"filled,dashed\""
+		}
+		_, _ = fmt.Fprintf(
+			b,
+			"%s%s [label=%s, fillcolor=%s, color=%s, %s];\n",
+			nodeIndent,
+			dotString(node.id),
+			dotString(strings.Join(node.label, "\n")),
+			dotString(colors[0]),
+			dotString(colors[1]),
+			style,
+		)
+		if node.children != nil {
+			writeDOTNodes(b, node.children, nodeIndent)
+			_, _ = fmt.Fprintf(b, "%s}\n", indent)
+		}
+	}
+}
+
+// dotString quotes a string for DOT. Newlines are turned into line breaks of labels.
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func poolMermaid(nodes []graphNode) string {
+	// Mermaid IDs are restricted, so services get generated IDs in the order they are rendered.
+	ids := map[string]string{}
+	walkGraph(nodes, func(node graphNode) {
+		ids[node.id] = fmt.Sprintf("s%d", len(ids))
+	})
+
+	b := &strings.Builder{}
+	b.WriteString("flowchart LR\n")
+	writeMermaidNodes(b, nodes, ids, "    ")
+	byState := map[State][]string{}
+	walkGraph(nodes, func(node graphNode) {
+		for _, dependency := range node.dependsOn {
+			if dependencyID, ok := ids[dependency]; ok {
+				_, _ = fmt.Fprintf(b, "    %s --> %s\n", ids[node.id], dependencyID)
+			}
+		}
+		byState[node.state] = append(byState[node.state], ids[node.id])
+	})
+	for _, state := range graphStates {
+		colors := graphStateColors[state]
+		_, _ = fmt.Fprintf(b, "    classDef %s fill:%s,stroke:%s\n", state, colors[0], colors[1])
+		if len(byState[state]) > 0 {
+			_, _ = fmt.Fprintf(b, "    class %s %s\n", strings.Join(byState[state], ","), state)
+		}
+	}
+	return b.String()
+}
+
+func writeMermaidNodes(b *strings.Builder, nodes []graphNode, ids map[string]string, indent string) {
+	for _, node := range nodes {
+		nodeIndent := indent
+		if node.children != nil {
+			_, _ = fmt.Fprintf(b, "%ssubgraph %s_pool [%s]\n", indent, ids[node.id], mermaidString(node.id))
+			nodeIndent += "    "
+		}
+		label := mermaidString(strings.Join(node.label, "<br/>"))
+		if node.optional {
+			_, _ = fmt.Fprintf(b, "%s%s([%s])\n", nodeIndent, ids[node.id], label)
+		} else {
+			_, _ = fmt.Fprintf(b, "%s%s[%s]\n", nodeIndent, ids[node.id], label)
+		}
+		if node.children != nil {
+			writeMermaidNodes(b, node.children, ids, nodeIndent)
+			_, _ = fmt.Fprintf(b, "%send\n", indent)
+		}
+	}
+}
+
+// mermaidString quotes a string for Mermaid.
+func mermaidString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
+
+// walkGraph calls the function for every node, parents before their children.
+func walkGraph(nodes []graphNode, f func(node graphNode)) {
+	for _, node := range nodes {
+		f(node)
+		walkGraph(node.children, f)
+	}
+}
@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+func newGraphTestPool(t *testing.T) service.Pool {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	_, err := pool.AddWithOptions(newTestService("Database"), service.ServiceOptions{ID: "db"})
+	assert.NoError(t, err)
+	nested := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	_, err = nested.AddWithOptions(newTestService("worker"), service.ServiceOptions{ID: "worker"})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(nested, service.ServiceOptions{
+		ID:        "jobs",
+		DependsOn: []string{"db"},
+		Restart:   service.RestartOnFailure,
+	})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Metrics \"exporter\""), service.ServiceOptions{
+		ID:       "metrics",
+		Optional: true,
+	})
+	assert.NoError(t, err)
+	return pool
+}
+
+func TestPoolGraphDOT(t *testing.T) {
+	graph, err := service.PoolGraph(newGraphTestPool(t), service.GraphFormatDOT)
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph pool {
+    rankdir=LR;
+    node [shape=box, style=filled];
+    "db" [label="db\nDatabase\nstopped\ncritical", fillcolor="#eeeeee", color="#9e9e9e", penwidth=2];
+    subgraph "cluster_jobs" {
+        label="jobs";
+        "jobs" [label="jobs\nService Pool\nstopped\ncritical\nrestart: on-failure", `+
+		`fillcolor="#eeeeee", color="#9e9e9e", penwidth=2];
+        "jobs/worker" [label="worker\nstopped\ncritical", fillcolor="#eeeeee", color="#9e9e9e", penwidth=2];
+    }
+    "metrics" [label="metrics\nMetrics \"exporter\"\nstopped\noptional", `+
+		`fillcolor="#eeeeee", color="#9e9e9e", style="filled,dashed"];
+    "jobs" -> "db";
+}
+`, graph)
+}
+
+func TestPoolGraphMermaid(t *testing.T) {
+	graph, err := service.PoolGraph(newGraphTestPool(t), service.GraphFormatMermaid)
+	assert.NoError(t, err)
+	assert.Equal(t, `flowchart LR
+    s0["db<br/>Database<br/>stopped<br/>critical"]
+    subgraph s1_pool ["jobs"]
+        s1["jobs<br/>Service Pool<br/>stopped<br/>critical<br/>restart: on-failure"]
+        s2["worker<br/>stopped<br/>critical"]
+    end
+    s3(["metrics<br/>Metrics #quot;exporter#quot;<br/>stopped<br/>optional"])
+    s1 --> s0
+    classDef stopped fill:#eeeeee,stroke:#9e9e9e
+    class s0,s1,s2,s3 stopped
+    classDef starting fill:#fff9c4,stroke:#f9a825
+    classDef running fill:#c8e6c9,stroke:#2e7d32
+    classDef stopping fill:#ffe0b2,stroke:#ef6c00
+    classDef crashed fill:#ffcdd2,stroke:#c62828
+`, graph)
+}
+
+func TestPoolGraphRunningState(t *testing.T) {
+	pool := newGraphTestPool(t)
+	poolLifecycle, result := startPool(t, pool)
+	defer func() {
+		poolLifecycle.Stop(context.Background())
+		<-result
+	}()
+	graph, err := service.PoolGraph(pool, service.GraphFormatDOT)
+	assert.NoError(t, err)
+	assert.Contains(t, graph, `"jobs/worker" [label="worker\nrunning\ncritical", fillcolor="#c8e6c9"`)
+}
+
+func TestStateGraph(t *testing.T) {
+	graph, err := service.StateGraph(service.GraphFormatDOT)
+	assert.NoError(t, err)
+	assert.Contains(t, graph, `start -> "stopped";`)
+	assert.Contains(t, graph, `"running" -> "stopping";`)
+	assert.NotContains(t, graph, `"stopping" -> "running";`)
+
+	graph, err = service.StateGraph(service.GraphFormatMermaid)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(graph, "stateDiagram-v2\n    [*] --> stopped\n"))
+
+	// The README shows the state machine, it must not get out of date.
+	readme, err := ioutil.ReadFile("README.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(readme), "```mermaid\n"+graph+"```")
+
+	_, err = service.StateGraph("svg")
+	assert.Error(t, err)
+}
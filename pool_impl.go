@@ -2,23 +2,126 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/containerssh/log"
 )
 
 type pool struct {
+	config           PoolConfig
 	mutex            *sync.Mutex
-	services         []Service
-	lifecycles       map[Service]Lifecycle
-	serviceStates    map[Service]State
+	entries          []*poolEntry
+	ids              map[string]*poolEntry
 	lifecycleFactory LifecycleFactory
 	running          bool
-	startupComplete  chan struct{}
-	stopComplete     chan struct{}
 	lastError        error
-	stopping         bool
 	logger           log.Logger
+	events           *eventBus
+
+	// changed is closed and replaced whenever a service changes its state or exits, waking up everyone waiting for
+	// the services.
+	changed chan struct{}
+	random  *rand.Rand
+
+	// The following fields describe the current run and are reset when the pool is started.
+	order           []*poolEntry
+	launched        int
+	ready           int
+	skipped         int
+	exited          int
+	unexpected      int
+	launchDone      bool
+	started         bool
+	stopping        bool
+	stopRequested   chan struct{}
+	shutdownContext context.Context
+	cancelShutdown  func()
+	startSlots      chan struct{}
+	stopSlots       chan struct{}
+}
+
+// poolEntry holds the bookkeeping of the pool for a single service. All fields except service and lifecycle are
+// protected by the pool mutex.
+type poolEntry struct {
+	service   Service
+	lifecycle Lifecycle
+	options   ServiceOptions
+	state     State
+	launched  bool
+	exited    bool
+	// wave is the start wave of the service, computed from its start priority and dependencies.
+	wave int
+	// wasReady is set once the service has been running during the current run of the pool.
+	wasReady bool
+	// done is closed when the current run of the service has exited and the pool has processed the exit.
+	done chan struct{}
+	// manualStop is set when the service was stopped through StopService or RestartService. Such an exit does not
+	// shut down the pool.
+	manualStop      bool
+	shutdownContext context.Context
+	history         []StateChange
+	// holdsStartSlot and holdsStopSlot indicate that the service occupies a slot of the start or stop concurrency
+	// limit.
+	holdsStartSlot bool
+	holdsStopSlot  bool
+	// crashes are the times of the recent crashes counted by the crash loop detection.
+	crashes []time.Time
+	// circuitOpen is set when the service crashes in a loop and is not restarted until circuitOpenUntil.
+	circuitOpen      bool
+	circuitOpenUntil time.Time
+}
+
+// entryStatus is the part of the public description of an entry that is protected by the pool mutex.
+type entryStatus struct {
+	since            time.Time
+	circuitOpen      bool
+	circuitOpenUntil time.Time
+}
+
+// info returns the public description of the entry. It must be called without the pool mutex held.
+func (e *poolEntry) info(status entryStatus) ServiceInfo {
+	labels := make(map[string]string, len(e.options.Labels))
+	for key, value := range e.options.Labels {
+		labels[key] = value
+	}
+	return ServiceInfo{
+		ID:        e.options.ID,
+		Labels:    labels,
+		Service:   e.service,
+		Lifecycle: e.lifecycle,
+		State:     e.lifecycle.State(),
+		Since:     status.since,
+		DependsOn: append([]string{}, e.options.DependsOn...),
+		Optional:  e.options.Optional,
+		Restart:   e.options.Restart,
+
+		CircuitOpen:      status.circuitOpen,
+		CircuitOpenUntil: status.circuitOpenUntil,
+	}
+}
+
+// status returns the time the service entered its last recorded state and the state of its circuit. It must be
+// called with the pool mutex held.
+func (e *poolEntry) status() entryStatus {
+	status := entryStatus{
+		circuitOpen:      e.circuitOpen,
+		circuitOpenUntil: e.circuitOpenUntil,
+	}
+	if len(e.history) > 0 {
+		status.since = e.history[len(e.history)-1].Time
+	}
+	return status
+}
+
+// stopRequester is implemented by the lifecycles of this package. It allows pools to request a stop without waiting
+// for the service to exit, so they don't need a goroutine per service when shutting down.
+type stopRequester interface {
+	requestStop(shutdownContext context.Context) bool
 }
 
 func (p *pool) String() string {
@@ -26,153 +129,716 @@ func (p *pool) String() string {
 }
 
 func (p *pool) Add(s Service) Lifecycle {
+	l, err := p.AddWithOptions(s, ServiceOptions{})
+	if err != nil {
+		panic("bug: " + err.Error())
+	}
+	return l
+}
+
+func (p *pool) AddWithOptions(s Service, options ServiceOptions) (Lifecycle, error) {
 	p.mutex.Lock()
 	if p.running {
 		panic("bug: pool already running, cannot add service")
 	}
 	defer p.mutex.Unlock()
-	l := p.lifecycleFactory.Make(s)
-	l.OnStateChange(p.onServiceStateChange)
-	p.serviceStates[s] = StateStopped
-	p.services = append(p.services, s)
-	p.lifecycles[s] = l
-	return l
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.ID == "" {
+		options.ID = p.generateID(s)
+	}
+	if _, ok := p.ids[options.ID]; ok {
+		return nil, &DuplicateServiceIDError{ID: options.ID}
+	}
+	labels := make(map[string]string, len(options.Labels))
+	for key, value := range options.Labels {
+		labels[key] = value
+	}
+	options.Labels = labels
+	options.DependsOn = append([]string{}, options.DependsOn...)
+
+	lifecycleFactory := p.lifecycleFactory
+	if options.LifecycleFactory != nil {
+		lifecycleFactory = options.LifecycleFactory
+	}
+	l := lifecycleFactory.Make(s)
+	entry := &poolEntry{
+		service:   s,
+		lifecycle: l,
+		options:   options,
+		state:     StateStopped,
+	}
+	l.OnStateChange(func(s Service, l Lifecycle, state State) {
+		p.onServiceStateChange(entry, state)
+	})
+	p.entries = append(p.entries, entry)
+	p.ids[options.ID] = entry
+	p.forwardEvents(s, l)
+	return l, nil
+}
+
+// generateID returns an unused ID based on the name of the service. It must be called with the mutex held.
+func (p *pool) generateID(s Service) string {
+	id := s.String()
+	for i := 2; ; i++ {
+		if _, ok := p.ids[id]; !ok {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", s.String(), i)
+	}
+}
+
+func (p *pool) Services() []ServiceInfo {
+	return p.ServicesByLabels(nil)
+}
+
+func (p *pool) ServicesByLabels(labels map[string]string) []ServiceInfo {
+	p.mutex.Lock()
+	entries := append([]*poolEntry{}, p.entries...)
+	statuses := make([]entryStatus, len(entries))
+	for i, entry := range entries {
+		statuses[i] = entry.status()
+	}
+	p.mutex.Unlock()
+
+	result := make([]ServiceInfo, 0, len(entries))
+	for i, entry := range entries {
+		if info := entry.info(statuses[i]); info.hasLabels(labels) {
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+func (p *pool) Lifecycle(id string) (Lifecycle, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, ok := p.ids[id]
+	if !ok {
+		return nil, false
+	}
+	return entry.lifecycle, true
+}
+
+func (p *pool) Subscribe(ctx context.Context) <-chan Event {
+	return p.events.subscribe(ctx)
+}
+
+func (p *pool) SubscribeWithOptions(ctx context.Context, options SubscribeOptions) <-chan Event {
+	if options.BufferSize < 0 {
+		panic("bug: negative event buffer size")
+	}
+	if err := options.Policy.Validate(); err != nil {
+		panic("bug: " + err.Error())
+	}
+	return p.events.subscribeWithOptions(ctx, options.BufferSize, options.Policy)
+}
+
+func (p *pool) addEventListener(listener func(event Event)) {
+	p.events.addEventListener(listener)
+}
+
+// forwardEvents passes the events of a service and, if the service is a nested pool, of its children to the
+// subscribers of this pool. Hooks of the service that misbehave are logged as soon as they are reported.
+func (p *pool) forwardEvents(s Service, l Lifecycle) {
+	if source, ok := s.(eventSource); ok {
+		source.addEventListener(p.events.publish)
+	}
+	if source, ok := l.(eventSource); ok {
+		source.addEventListener(p.receiveEvent)
+		return
+	}
+	// Lifecycles from custom factories are subscribed to for as long as the pool exists.
+	events := l.Subscribe(context.Background())
+	go func() {
+		for event := range events {
+			p.receiveEvent(event)
+		}
+	}()
+}
+
+// receiveEvent handles an event of a lifecycle of this pool. Events of the services of nested pools are logged by the
+// nested pool, and misbehaving hooks of lifecycles with a logger of their own by the lifecycle.
+func (p *pool) receiveEvent(event Event) {
+	var hookError *HookError
+	if event.Type == EventTypeHookError && !logsHooks(event.Lifecycle) && errors.As(event.Error, &hookError) {
+		if message := misbehavingHookMessage(hookError); message != nil {
+			p.logger.Error(message)
+		}
+	}
+	p.events.publish(event)
+}
+
+// logsHooks returns true if the lifecycle logs its misbehaving hooks itself.
+func logsHooks(l Lifecycle) bool {
+	own, ok := l.(*lifecycle)
+	return ok && own.config.Logger != nil
 }
 
 func (p *pool) RunWithLifecycle(lifecycle Lifecycle) error {
+	entries, err := p.reset()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		p.mutex.Lock()
+		p.running = false
+		if p.cancelShutdown != nil {
+			p.cancelShutdown()
+		}
+		p.mutex.Unlock()
+	}()
+
+	go p.launchServices(entries)
+
+	switch {
+	case !p.waitUntil(lifecycle.Context().Done(), func() bool {
+		return p.unexpected > 0 || p.ready+p.skipped == len(entries)
+	}):
+		p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
+		lifecycle.Stopping()
+		p.triggerStop(lifecycle.ShutdownContext(), nil)
+	case p.hasExited():
+		p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
+		lifecycle.Stopping()
+		p.triggerInternalStop()
+	default:
+		p.processRunning(lifecycle)
+	}
+
+	p.waitUntil(nil, func() bool {
+		return p.launchDone && p.exited == p.launched
+	})
+	p.logger.Info(log.NewMessage(MServicesStopped, "All services have stopped."))
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lastError
+}
+
+// reset prepares the pool for a new run and returns the services to run in the order they should be started. It
+// returns an error if the dependencies of the services cannot be satisfied.
+func (p *pool) reset() ([]*poolEntry, error) {
 	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	if p.running {
-		p.mutex.Unlock()
 		panic("bug: pool already running, cannot run again")
 	}
+	if err := p.computeWaves(); err != nil {
+		return nil, err
+	}
 	p.logger.Info(log.NewMessage(MServicesStarting, "Services are starting..."))
-	p.stopComplete = make(chan struct{}, len(p.services))
 	p.running = true
 	p.stopping = false
+	p.lastError = nil
+	p.launched = 0
+	p.ready = 0
+	p.skipped = 0
+	p.exited = 0
+	p.unexpected = 0
+	p.started = false
+	p.launchDone = false
+	p.stopRequested = make(chan struct{})
+	p.shutdownContext = nil
+	p.cancelShutdown = nil
+	p.startSlots = newSlots(p.config.StartConcurrency)
+	p.stopSlots = newSlots(p.config.StopConcurrency)
+	for _, entry := range p.entries {
+		entry.launched = false
+		entry.exited = false
+		entry.wasReady = false
+		entry.done = nil
+		entry.manualStop = false
+		entry.holdsStartSlot = false
+		entry.holdsStopSlot = false
+		entry.crashes = nil
+		entry.closeCircuit()
+	}
+	p.order = append([]*poolEntry{}, p.entries...)
+	sort.SliceStable(p.order, func(i, j int) bool {
+		return p.order[i].wave < p.order[j].wave
+	})
+	return p.order, nil
+}
+
+func (p *pool) processRunning(lifecycle Lifecycle) {
+	p.logger.Info(log.NewMessage(MServicesRunning, "All services are now running."))
+
+	p.mutex.Lock()
+	p.started = true
+	p.mutex.Unlock()
+	lifecycle.Running()
+
+	if p.waitUntil(lifecycle.Context().Done(), func() bool {
+		return p.unexpected > 0
+	}) {
+		// One service stopped, the shutdown has already been initiated by its state change.
+		p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
+		p.triggerInternalStop()
+		return
+	}
+	p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
+	lifecycle.Stopping()
+	p.waitPreStopDelay(lifecycle.ShutdownContext())
+	p.triggerStop(lifecycle.ShutdownContext(), nil)
+}
+
+// waitPreStopDelay keeps the services running for the pre-stop delay. The wait ends early if the shutdown context
+// expires or a service exits.
+func (p *pool) waitPreStopDelay(shutdownContext context.Context) {
+	if p.config.PreStopDelay <= 0 {
+		return
+	}
+	delayContext, cancel := withClockTimeout(shutdownContext, p.config.Clock, p.config.PreStopDelay)
+	defer cancel()
+	p.waitUntil(delayContext.Done(), func() bool {
+		return p.unexpected > 0
+	})
+}
+
+// waitUntil waits until the condition, which is evaluated with the mutex held, becomes true. It returns false if the
+// done channel is closed first.
+func (p *pool) waitUntil(done <-chan struct{}, condition func() bool) bool {
+	for {
+		p.mutex.Lock()
+		result := condition()
+		changed := p.changed
+		p.mutex.Unlock()
+		if result {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-done:
+			return false
+		}
+	}
+}
+
+func (p *pool) hasExited() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.unexpected > 0
+}
+
+// notify wakes up everyone waiting for the services to re-evaluate their state.
+func (p *pool) notify() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// launchServices starts the services in waves of equal start wave, lowest wave first. Within a wave the services are
+// started in the order they were added, respecting the start concurrency limit and the delay between starts. A wave
+// is only started once all services of the previous wave are running, apart from optional services that have
+// exited. It stops launching services once the pool is shutting down or a service has exited. The entries must be
+// sorted by start wave.
+func (p *pool) launchServices(entries []*poolEntry) {
+	p.mutex.Lock()
+	stopRequested := p.stopRequested
 	p.mutex.Unlock()
 	defer func() {
 		p.mutex.Lock()
-		p.running = false
+		p.launchDone = true
 		p.mutex.Unlock()
+		p.notify()
 	}()
 
-	for _, service := range p.services {
-		p.runService(service)
+	for i := 0; i < len(entries); {
+		wave := entries[i].wave
+		start := i
+		for ; i < len(entries) && entries[i].wave == wave; i++ {
+			if !p.launchService(entries[i], i == 0, stopRequested) {
+				return
+			}
+		}
+		launched := entries[start:i]
+		if !p.waitUntil(stopRequested, func() bool {
+			return p.unexpected > 0 || allSettled(launched)
+		}) || p.hasExited() {
+			return
+		}
 	}
+}
 
-	stopped := false
-	startedServices := len(p.services)
-	finished := false
-	for i := 0; i < len(p.services); i++ {
-		select {
-		case <-p.startupComplete:
-		case <-p.stopComplete:
-			stopped = true
-			startedServices--
-			finished = true
-		}
-		if finished {
-			break
+// launchService waits for the start delay and a start slot, then runs the service. It returns false if the pool is
+// stopping and no further services should be launched.
+func (p *pool) launchService(entry *poolEntry, first bool, stopRequested <-chan struct{}) bool {
+	if !first && !p.waitStartDelay(stopRequested) {
+		return false
+	}
+	p.mutex.Lock()
+	startSlots := p.startSlots
+	p.mutex.Unlock()
+	if !acquireSlot(startSlots, stopRequested) {
+		return false
+	}
+	p.mutex.Lock()
+	if p.stopping {
+		releaseSlot(startSlots, true)
+		p.mutex.Unlock()
+		return false
+	}
+	entry.launched = true
+	entry.holdsStartSlot = true
+	entry.done = make(chan struct{})
+	p.launched++
+	p.mutex.Unlock()
+	go p.runService(entry)
+	return true
+}
+
+// waitStartDelay waits for the configured delay between two starts plus a random jitter. It returns false if the
+// pool is stopping.
+func (p *pool) waitStartDelay(stopRequested <-chan struct{}) bool {
+	delay := p.config.StartDelay
+	if p.config.StartJitter > 0 {
+		delay += time.Duration(p.random.Int63n(int64(p.config.StartJitter)))
+	}
+	if delay <= 0 {
+		return true
+	}
+	timer := p.config.Clock.NewTimer(delay)
+	select {
+	case <-timer.C():
+		return true
+	case <-stopRequested:
+		timer.Stop()
+		return false
+	}
+}
+
+// allSettled returns true if all specified services are running or are optional and have exited for good. It must
+// be called with the mutex held.
+func allSettled(entries []*poolEntry) bool {
+	for _, entry := range entries {
+		if entry.state != StateRunning && !(entry.options.Optional && entry.exited) {
+			return false
 		}
 	}
+	return true
+}
+
+// runService runs the service until it exits and is not restarted.
+func (p *pool) runService(entry *poolEntry) {
+	for {
+		_ = entry.lifecycle.Run()
+		exitError := entry.lifecycle.Error()
+		p.logHookErrors(entry.service, exitError)
 
-	startedServices = p.processRunning(lifecycle, stopped, startedServices)
+		p.mutex.Lock()
+		entry.exited = true
+		p.exited++
+		restart, stop, crashLoop := p.processExit(entry, exitError)
+		close(entry.done)
+		releaseSlot(p.startSlots, entry.holdsStartSlot)
+		entry.holdsStartSlot = false
+		releaseSlot(p.stopSlots, entry.holdsStopSlot)
+		entry.holdsStopSlot = false
+		p.mutex.Unlock()
+		p.notify()
 
-	for i := 0; i < startedServices; i++ {
-		<-p.stopComplete
+		if crashLoop {
+			p.logCrashLoop(entry, exitError, stop)
+		}
+		if stop {
+			p.triggerInternalStop()
+		}
+		if !restart || !p.relaunch(entry) {
+			return
+		}
 	}
-	p.logger.Info(log.NewMessage(MServicesStopped, "All services have stopped."))
-	return p.lastError
 }
 
-func (p *pool) processRunning(lifecycle Lifecycle, stopped bool, startedServices int) int {
-	if !stopped {
-		p.logger.Info(log.NewMessage(MServicesRunning, "All services are now running."))
+// processExit decides what happens after a service has exited. It returns whether the service should be restarted,
+// whether the pool should shut down and whether the service has been detected to crash in a loop. It must be called
+// with the mutex held.
+func (p *pool) processExit(entry *poolEntry, exitError error) (restart bool, stop bool, crashLoop bool) {
+	switch {
+	case entry.manualStop:
+		return false, false, false
+	case !p.stopping && entry.options.Restart.restarts(entry.state):
+		if !p.detectCrashLoop(entry) {
+			return true, false, false
+		}
+		if !entry.options.CrashLoop.StopPool || entry.options.Optional {
+			return true, false, true
+		}
+		p.unexpected++
+		p.lastError = &CrashLoopError{
+			ID:      entry.options.ID,
+			Crashes: entry.options.CrashLoop.MaxCrashes,
+			Window:  entry.options.CrashLoop.Window,
+			Cause:   exitError,
+		}
+		return false, true, true
+	case entry.options.Optional:
+		if !entry.wasReady {
+			p.skipped++
+		}
+		return false, false, false
+	}
+	p.unexpected++
+	if entry.detached() && entry.state == StateCrashed {
+		p.lastError = exitError
+	}
+	return false, entry.detached(), false
+}
 
-		lifecycle.Running()
+// detached returns true if the exit of the service is only handled once it has exited rather than by its state
+// changes, because it may be restarted or is optional.
+func (e *poolEntry) detached() bool {
+	return e.options.Optional || e.options.Restart.restarts(StateCrashed)
+}
 
+// relaunch waits for the restart delay, or the cooldown if the circuit of the service is open, and runs the service
+// again. It returns false if the pool has started shutting down or the service has been stopped or restarted through
+// the pool in the meantime.
+func (p *pool) relaunch(entry *poolEntry) bool {
+	p.mutex.Lock()
+	stopRequested := p.stopRequested
+	delay := entry.options.RestartDelay
+	circuitOpen := entry.circuitOpen
+	if circuitOpen {
+		delay = entry.options.CrashLoop.Cooldown
+	}
+	p.mutex.Unlock()
+	if !circuitOpen {
+		p.logger.Info(
+			log.NewMessage(
+				MServiceRestarting,
+				"%s has exited and will be restarted in %s.",
+				entry.service.String(),
+				delay,
+			).Label("service", entry.service.String()),
+		)
+	}
+	if delay > 0 {
+		timer := p.config.Clock.NewTimer(delay)
 		select {
-		case <-p.stopComplete:
-			// One service stopped, initiate shutdown
-			p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
-			startedServices--
-		case <-lifecycle.Context().Done():
-			p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
-			lifecycle.Stopping()
-			p.triggerStop(lifecycle.ShutdownContext())
-		}
-	} else {
-		p.logger.Info(log.NewMessage(MServicesStopping, "Services are now stopping..."))
-		lifecycle.Stopping()
-		p.triggerStop(context.Background())
+		case <-timer.C():
+		case <-stopRequested:
+			timer.Stop()
+			return false
+		}
 	}
-	return startedServices
-}
 
-func (p *pool) runService(service Service) {
-	go func() {
-		_ = p.lifecycles[service].Run()
-	}()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stopping || entry.manualStop || !entry.exited {
+		return false
+	}
+	entry.closeCircuit()
+	entry.launched = true
+	entry.exited = false
+	entry.done = make(chan struct{})
+	p.launched++
+	return true
 }
 
-func (p *pool) onServiceStateChange(s Service, l Lifecycle, newState State) {
-	if s == p {
-		return
+// logHookErrors logs the hook failures contained in the error returned by Lifecycle.Error(). Hooks that timed out or
+// panicked have already been logged by receiveEvent when they misbehaved.
+func (p *pool) logHookErrors(s Service, err error) {
+	for _, e := range Errors(err) {
+		var hookError *HookError
+		if !errors.As(e, &hookError) || misbehavingHookMessage(hookError) != nil {
+			continue
+		}
+		p.logger.Warning(
+			log.Wrap(
+				hookError.Cause,
+				EServiceHookFailed,
+				"%s hook %s of %s failed",
+				hookError.Type,
+				hookError.Hook,
+				s.String(),
+			).Label("service", s.String()).Label("hook", hookError.Hook).Label("hookType", string(hookError.Type)),
+		)
 	}
+}
 
+func (p *pool) onServiceStateChange(entry *poolEntry, newState State) {
+	s := entry.service
 	p.mutex.Lock()
-	oldState := p.serviceStates[s]
-	p.serviceStates[s] = newState
+	oldState := entry.state
+	entry.state = newState
+	manualStop := entry.manualStop
+	detached := entry.detached()
+	if oldState != newState {
+		entry.recordState(p.config.Clock.Now(), newState, p.historySize())
+	}
+	if newState == StateRunning && oldState != newState {
+		if !entry.wasReady {
+			entry.wasReady = true
+			p.ready++
+		}
+		releaseSlot(p.startSlots, entry.holdsStartSlot)
+		entry.holdsStartSlot = false
+	}
 	p.mutex.Unlock()
 
 	if oldState == newState {
 		return
 	}
+	p.notify()
 
 	switch newState {
 	case StateStarting:
 		p.logger.Info(log.NewMessage(MServiceStarting, "%s is starting...", s.String()).Label("service", s.String()))
+		p.stopIfStopping(entry)
 		return
 	case StateRunning:
 		p.logger.Info(log.NewMessage(MServiceRunning, "%s is running.", s.String()).Label("service", s.String()))
-		select {
-		case p.startupComplete <- struct{}{}:
-		default:
-		}
 	case StateStopping:
 		p.logger.Info(log.NewMessage(MServiceStopping, "%s is stopping...", s.String()).Label("service", s.String()))
-		p.triggerStop(context.Background())
 	case StateStopped:
 		p.logger.Info(log.NewMessage(MServiceStopped, "%s has stopped.", s.String()).Label("service", s.String()))
-		p.triggerStop(context.Background())
-		p.stopComplete <- struct{}{}
 	case StateCrashed:
 		p.logger.Error(log.NewMessage(EServiceCrashed, "%s has crashed.", s.String()).Label("service", s.String()))
-		p.lastError = l.Error()
-		p.triggerStop(context.Background())
-		p.stopComplete <- struct{}{}
+		if !manualStop && !detached {
+			p.mutex.Lock()
+			p.lastError = entry.lifecycle.Error()
+			p.mutex.Unlock()
+		}
+	}
+	if !manualStop && !detached && (newState == StateStopping || newState == StateStopped || newState == StateCrashed) {
+		p.triggerInternalStop()
 	}
 }
 
-func (p *pool) triggerStop(shutdownContext context.Context) {
+// stopIfStopping stops a service that has only started after the pool has begun shutting down, or after it was
+// stopped through StopService. Such a service was still in the "stopped" state when the stop was requested, so the
+// stop had no effect.
+func (p *pool) stopIfStopping(entry *poolEntry) {
+	p.mutex.Lock()
+	stopping := p.stopping
+	shutdownContext := p.shutdownContext
+	if entry.manualStop {
+		stopping = true
+		shutdownContext = entry.shutdownContext
+	}
+	p.mutex.Unlock()
+	if stopping {
+		stopLifecycle(entry.lifecycle, shutdownContext)
+	}
+}
+
+// triggerInternalStop stops all services because one of them has exited. The shutdown is bounded by the configured
+// shutdown timeout.
+func (p *pool) triggerInternalStop() {
+	p.mutex.Lock()
+	stopping := p.stopping
+	p.mutex.Unlock()
+	if stopping {
+		return
+	}
+	shutdownContext, cancel := withClockTimeout(context.Background(), p.config.Clock, p.config.ShutdownTimeout)
+	p.triggerStop(shutdownContext, cancel)
+}
+
+// triggerStop initiates the shutdown of all services without waiting for them to exit. The cancel function, if any,
+// is called when the pool has finished running.
+func (p *pool) triggerStop(shutdownContext context.Context, cancel func()) {
 	p.mutex.Lock()
 	if p.stopping {
 		p.mutex.Unlock()
+		if cancel != nil {
+			cancel()
+		}
 		return
 	}
 	p.stopping = true
-	svc := p.services
+	p.shutdownContext = shutdownContext
+	p.cancelShutdown = cancel
+	close(p.stopRequested)
+	entries := p.order
+	stopSlots := p.stopSlots
+	p.mutex.Unlock()
+
+	go p.stopServices(entries, shutdownContext, stopSlots)
+}
+
+// stopServices stops the launched services wave by wave in the reverse order of their start, respecting the stop
+// concurrency limit. A wave is only stopped once all services of the later waves have exited, so services are
+// stopped before the services they depend on. The wait for a wave ends when the shutdown context ends. The entries
+// must be sorted by start wave.
+func (p *pool) stopServices(entries []*poolEntry, shutdownContext context.Context, stopSlots chan struct{}) {
+	for end := len(entries); end > 0; {
+		start := end - 1
+		for start > 0 && entries[start-1].wave == entries[end-1].wave {
+			start--
+		}
+		wave := entries[start:end]
+		for i := len(wave) - 1; i >= 0; i-- {
+			p.stopEntry(wave[i], shutdownContext, stopSlots)
+		}
+		p.waitUntil(shutdownContext.Done(), func() bool {
+			for _, entry := range wave {
+				if entry.launched && !entry.exited {
+					return false
+				}
+			}
+			return true
+		})
+		end = start
+	}
+}
+
+// stopEntry requests a launched service to stop once a stop slot is free.
+func (p *pool) stopEntry(entry *poolEntry, shutdownContext context.Context, stopSlots chan struct{}) {
+	p.mutex.Lock()
+	skip := !entry.launched || entry.exited
 	p.mutex.Unlock()
+	if skip {
+		return
+	}
+	acquireSlot(stopSlots, nil)
+	p.mutex.Lock()
+	if entry.exited {
+		releaseSlot(stopSlots, true)
+		p.mutex.Unlock()
+		return
+	}
+	entry.holdsStopSlot = true
+	p.mutex.Unlock()
+	stopLifecycle(entry.lifecycle, shutdownContext)
+}
+
+// stopLifecycle requests a lifecycle to stop without waiting for the service to exit.
+func stopLifecycle(l Lifecycle, shutdownContext context.Context) {
+	if requester, ok := l.(stopRequester); ok {
+		requester.requestStop(shutdownContext)
+		return
+	}
+	go l.Stop(shutdownContext)
+}
+
+// newSlots creates a semaphore with the specified number of slots, or nil if the number is not limited.
+func newSlots(limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	return make(chan struct{}, limit)
+}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(svc))
-	for _, s := range svc {
-		service := s
-		l := p.lifecycles[service]
-		go func() {
-			defer wg.Done()
-			l.Stop(shutdownContext)
-		}()
-	}
-	wg.Wait()
+// acquireSlot waits for a free slot in the semaphore. It returns false if the abort channel is closed first. A nil
+// semaphore has unlimited slots.
+func acquireSlot(slots chan struct{}, abort <-chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-abort:
+		return false
+	}
+}
+
+// releaseSlot frees a slot acquired with acquireSlot if held is true. A nil semaphore has nothing to release.
+func releaseSlot(slots chan struct{}, held bool) {
+	if held && slots != nil {
+		<-slots
+	}
 }
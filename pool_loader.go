@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/containerssh/log"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadPool parses a pool definition in the YAML or JSON format, validates it and creates a pool with the services it
+// describes using the factories of the registry. The services are not started until the pool is run. If the
+// definition is invalid, a *PoolDefinitionError listing all problems is returned.
+func LoadPool(data []byte, registry ServiceRegistry, logger log.Logger) (Pool, error) {
+	definition, err := ParsePoolDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+	return definition.Build(registry, logger)
+}
+
+// LoadPoolFile reads a pool definition from a YAML or JSON file and creates the pool like LoadPool().
+func LoadPoolFile(path string, registry ServiceRegistry, logger log.Logger) (Pool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool definition %s (%w)", path, err)
+	}
+	return LoadPool(data, registry, logger)
+}
+
+// ParsePoolDefinition parses and validates a pool definition in the YAML or JSON format. Unknown fields are rejected.
+// If the definition is invalid, a *PoolDefinitionError listing all problems with their line numbers is returned.
+func ParsePoolDefinition(data []byte) (*PoolDefinition, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, &PoolDefinitionError{Problems: yamlProblems(err, "", 0)}
+	}
+	if len(document.Content) == 0 {
+		return nil, &PoolDefinitionError{Problems: []PoolDefinitionProblem{{Message: "the pool definition is empty"}}}
+	}
+	definition := &PoolDefinition{}
+	if err := document.Decode(definition); err != nil {
+		return nil, &PoolDefinitionError{Problems: yamlProblems(err, "", 0)}
+	}
+	if err := definition.Validate(); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+// Build validates the definition and creates a pool with the services it describes using the factories of the
+// registry. All services are created before any problem is reported, so the returned *PoolDefinitionError lists the
+// problems of all services, including unknown types and errors returned by the factories.
+func (d *PoolDefinition) Build(registry ServiceRegistry, logger log.Logger) (Pool, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	var problems []PoolDefinitionProblem
+	services := make([]Service, len(d.Services))
+	for i, definition := range d.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		factory, ok := registry.Factory(definition.Type)
+		if !ok {
+			types := strings.Join(registry.Types(), ", ")
+			problems = append(problems, PoolDefinitionProblem{
+				Path:    path + ".type",
+				Line:    definition.line,
+				Message: fmt.Sprintf("unknown service type %s, expected one of: %s", definition.Type, types),
+			})
+			continue
+		}
+		s, err := factory(definition, logger)
+		if err != nil {
+			problems = append(problems, yamlProblems(err, path+".config", definition.line)...)
+			continue
+		}
+		services[i] = s
+	}
+	if len(problems) > 0 {
+		return nil, &PoolDefinitionError{Problems: problems}
+	}
+
+	// The lifecycles report problems, such as illegal transitions and misbehaving hooks, to the logger of the pool.
+	lifecycleFactory, err := NewLifecycleFactoryWithConfig(LifecycleConfig{Logger: logger})
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPoolWithConfig(d.poolConfig(), lifecycleFactory, logger)
+	if err != nil {
+		return nil, err
+	}
+	for i, definition := range d.Services {
+		options := definition.serviceOptions()
+		if lifecycleConfig := definition.lifecycleConfig(nil); lifecycleConfig != (LifecycleConfig{}) {
+			lifecycleConfig.Logger = logger
+			if options.LifecycleFactory, err = NewLifecycleFactoryWithConfig(lifecycleConfig); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.AddWithOptions(services[i], options); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
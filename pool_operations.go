@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// defaultStateHistorySize is the number of state changes kept per service if no history size is configured.
+const defaultStateHistorySize = 32
+
+// StateChange is an entry in the state history of a service.
+type StateChange struct {
+	// Time is the time the service entered the state.
+	Time time.Time `json:"time"`
+	// State is the state the service entered.
+	State State `json:"state"`
+}
+
+func (p *pool) StopService(shutdownContext context.Context, id string) error {
+	entry, err := p.operableEntry(id)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	if !entry.launched || entry.exited {
+		// A service waiting to be restarted is not restarted anymore.
+		entry.manualStop = entry.launched
+		p.mutex.Unlock()
+		return nil
+	}
+	entry.manualStop = true
+	entry.shutdownContext = shutdownContext
+	done := entry.done
+	p.mutex.Unlock()
+
+	stopLifecycle(entry.lifecycle, shutdownContext)
+	select {
+	case <-done:
+		return nil
+	case <-shutdownContext.Done():
+		return shutdownContext.Err()
+	}
+}
+
+func (p *pool) RestartService(ctx context.Context, id string) error {
+	if err := p.StopService(ctx, id); err != nil {
+		return err
+	}
+	entry, err := p.operableEntry(id)
+	if err != nil {
+		return err
+	}
+	ready := entry.lifecycle.Ready()
+
+	p.mutex.Lock()
+	if entry.launched && !entry.exited {
+		// Someone else has restarted the service in the meantime.
+		p.mutex.Unlock()
+		return nil
+	}
+	entry.launched = true
+	entry.exited = false
+	entry.manualStop = false
+	entry.crashes = nil
+	entry.closeCircuit()
+	entry.done = make(chan struct{})
+	done := entry.done
+	p.launched++
+	p.mutex.Unlock()
+	go p.runService(entry)
+
+	select {
+	case <-ready:
+		return nil
+	case <-done:
+		if err := entry.lifecycle.Error(); err != nil {
+			return err
+		}
+		return ErrServiceExited
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// operableEntry returns the entry of the service with the specified ID if the pool is running and not shutting down.
+func (p *pool) operableEntry(id string) (*poolEntry, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, ok := p.ids[id]
+	if !ok {
+		return nil, &ServiceNotFoundError{ID: id}
+	}
+	if !p.running || !p.started || p.stopping {
+		return nil, ErrPoolNotRunning
+	}
+	return entry, nil
+}
+
+func (p *pool) History(id string) ([]StateChange, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, ok := p.ids[id]
+	if !ok {
+		return nil, &ServiceNotFoundError{ID: id}
+	}
+	return append([]StateChange{}, entry.history...), nil
+}
+
+// historySize returns the number of state changes to keep per service.
+func (p *pool) historySize() int {
+	if p.config.StateHistorySize == 0 {
+		return defaultStateHistorySize
+	}
+	return p.config.StateHistorySize
+}
+
+// recordState adds a state change to the history of the service, discarding the oldest entries beyond the size. It
+// must be called with the pool mutex held.
+func (e *poolEntry) recordState(t time.Time, state State, size int) {
+	e.history = append(e.history, StateChange{
+		Time:  t,
+		State: state,
+	})
+	if len(e.history) > size {
+		e.history = append([]StateChange{}, e.history[len(e.history)-size:]...)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServiceOptions holds the settings for a single service in a pool. The zero value is a valid configuration.
+type ServiceOptions struct {
+	// ID identifies the service within the pool. It must be unique within the pool. Defaults to the name of the
+	// service, followed by a number if the name is already taken.
+	ID string
+	// Labels are arbitrary key-value pairs describing the service. They can be used to look up services.
+	Labels map[string]string
+	// StartPriority determines the start wave of the service. Services with a lower priority are started first, and
+	// the services of a wave are only started once all services of the previous waves are running. Services with the
+	// same priority are started in the order they were added. Defaults to 0.
+	StartPriority int
+	// DependsOn lists the IDs of the services that must be running before this service is started. The service is
+	// started in a later wave than its dependencies and stopped before them. Unknown IDs and dependency cycles make
+	// the pool fail to start.
+	DependsOn []string
+	// Optional marks a service whose exit does not shut down the pool. Optional services that exit during startup
+	// do not prevent the pool from becoming ready.
+	Optional bool
+	// Restart determines whether the pool restarts the service when it exits on its own instead of shutting down.
+	// Defaults to RestartNever.
+	Restart RestartPolicy
+	// RestartDelay is the time the pool waits before restarting the service. Zero means no delay.
+	RestartDelay time.Duration
+	// CrashLoop configures the detection of a restarted service that keeps crashing. Defaults to no detection.
+	CrashLoop CrashLoopConfig
+	// LifecycleFactory creates the lifecycle of this service, for example to use different timeouts than the other
+	// services. Defaults to the lifecycle factory of the pool.
+	LifecycleFactory LifecycleFactory
+}
+
+// Validate checks the service options for errors.
+func (o *ServiceOptions) Validate() error {
+	for _, dependency := range o.DependsOn {
+		if dependency == "" {
+			return fmt.Errorf("empty service ID in the dependencies")
+		}
+		if dependency == o.ID && o.ID != "" {
+			return fmt.Errorf("the service %s depends on itself", o.ID)
+		}
+	}
+	if o.RestartDelay < 0 {
+		return fmt.Errorf("the restart delay must not be negative")
+	}
+	if err := o.Restart.Validate(); err != nil {
+		return err
+	}
+	return o.CrashLoop.Validate()
+}
+
+// RestartPolicy determines when a pool restarts a service that has exited on its own. Services stopped through the
+// pool are never restarted.
+type RestartPolicy string
+
+const (
+	// RestartNever shuts down the pool when the service exits, unless the service is optional. This is the default.
+	RestartNever RestartPolicy = "never"
+	// RestartOnFailure restarts the service when it crashes.
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways restarts the service whenever it exits.
+	RestartAlways RestartPolicy = "always"
+)
+
+// Validate checks if the restart policy is one of the supported values.
+func (p RestartPolicy) Validate() error {
+	switch p {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	default:
+		return fmt.Errorf("invalid restart policy: %s", p)
+	}
+}
+
+// restarts returns true if a service that exited in the specified state should be restarted.
+func (p RestartPolicy) restarts(state State) bool {
+	switch p {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return state == StateCrashed
+	default:
+		return false
+	}
+}
+
+// CrashLoopConfig configures the crash loop detection of a service that is restarted on crashes. When the service
+// crashes MaxCrashes times within the Window, the pool opens the circuit of the service: it stops restarting the
+// service for the Cooldown, then restarts it with a clean slate. The zero value disables the detection.
+type CrashLoopConfig struct {
+	// MaxCrashes is the number of crashes within the window that opens the circuit. Zero disables the detection.
+	MaxCrashes int `yaml:"maxCrashes"`
+	// Window is the period in which the crashes are counted. Required if MaxCrashes is set.
+	Window time.Duration `yaml:"window"`
+	// Cooldown is the time the circuit stays open before the service is restarted again. Required if MaxCrashes is
+	// set.
+	Cooldown time.Duration `yaml:"cooldown"`
+	// StopPool shuts down the pool instead of waiting for the cooldown when the circuit of a critical service opens.
+	// The pool then fails with a *CrashLoopError. Optional services always wait for the cooldown.
+	StopPool bool `yaml:"stopPool"`
+}
+
+// Validate checks the crash loop configuration for errors.
+func (c CrashLoopConfig) Validate() error {
+	if c.MaxCrashes < 0 {
+		return fmt.Errorf("the maximum number of crashes must not be negative")
+	}
+	if c.MaxCrashes == 0 {
+		return nil
+	}
+	if c.Window <= 0 {
+		return fmt.Errorf("the crash loop window must be positive")
+	}
+	if c.Cooldown <= 0 {
+		return fmt.Errorf("the crash loop cooldown must be positive")
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes the crash loop configuration of a service definition and rejects unknown fields.
+func (c *CrashLoopConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain CrashLoopConfig
+	return decodeStrict(value, (*plain)(c))
+}
+
+// ServiceInfo describes a service registered in a pool.
+type ServiceInfo struct {
+	// ID is the unique ID of the service within the pool.
+	ID string
+	// Labels are the labels the service was registered with.
+	Labels map[string]string
+	// Service is the service itself.
+	Service Service
+	// Lifecycle is the lifecycle of the service.
+	Lifecycle Lifecycle
+	// State is the state of the service at the time the information was retrieved.
+	State State
+	// Since is the time the service entered its current state in the pool. It is zero if the service has not been
+	// run by the pool yet.
+	Since time.Time
+	// DependsOn lists the IDs of the services this service depends on.
+	DependsOn []string
+	// Optional is true if the exit of the service does not shut down the pool.
+	Optional bool
+	// Restart is the restart policy of the service.
+	Restart RestartPolicy
+	// CircuitOpen is true if the service has been detected to crash in a loop and is not being restarted.
+	CircuitOpen bool
+	// CircuitOpenUntil is the time the service will be restarted again. It is zero if the circuit is closed, or if
+	// the crash loop has shut down the pool.
+	CircuitOpenUntil time.Time
+}
+
+// hasLabels returns true if the service has all of the specified labels with the specified values.
+func (i ServiceInfo) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if actual, ok := i.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
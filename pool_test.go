@@ -2,13 +2,18 @@ package service_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/containerssh/log"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/containerssh/service"
+	"github.com/containerssh/service/servicetest"
 )
 
 func TestEmptyPool(t *testing.T) {
@@ -171,10 +176,8 @@ func TestOneServiceStartupCrash(t *testing.T) {
 
 func TestTwoServices(t *testing.T) {
 	testLock := &sync.Mutex{}
-	pool, poolLifecycle, poolStarted, poolStopped, poolStates, serviceStates1, serviceStates2 := setupPoolForTwoServiceTest(
-		t,
-		testLock,
-	)
+	pool, poolLifecycle, poolStarted, poolStopped, poolStates, serviceStates1, serviceStates2 :=
+		setupPoolForTwoServiceTest(t, testLock)
 
 	s1 := newTestService("Test service 1")
 	pool.Add(s1).OnStateChange(
@@ -398,3 +401,720 @@ func TestTwoServicesOneTerminatedDuringStartup(t *testing.T) {
 		service.StateCrashed,
 	}, poolStates)
 }
+
+func TestPoolShutdownTimeout(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{
+			Clock:           clock,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	poolLifecycle := service.NewLifecycle(pool)
+	poolStarted := make(chan bool)
+	poolLifecycle.OnRunning(func(s service.Service, l service.Lifecycle) {
+		poolStarted <- true
+	})
+
+	s1 := newTestService("Test service 1")
+	pool.Add(s1)
+	s2 := newCallbackService("Test service 2", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		<-lifecycle.Stopping().Done()
+		return nil
+	})
+	pool.Add(s2)
+
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	<-poolStarted
+	s1.Crash()
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+	clock.Advance(30 * time.Second)
+	assert.Error(t, <-result)
+}
+
+func TestPoolServiceHookError(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	poolLifecycle := service.NewLifecycle(pool)
+	poolStarted := make(chan bool)
+	poolLifecycle.OnRunning(func(s service.Service, l service.Lifecycle) {
+		poolStarted <- true
+	})
+	hookErr := errors.New("hook failed")
+	serviceLifecycle := pool.Add(newTestService("Test service")).AddHook(service.Hook{
+		OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+			return hookErr
+		},
+	})
+
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	<-poolStarted
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.Equal(t, service.StateStopped, serviceLifecycle.State())
+	assert.True(t, errors.Is(serviceLifecycle.Error(), hookErr))
+}
+
+func TestPoolServiceHookMisbehaved(t *testing.T) {
+	logger, output := newRecordingLogger(t)
+	pool := service.NewPool(service.NewLifecycleFactory(), logger)
+	pool.Add(newTestService("Test service")).AddHook(service.Hook{
+		Name: "panicking",
+		OnRunning: func(s service.Service, l service.Lifecycle) error {
+			panic("out of coffee")
+		},
+	})
+	poolLifecycle, result := startPool(t, pool)
+
+	// The hook is reported while the service keeps running, not only once it exits.
+	assert.Eventually(t, func() bool {
+		return strings.Contains(output.String(), `"code":"SERVICE_HOOK_MISBEHAVED"`)
+	}, 10*time.Second, 10*time.Millisecond)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.Equal(t, 1, strings.Count(output.String(), `"code":"SERVICE_HOOK_MISBEHAVED"`))
+}
+
+// concurrencyTracker records the highest number of services that were in a phase at the same time.
+type concurrencyTracker struct {
+	lock    sync.Mutex
+	current int
+	max     int
+}
+
+func (c *concurrencyTracker) enter() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+}
+
+func (c *concurrencyTracker) leave() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.current--
+}
+
+func (c *concurrencyTracker) getMax() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.max
+}
+
+func TestPoolConcurrencyLimits(t *testing.T) {
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{
+			StartConcurrency: 2,
+			StopConcurrency:  3,
+		},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	starting := &concurrencyTracker{}
+	stopping := &concurrencyTracker{}
+	for i := 0; i < 10; i++ {
+		pool.Add(newCallbackService(fmt.Sprintf("Test service %d", i), func(lifecycle service.Lifecycle) error {
+			starting.enter()
+			time.Sleep(5 * time.Millisecond)
+			starting.leave()
+			lifecycle.Running()
+			<-lifecycle.Context().Done()
+			stopping.enter()
+			defer stopping.leave()
+			lifecycle.Stopping()
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		}))
+	}
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	select {
+	case <-poolLifecycle.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout while waiting for the pool to start")
+	}
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.Equal(t, 2, starting.getMax())
+	assert.Equal(t, 3, stopping.getMax())
+}
+
+func TestPoolStopDuringStartup(t *testing.T) {
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{StartConcurrency: 1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	release := make(chan struct{})
+	pool.Add(newCallbackService("Slow service", func(lifecycle service.Lifecycle) error {
+		<-release
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		return nil
+	}))
+	never := pool.Add(newTestService("Never started"))
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	_, err = poolLifecycle.WaitForState(context.Background(), service.StateStarting)
+	assert.NoError(t, err)
+	go poolLifecycle.Stop(context.Background())
+	_, err = poolLifecycle.WaitForState(context.Background(), service.StateStopping)
+	assert.NoError(t, err)
+	close(release)
+	assert.NoError(t, <-result)
+	assert.Equal(t, 0, never.Generation(), "the pool started a service after it was stopped")
+}
+
+func TestPoolConfigValidation(t *testing.T) {
+	_, err := service.NewPoolWithConfig(
+		service.PoolConfig{StartConcurrency: -1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.Error(t, err)
+	_, err = service.NewPoolWithConfig(
+		service.PoolConfig{StartDelay: -1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.Error(t, err)
+	_, err = service.NewPoolWithConfig(
+		service.PoolConfig{StartJitter: -1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.Error(t, err)
+	_, err = service.NewPoolWithConfig(
+		service.PoolConfig{StopConcurrency: -1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.Error(t, err)
+	_, err = service.NewPoolWithConfig(
+		service.PoolConfig{PreStopDelay: -1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.Error(t, err)
+	_, err = service.NewLifecycleWithConfig(newTestService("Test service"), service.LifecycleConfig{PreStopDelay: -1})
+	assert.Error(t, err)
+}
+
+func TestPoolStartWaves(t *testing.T) {
+	// A stop concurrency of 1 makes the reverse stop order observable.
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{StopConcurrency: 1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	lock := &sync.Mutex{}
+	var events []string
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	release := make(chan struct{})
+	newService := func(name string, gate <-chan struct{}) service.Service {
+		return newCallbackService(name, func(lifecycle service.Lifecycle) error {
+			record(name + " starting")
+			if gate != nil {
+				<-gate
+			}
+			lifecycle.Running()
+			<-lifecycle.Context().Done()
+			record(name + " stopping")
+			lifecycle.Stopping()
+			return nil
+		})
+	}
+	pool.AddWithOptions(newService("late", nil), service.ServiceOptions{StartPriority: 1})
+	pool.Add(newService("early", release))
+
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	_, err = poolLifecycle.WaitForState(context.Background(), service.StateStarting)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, []string{"early starting"}, events, "the second wave started before the first was running")
+	lock.Unlock()
+	close(release)
+	<-poolLifecycle.Ready()
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"early starting", "late starting", "late stopping", "early stopping"}, events)
+}
+
+func TestPoolStartDelay(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{
+			Clock:      clock,
+			StartDelay: 10 * time.Second,
+		},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	var lifecycles []service.Lifecycle
+	for i := 0; i < 3; i++ {
+		lifecycles = append(lifecycles, pool.Add(newTestService(fmt.Sprintf("Test service %d", i))))
+	}
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	for i := 1; i < 3; i++ {
+		<-lifecycles[i-1].Ready()
+		assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+		assert.Equal(t, 0, lifecycles[i].Generation(), "service %d started before the delay expired", i)
+		clock.Advance(10 * time.Second)
+	}
+	<-poolLifecycle.Ready()
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestPoolStartJitter(t *testing.T) {
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{
+			StartJitter:      time.Millisecond,
+			StartConcurrency: 2,
+		},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		pool.Add(newTestService(fmt.Sprintf("Test service %d", i)))
+	}
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	<-poolLifecycle.Ready()
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestPoolRegistry(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	l1 := pool.Add(newTestService("Test service"))
+	l2 := pool.Add(newTestService("Test service"))
+	labels := map[string]string{"tenant": "a", "type": "listener"}
+	l3, err := pool.AddWithOptions(newTestService("Listener"), service.ServiceOptions{
+		ID:     "listener-a",
+		Labels: labels,
+	})
+	assert.NoError(t, err)
+	labels["tenant"] = "modified"
+	_, err = pool.AddWithOptions(newTestService("Listener"), service.ServiceOptions{
+		ID:     "listener-b",
+		Labels: map[string]string{"tenant": "b", "type": "listener"},
+	})
+	assert.NoError(t, err)
+
+	_, err = pool.AddWithOptions(newTestService("Other"), service.ServiceOptions{ID: "listener-a"})
+	var duplicateError *service.DuplicateServiceIDError
+	assert.True(t, errors.As(err, &duplicateError))
+	assert.Equal(t, "listener-a", duplicateError.ID)
+
+	var ids []string
+	for _, info := range pool.Services() {
+		ids = append(ids, info.ID)
+		assert.Equal(t, service.StateStopped, info.State)
+	}
+	assert.Equal(t, []string{"Test service", "Test service-2", "listener-a", "listener-b"}, ids)
+
+	l, ok := pool.Lifecycle("Test service")
+	assert.True(t, ok)
+	assert.Equal(t, l1, l)
+	l, ok = pool.Lifecycle("Test service-2")
+	assert.True(t, ok)
+	assert.Equal(t, l2, l)
+	_, ok = pool.Lifecycle("nonexistent")
+	assert.False(t, ok)
+
+	listeners := pool.ServicesByLabels(map[string]string{"type": "listener"})
+	assert.Len(t, listeners, 2)
+	tenantA := pool.ServicesByLabels(map[string]string{"type": "listener", "tenant": "a"})
+	if assert.Len(t, tenantA, 1) {
+		assert.Equal(t, "listener-a", tenantA[0].ID)
+		assert.Equal(t, l3, tenantA[0].Lifecycle)
+		assert.Equal(t, "a", tenantA[0].Labels["tenant"], "the labels were not copied when the service was added")
+	}
+	assert.Empty(t, pool.ServicesByLabels(map[string]string{"tenant": "c"}))
+}
+
+func TestPoolStopServiceShutdownContext(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	release := make(chan struct{})
+	_, err := pool.AddWithOptions(newCallbackService("Stubborn", func(lifecycle service.Lifecycle) error {
+		lifecycle.Running()
+		<-lifecycle.Context().Done()
+		lifecycle.Stopping()
+		<-release
+		return nil
+	}), service.ServiceOptions{ID: "stubborn"})
+	assert.NoError(t, err)
+	poolLifecycle, result := startPool(t, pool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.StopService(ctx, "stubborn"), context.DeadlineExceeded)
+	close(release)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestPoolStopAndRestartService(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	s1 := newTestService("Test service 1")
+	l1, err := pool.AddWithOptions(s1, service.ServiceOptions{ID: "s1"})
+	assert.NoError(t, err)
+	l2, err := pool.AddWithOptions(newTestService("Test service 2"), service.ServiceOptions{ID: "s2"})
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, pool.StopService(context.Background(), "s1"), service.ErrPoolNotRunning)
+
+	poolLifecycle, result := startPool(t, pool)
+
+	var notFound *service.ServiceNotFoundError
+	assert.True(t, errors.As(pool.StopService(context.Background(), "nonexistent"), &notFound))
+
+	assert.NoError(t, pool.StopService(context.Background(), "s1"))
+	assert.Equal(t, service.StateStopped, l1.State())
+	assert.Equal(t, service.StateRunning, l2.State())
+	assert.Equal(t, service.StateRunning, poolLifecycle.State(), "stopping a single service stopped the pool")
+	assert.NoError(t, pool.StopService(context.Background(), "s1"), "stopping a stopped service failed")
+
+	assert.NoError(t, pool.RestartService(context.Background(), "s1"))
+	assert.Equal(t, service.StateRunning, l1.State())
+	assert.Equal(t, 2, l1.Generation())
+	assert.NoError(t, pool.RestartService(context.Background(), "s2"))
+	assert.Equal(t, service.StateRunning, l2.State())
+
+	history, err := pool.History("s1")
+	assert.NoError(t, err)
+	var states []service.State
+	for _, change := range history {
+		states = append(states, change.State)
+	}
+	assert.Equal(t, []service.State{
+		service.StateStarting,
+		service.StateRunning,
+		service.StateStopping,
+		service.StateStopped,
+		service.StateStarting,
+		service.StateRunning,
+	}, states)
+
+	// A crash after the restart must still stop the pool.
+	s1.Crash()
+	assert.Error(t, <-result)
+	assert.Equal(t, service.StateCrashed, poolLifecycle.State())
+	assert.ErrorIs(t, pool.RestartService(context.Background(), "s1"), service.ErrPoolNotRunning)
+}
+
+func TestPoolRestartServiceStartupCrash(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	s := newTestService("Test service")
+	_, err := pool.AddWithOptions(s, service.ServiceOptions{ID: "s"})
+	assert.NoError(t, err)
+	poolLifecycle, result := startPool(t, pool)
+
+	s.CrashStartup()
+	assert.Error(t, pool.RestartService(context.Background(), "s"))
+	assert.Error(t, <-result)
+	assert.Equal(t, service.StateCrashed, poolLifecycle.State())
+}
+
+func TestPoolStateHistorySize(t *testing.T) {
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{StateHistorySize: 3},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Test service"), service.ServiceOptions{ID: "s"})
+	assert.NoError(t, err)
+	poolLifecycle, result := startPool(t, pool)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+
+	history, err := pool.History("s")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, service.StateRunning, history[0].State)
+		assert.Equal(t, service.StateStopped, history[2].State)
+		assert.False(t, history[0].Time.After(history[2].Time))
+	}
+	_, err = pool.History("nonexistent")
+	assert.Error(t, err)
+}
+
+// startPool runs the pool on a new lifecycle and waits for it to enter the "running" state.
+func startPool(t *testing.T, pool service.Pool) (service.Lifecycle, <-chan error) {
+	t.Helper()
+	poolLifecycle := service.NewLifecycle(pool)
+	result := make(chan error, 1)
+	go func() {
+		result <- poolLifecycle.Run()
+	}()
+	select {
+	case <-poolLifecycle.Ready():
+	case err := <-result:
+		t.Fatalf("the pool exited during startup (%v)", err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout while waiting for the pool to start")
+	}
+	return poolLifecycle, result
+}
+
+func TestPoolPreStopDelay(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{
+			Clock:        clock,
+			PreStopDelay: 10 * time.Second,
+		},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	l := pool.Add(newTestService("Test service"))
+	poolLifecycle, result := startPool(t, pool)
+
+	go poolLifecycle.Stop(context.Background())
+	_, err = poolLifecycle.WaitForState(context.Background(), service.StateStopping)
+	assert.NoError(t, err)
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+	assert.Equal(t, service.StateRunning, l.State(), "the service was stopped before the pre-stop delay expired")
+
+	clock.Advance(10 * time.Second)
+	assert.NoError(t, <-result)
+	assert.Equal(t, service.StateStopped, l.State())
+}
+
+func TestPoolDependencies(t *testing.T) {
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{StopConcurrency: 1},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	lock := &sync.Mutex{}
+	var events []string
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	newService := func(name string) service.Service {
+		return newCallbackService(name, func(lifecycle service.Lifecycle) error {
+			record(name + " starting")
+			lifecycle.Running()
+			<-lifecycle.Context().Done()
+			record(name + " stopping")
+			lifecycle.Stopping()
+			return nil
+		})
+	}
+	// Added in reverse order so that only the dependencies determine the start order.
+	_, err = pool.AddWithOptions(
+		newService("app"),
+		service.ServiceOptions{ID: "app", DependsOn: []string{"cache", "db"}},
+	)
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newService("cache"), service.ServiceOptions{ID: "cache", DependsOn: []string{"db"}})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newService("db"), service.ServiceOptions{ID: "db"})
+	assert.NoError(t, err)
+
+	poolLifecycle, result := startPool(t, pool)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{
+		"db starting",
+		"cache starting",
+		"app starting",
+		"app stopping",
+		"cache stopping",
+		"db stopping",
+	}, events)
+}
+
+func TestPoolDependencyShutdown(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	lock := &sync.Mutex{}
+	var events []string
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	newService := func(name string, shutdownDuration time.Duration) service.Service {
+		return newCallbackService(name, func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			<-lifecycle.Context().Done()
+			record(name + " canceled")
+			lifecycle.Stopping()
+			// A slow shutdown gives a dependency stopped too early the chance to exit first.
+			time.Sleep(shutdownDuration)
+			record(name + " exited")
+			return nil
+		})
+	}
+	_, err := pool.AddWithOptions(newService("db", 0), service.ServiceOptions{ID: "db"})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(
+		newService("app", 50*time.Millisecond),
+		service.ServiceOptions{ID: "app", DependsOn: []string{"db"}},
+	)
+	assert.NoError(t, err)
+
+	poolLifecycle, result := startPool(t, pool)
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"app canceled", "app exited", "db canceled", "db exited"}, events)
+}
+
+func TestPoolDependencyErrors(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	_, err := pool.AddWithOptions(newTestService("a"), service.ServiceOptions{ID: "a", DependsOn: []string{"b"}})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("b"), service.ServiceOptions{ID: "b", DependsOn: []string{"a"}})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("c"), service.ServiceOptions{ID: "c", DependsOn: []string{"c"}})
+	assert.Error(t, err, "a service depending on itself was accepted")
+
+	var cycleError *service.DependencyCycleError
+	if assert.True(t, errors.As(service.NewLifecycle(pool).Run(), &cycleError)) {
+		assert.Equal(t, []string{"a", "b", "a"}, cycleError.Cycle)
+	}
+
+	pool = service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	_, err = pool.AddWithOptions(newTestService("a"), service.ServiceOptions{ID: "a", DependsOn: []string{"missing"}})
+	assert.NoError(t, err)
+	var unknownError *service.UnknownDependencyError
+	if assert.True(t, errors.As(service.NewLifecycle(pool).Run(), &unknownError)) {
+		assert.Equal(t, "missing", unknownError.Dependency)
+	}
+}
+
+func TestPoolOptionalService(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	optional := newTestService("Optional service")
+	optionalLifecycle, err := pool.AddWithOptions(optional, service.ServiceOptions{ID: "optional", Optional: true})
+	assert.NoError(t, err)
+	failing := newTestService("Failing optional service")
+	failing.CrashStartup()
+	_, err = pool.AddWithOptions(failing, service.ServiceOptions{ID: "failing", Optional: true})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Required service"), service.ServiceOptions{ID: "required"})
+	assert.NoError(t, err)
+
+	poolLifecycle, result := startPool(t, pool)
+	optional.Crash()
+	_, err = optionalLifecycle.WaitForState(context.Background(), service.StateCrashed)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, service.StateRunning, poolLifecycle.State(), "an optional service stopped the pool")
+
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+}
+
+func TestPoolRestartPolicy(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	pool, err := service.NewPoolWithConfig(
+		service.PoolConfig{Clock: clock},
+		service.NewLifecycleFactory(),
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+	s := newTestService("Test service")
+	l, err := pool.AddWithOptions(s, service.ServiceOptions{
+		ID:           "restarting",
+		Restart:      service.RestartOnFailure,
+		RestartDelay: time.Second,
+	})
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Other service"), service.ServiceOptions{ID: "other"})
+	assert.NoError(t, err)
+
+	poolLifecycle, result := startPool(t, pool)
+	s.Crash()
+	_, err = l.WaitForState(context.Background(), service.StateCrashed)
+	assert.NoError(t, err)
+	assert.True(t, clock.WaitForWaiters(1, 5*time.Second), "the restart delay was not scheduled")
+	assert.Equal(t, 1, l.Generation(), "the service was restarted before the restart delay")
+	clock.Advance(time.Second)
+	_, err = l.WaitForState(context.Background(), service.StateRunning)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, l.Generation())
+	assert.Equal(t, service.StateRunning, poolLifecycle.State(), "a restarted service stopped the pool")
+
+	poolLifecycle.Stop(context.Background())
+	assert.NoError(t, <-result)
+	assert.Equal(t, service.StateStopped, l.State())
+}
+
+func TestPoolRestartOnFailureCleanExit(t *testing.T) {
+	pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+	exit := make(chan struct{})
+	_, err := pool.AddWithOptions(
+		newCallbackService("Exiting service", func(lifecycle service.Lifecycle) error {
+			lifecycle.Running()
+			select {
+			case <-exit:
+			case <-lifecycle.Context().Done():
+			}
+			lifecycle.Stopping()
+			return nil
+		}),
+		service.ServiceOptions{Restart: service.RestartOnFailure},
+	)
+	assert.NoError(t, err)
+	_, err = pool.AddWithOptions(newTestService("Other service"), service.ServiceOptions{})
+	assert.NoError(t, err)
+
+	poolLifecycle, result := startPool(t, pool)
+	close(exit)
+	assert.NoError(t, <-result)
+	assert.Equal(t, service.StateStopped, poolLifecycle.State())
+}
@@ -1,5 +1,9 @@
 package service
 
+import (
+	"context"
+)
+
 // Service is an interface that specifies the minimum requirements for a generic service.
 type Service interface {
 	// String should return a user-readable name for the service.
@@ -19,3 +23,18 @@ type Service interface {
 	// - When the shutdown context expires the service must abort graceful shutdown and stop as soon as possible.
 	RunWithLifecycle(lifecycle Lifecycle) error
 }
+
+// Pauser is implemented by services that can temporarily stop serving new requests without shutting down, for example
+// by no longer accepting connections. Paused services remain in the "running" state.
+type Pauser interface {
+	// Pause stops serving new requests until Resume is called.
+	Pause(ctx context.Context) error
+	// Resume continues serving requests after Pause.
+	Resume(ctx context.Context) error
+}
+
+// Reloader is implemented by services that can reload their configuration without restarting.
+type Reloader interface {
+	// Reload reloads the configuration of the service.
+	Reload(ctx context.Context) error
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/containerssh/log"
+)
+
+// ServiceFactory creates a service from its definition in a pool definition. Settings specific to the service type
+// can be read with definition.DecodeConfig().
+type ServiceFactory func(definition ServiceDefinition, logger log.Logger) (Service, error)
+
+// ServiceRegistry holds the service factories that pool definitions can refer to by their type.
+type ServiceRegistry interface {
+	// Register adds a factory for the specified service type. It panics if the type is already registered.
+	Register(serviceType string, factory ServiceFactory)
+
+	// Factory returns the factory for the specified service type, or false if the type is not registered.
+	Factory(serviceType string) (ServiceFactory, bool)
+
+	// Types returns the registered service types in alphabetical order.
+	Types() []string
+}
+
+// NewServiceRegistry creates a service registry with the built-in "exec" type, which creates an exec service from an
+// ExecConfig. The name of the service defaults to its ID.
+func NewServiceRegistry() ServiceRegistry {
+	registry := &serviceRegistry{
+		mutex:     &sync.Mutex{},
+		factories: map[string]ServiceFactory{},
+	}
+	registry.Register("exec", newExecServiceFromDefinition)
+	return registry
+}
+
+type serviceRegistry struct {
+	mutex     *sync.Mutex
+	factories map[string]ServiceFactory
+}
+
+func (r *serviceRegistry) Register(serviceType string, factory ServiceFactory) {
+	if serviceType == "" || factory == nil {
+		panic("bug: empty service type or nil factory passed to Register")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.factories[serviceType]; ok {
+		panic("bug: service type " + serviceType + " is already registered")
+	}
+	r.factories[serviceType] = factory
+}
+
+func (r *serviceRegistry) Factory(serviceType string) (ServiceFactory, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	factory, ok := r.factories[serviceType]
+	return factory, ok
+}
+
+func (r *serviceRegistry) Types() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	types := make([]string, 0, len(r.factories))
+	for serviceType := range r.factories {
+		types = append(types, serviceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// newExecServiceFromDefinition is the factory of the built-in "exec" service type.
+func newExecServiceFromDefinition(definition ServiceDefinition, logger log.Logger) (Service, error) {
+	config := ExecConfig{}
+	if err := definition.DecodeConfig(&config); err != nil {
+		return nil, err
+	}
+	if config.Name == "" {
+		config.Name = definition.ID
+	}
+	return NewExecService(config, logger)
+}
@@ -48,3 +48,25 @@ func newTestService(name string) *testService {
 		crash: make(chan bool, 1),
 	}
 }
+
+// callbackService is a service that delegates RunWithLifecycle to a function, allowing tests to simulate misbehaving
+// services.
+type callbackService struct {
+	name string
+	run  func(lifecycle service.Lifecycle) error
+}
+
+func (c *callbackService) String() string {
+	return c.name
+}
+
+func (c *callbackService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	return c.run(lifecycle)
+}
+
+func newCallbackService(name string, run func(lifecycle service.Lifecycle) error) *callbackService {
+	return &callbackService{
+		name: name,
+		run:  run,
+	}
+}
@@ -0,0 +1,189 @@
+package servicetest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerssh/service"
+)
+
+// FakeClock is a service.Clock implementation for tests. Time only moves when Advance is called, which fires all
+// timers and tickers that have become due in deadline order.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{}
+}
+
+// NewFakeClock creates a fake clock set to the specified time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the current fake time.
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// NewTimer creates a timer that fires once the clock has been advanced by the specified duration.
+func (f *FakeClock) NewTimer(d time.Duration) service.Timer {
+	w := &fakeWaiter{
+		clock: f,
+		c:     make(chan time.Time, 1),
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.schedule(w, d)
+	return &fakeTimer{w}
+}
+
+// NewTicker creates a ticker that fires every time the clock has been advanced by the specified interval.
+func (f *FakeClock) NewTicker(d time.Duration) service.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{
+		clock:    f,
+		c:        make(chan time.Time, 1),
+		interval: d,
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.schedule(w, d)
+	return &fakeTicker{w}
+}
+
+// Advance moves the clock forward by the specified duration and fires all timers and tickers that become due.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	target := f.now.Add(d)
+	for {
+		sort.Slice(f.waiters, func(i, j int) bool {
+			return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].deadline.After(target) {
+			break
+		}
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		w.active = false
+		f.now = w.deadline
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.interval > 0 {
+			f.schedule(w, w.interval)
+		}
+	}
+	f.now = target
+}
+
+// Waiters returns the number of timers and tickers that are currently waiting to fire.
+func (f *FakeClock) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+// WaitForWaiters blocks until at least n timers or tickers are waiting to fire, or the timeout expires. Tests use it
+// to make sure the code under test has armed its timers before calling Advance. It returns false on timeout.
+func (f *FakeClock) WaitForWaiters(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		f.lock.Lock()
+		count := len(f.waiters)
+		changed := f.changed
+		f.lock.Unlock()
+		if count >= n {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// schedule adds the waiter to the list of pending waiters. It must be called with the lock held.
+func (f *FakeClock) schedule(w *fakeWaiter, d time.Duration) {
+	w.deadline = f.now.Add(d)
+	w.active = true
+	f.waiters = append(f.waiters, w)
+	f.notify()
+}
+
+// unschedule removes the waiter from the list of pending waiters. It must be called with the lock held.
+func (f *FakeClock) unschedule(w *fakeWaiter) bool {
+	if !w.active {
+		return false
+	}
+	for i, waiter := range f.waiters {
+		if waiter == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	w.active = false
+	f.notify()
+	return true
+}
+
+// notify wakes up everyone waiting in WaitForWaiters. It must be called with the lock held.
+func (f *FakeClock) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeWaiter struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	interval time.Duration
+	active   bool
+}
+
+type fakeTimer struct {
+	w *fakeWaiter
+}
+
+func (f *fakeTimer) C() <-chan time.Time {
+	return f.w.c
+}
+
+func (f *fakeTimer) Stop() bool {
+	f.w.clock.lock.Lock()
+	defer f.w.clock.lock.Unlock()
+	return f.w.clock.unschedule(f.w)
+}
+
+func (f *fakeTimer) Reset(d time.Duration) bool {
+	f.w.clock.lock.Lock()
+	defer f.w.clock.lock.Unlock()
+	wasActive := f.w.clock.unschedule(f.w)
+	f.w.clock.schedule(f.w, d)
+	return wasActive
+}
+
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (f *fakeTicker) C() <-chan time.Time {
+	return f.w.c
+}
+
+func (f *fakeTicker) Stop() {
+	f.w.clock.lock.Lock()
+	defer f.w.clock.lock.Unlock()
+	f.w.clock.unschedule(f.w)
+}
@@ -0,0 +1,65 @@
+package servicetest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service/servicetest"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := servicetest.NewFakeClock(start)
+	timer := clock.NewTimer(10 * time.Second)
+	assert.Equal(t, 1, clock.Waiters())
+
+	clock.Advance(9 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatalf("timer fired too early")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case fired := <-timer.C():
+		assert.Equal(t, start.Add(10*time.Second), fired)
+	default:
+		t.Fatalf("timer did not fire")
+	}
+	assert.False(t, timer.Stop())
+	assert.Equal(t, 0, clock.Waiters())
+
+	assert.False(t, timer.Reset(time.Second))
+	assert.True(t, timer.Stop())
+	clock.Advance(time.Minute)
+	select {
+	case <-timer.C():
+		t.Fatalf("stopped timer fired")
+	default:
+	}
+	assert.Equal(t, start.Add(70*time.Second), clock.Now())
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		select {
+		case <-ticker.C():
+		default:
+			t.Fatalf("ticker did not fire on tick %d", i)
+		}
+	}
+}
+
+func TestFakeClockWaitForWaiters(t *testing.T) {
+	clock := servicetest.NewFakeClock(time.Now())
+	assert.False(t, clock.WaitForWaiters(1, 10*time.Millisecond))
+	go clock.NewTimer(time.Second)
+	assert.True(t, clock.WaitForWaiters(1, 10*time.Second))
+}
@@ -0,0 +1,3 @@
+// Package servicetest contains reusable test suites and helpers to verify that custom Service and Lifecycle
+// implementations conform to the contracts described in the service package.
+package servicetest
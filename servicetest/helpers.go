@@ -0,0 +1,104 @@
+package servicetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/service"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// waitFor waits for the channel to be closed or to receive a value. It fails the test if the timeout expires first.
+func waitFor(t *testing.T, c <-chan struct{}, timeout time.Duration, what string) bool {
+	t.Helper()
+	select {
+	case <-c:
+		return true
+	case <-time.After(timeout):
+		t.Errorf("timeout while waiting for %s", what)
+		return false
+	}
+}
+
+// runAsync runs the lifecycle in a goroutine and returns a channel that receives the result of Run.
+func runAsync(l service.Lifecycle) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Run()
+	}()
+	return result
+}
+
+// waitForResult waits for the result of an asynchronous Run and fails the test if it doesn't arrive in time.
+func waitForResult(t *testing.T, result <-chan error, timeout time.Duration) (error, bool) {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err, true
+	case <-time.After(timeout):
+		t.Errorf("timeout while waiting for the service to exit")
+		return nil, false
+	}
+}
+
+// recorder records the events observed through the lifecycle hooks in a thread-safe manner.
+type recorder struct {
+	lock    sync.Mutex
+	events  []string
+	states  []service.State
+	running chan struct{}
+	once    sync.Once
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		running: make(chan struct{}),
+	}
+}
+
+func (r *recorder) attach(l service.Lifecycle) {
+	l.OnStateChange(func(s service.Service, l service.Lifecycle, state service.State) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.states = append(r.states, state)
+	})
+	l.OnStarting(func(s service.Service, l service.Lifecycle) {
+		r.record("starting")
+	})
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		r.record("running")
+		r.once.Do(func() {
+			close(r.running)
+		})
+	})
+	l.OnStopping(func(s service.Service, l service.Lifecycle, shutdownContext context.Context) {
+		r.record("stopping")
+	})
+	l.OnStopped(func(s service.Service, l service.Lifecycle) {
+		r.record("stopped")
+	})
+	l.OnCrashed(func(s service.Service, l service.Lifecycle, err error) {
+		r.record("crashed")
+	})
+}
+
+func (r *recorder) record(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) getEvents() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func (r *recorder) getStates() []service.State {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]service.State{}, r.states...)
+}
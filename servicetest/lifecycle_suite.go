@@ -0,0 +1,466 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+// LifecycleSuite is a conformance test suite for Lifecycle implementations. It runs a well-behaved reference service
+// through the lifecycle under test and verifies the hook ordering and the Wait() semantics.
+//
+// Usage:
+//
+//	func TestMyLifecycle(t *testing.T) {
+//	    servicetest.LifecycleSuite{
+//	        Factory: func(s service.Service) service.Lifecycle {
+//	            return newMyLifecycle(s)
+//	        },
+//	    }.Run(t)
+//	}
+type LifecycleSuite struct {
+	// Factory creates a new lifecycle for the specified service. It is called once per scenario.
+	Factory func(s service.Service) service.Lifecycle
+
+	// Timeout is the maximum time any single step of a scenario may take. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Run runs all scenarios of the suite as subtests of the specified test.
+func (s LifecycleSuite) Run(t *testing.T) {
+	if s.Factory == nil {
+		t.Fatalf("no lifecycle factory provided")
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaultTimeout
+	}
+	t.Run("HookOrder", s.testHookOrder)
+	t.Run("Wait", s.testWait)
+	t.Run("HookPriority", s.testHookPriority)
+	t.Run("Crash", s.testCrash)
+	t.Run("ShutdownContext", s.testShutdownContext)
+	t.Run("StopWithoutRun", s.testStopWithoutRun)
+	t.Run("WaitForState", s.testWaitForState)
+	t.Run("Drain", s.testDrain)
+	t.Run("Tasks", s.testTasks)
+	t.Run("FailingTask", s.testFailingTask)
+	t.Run("Defer", s.testDefer)
+}
+
+func (s LifecycleSuite) start(t *testing.T) (*referenceService, service.Lifecycle, *recorder, <-chan error, bool) {
+	t.Helper()
+	svc := newReferenceService()
+	l := s.Factory(svc)
+	r := newRecorder()
+	r.attach(l)
+	result := runAsync(l)
+	if !waitFor(t, r.running, s.Timeout, "the running hook") {
+		return svc, l, r, result, false
+	}
+	return svc, l, r, result, true
+}
+
+func (s LifecycleSuite) testHookOrder(t *testing.T) {
+	_, l, r, result, ok := s.start(t)
+	if !ok {
+		return
+	}
+	assert.Equal(t, service.StateRunning, l.State())
+	assert.False(t, l.ShouldStop())
+	l.Stop(context.Background())
+	assert.True(t, l.ShouldStop(), "ShouldStop() returned false after Stop()")
+	assert.Error(t, l.Context().Err(), "the context was not canceled after Stop()")
+	if err, ok := waitForResult(t, result, s.Timeout); ok {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, service.StateStopped, l.State())
+	assert.Equal(t, []string{"starting", "running", "stopping", "stopped"}, r.getEvents())
+	assert.Equal(
+		t,
+		[]service.State{service.StateStarting, service.StateRunning, service.StateStopping, service.StateStopped},
+		r.getStates(),
+	)
+}
+
+func (s LifecycleSuite) testWait(t *testing.T) {
+	_, l, _, result, ok := s.start(t)
+	if !ok {
+		return
+	}
+	waitResult := make(chan error, 1)
+	go func() {
+		waitResult <- l.Wait()
+	}()
+	select {
+	case <-waitResult:
+		t.Errorf("Wait() returned while the service was still running")
+		return
+	case <-time.After(10 * time.Millisecond):
+	}
+	l.Stop(context.Background())
+	select {
+	case err := <-waitResult:
+		assert.NoError(t, err)
+	case <-time.After(s.Timeout):
+		t.Errorf("Wait() did not return after the service stopped")
+		return
+	}
+	_, _ = waitForResult(t, result, s.Timeout)
+	assert.NoError(t, l.Wait(), "Wait() on a stopped service returned an error")
+	assert.NoError(t, l.Error())
+}
+
+func (s LifecycleSuite) testHookPriority(t *testing.T) {
+	svc := newReferenceService()
+	l := s.Factory(svc)
+	r := newRecorder()
+	for _, priority := range []int{10, -10, 0} {
+		name := fmt.Sprintf("stopping %d", priority)
+		l.AddHook(service.Hook{
+			Priority: priority,
+			OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+				r.record(name)
+				return nil
+			},
+		})
+	}
+	l.AddHook(service.Hook{
+		Priority: -10,
+		Mode:     service.HookModeSequential,
+		OnStopping: func(s service.Service, l service.Lifecycle, shutdownContext context.Context) error {
+			r.record("stopping -10 sequential")
+			return nil
+		},
+	})
+	running := make(chan struct{})
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		close(running)
+	})
+	result := runAsync(l)
+	if !waitFor(t, running, s.Timeout, "the running hook") {
+		return
+	}
+	l.Stop(context.Background())
+	_, _ = waitForResult(t, result, s.Timeout)
+	assert.Equal(
+		t,
+		[]string{"stopping -10", "stopping -10 sequential", "stopping 0", "stopping 10"},
+		r.getEvents(),
+		"the stopping hooks were not executed in the order of their priority",
+	)
+}
+
+func (s LifecycleSuite) testCrash(t *testing.T) {
+	svc, l, r, result, ok := s.start(t)
+	if !ok {
+		return
+	}
+	svc.crash <- errCrash
+	err, ok := waitForResult(t, result, s.Timeout)
+	if !ok {
+		return
+	}
+	assert.True(t, errors.Is(err, errCrash), "Run() did not return the crash error")
+	assert.True(t, errors.Is(l.Wait(), errCrash), "Wait() did not return the crash error")
+	assert.True(t, errors.Is(l.Wait(), errCrash), "a second Wait() did not return the crash error")
+	assert.True(t, errors.Is(l.Error(), errCrash), "Error() did not return the crash error")
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.Equal(t, []string{"starting", "running", "crashed"}, r.getEvents())
+	l.Stop(context.Background())
+}
+
+type contextKey string
+
+func (s LifecycleSuite) testShutdownContext(t *testing.T) {
+	svc := newReferenceService()
+	l := s.Factory(svc)
+	running := make(chan struct{})
+	received := make(chan context.Context, 1)
+	l.OnRunning(func(s service.Service, l service.Lifecycle) {
+		close(running)
+	})
+	l.OnStopping(func(s service.Service, l service.Lifecycle, shutdownContext context.Context) {
+		received <- shutdownContext
+	})
+	result := runAsync(l)
+	if !waitFor(t, running, s.Timeout, "the running hook") {
+		return
+	}
+	shutdownContext := context.WithValue(context.Background(), contextKey("test"), "shutdown")
+	l.Stop(shutdownContext)
+	_, _ = waitForResult(t, result, s.Timeout)
+	select {
+	case ctx := <-received:
+		assert.Equal(t, "shutdown", ctx.Value(contextKey("test")), "the stopping hook received the wrong context")
+	default:
+		t.Errorf("the stopping hook was not called")
+	}
+	assert.Equal(t, "shutdown", svc.shutdownContext.Value(contextKey("test")), "Stopping() returned the wrong context")
+}
+
+func (s LifecycleSuite) testStopWithoutRun(t *testing.T) {
+	l := s.Factory(newReferenceService())
+	done := make(chan struct{})
+	go func() {
+		l.Stop(context.Background())
+		close(done)
+	}()
+	waitFor(t, done, s.Timeout, "Stop() on a lifecycle that was never run")
+	assert.Equal(t, service.StateStopped, l.State())
+	assert.NoError(t, l.Wait())
+}
+
+func (s LifecycleSuite) testWaitForState(t *testing.T) {
+	l := s.Factory(newReferenceService())
+	ready := l.Ready()
+	done := l.Done()
+	select {
+	case <-ready:
+		t.Errorf("the Ready() channel was closed before the service was started")
+	case <-done:
+		t.Errorf("the Done() channel was closed before the service was started")
+	default:
+	}
+	waitResult := make(chan service.State, 1)
+	go func() {
+		state, err := l.WaitForState(context.Background(), service.StateRunning, service.StateCrashed)
+		assert.NoError(t, err)
+		waitResult <- state
+	}()
+
+	result := runAsync(l)
+	if !waitFor(t, ready, s.Timeout, "the Ready() channel to be closed") {
+		return
+	}
+	select {
+	case state := <-waitResult:
+		assert.Equal(t, service.StateRunning, state)
+	case <-time.After(s.Timeout):
+		t.Errorf("WaitForState() did not return after the service entered the running state")
+	}
+	state, err := l.WaitForState(context.Background(), service.StateRunning)
+	assert.NoError(t, err, "WaitForState() did not return immediately for the current state")
+	assert.Equal(t, service.StateRunning, state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.WaitForState(ctx, service.StateStopped)
+	assert.Error(t, err, "WaitForState() did not return an error after the context ended")
+
+	go l.Stop(context.Background())
+	if !waitFor(t, done, s.Timeout, "the Done() channel to be closed") {
+		return
+	}
+	_, _ = waitForResult(t, result, s.Timeout)
+	assert.Equal(t, service.StateStopped, l.State())
+}
+
+func (s LifecycleSuite) testDrain(t *testing.T) {
+	drainResult := make(chan error, 1)
+	svc := &drainingService{drainResult: drainResult}
+	l := s.Factory(svc)
+	finished := l.Track(nil)
+	canceledReason := make(chan error, 1)
+	unfinished := l.Track(func(reason error) {
+		canceledReason <- reason
+	})
+	assert.Equal(t, 2, l.ActiveWork())
+	finished.Finish()
+	assert.Equal(t, 1, l.ActiveWork())
+
+	result := runAsync(l)
+	if !waitFor(t, l.Ready(), s.Timeout, "the Ready() channel to be closed") {
+		return
+	}
+	shutdownContext, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	l.Stop(shutdownContext)
+	_, _ = waitForResult(t, result, s.Timeout)
+
+	select {
+	case err := <-drainResult:
+		assert.True(t, errors.Is(err, service.ErrDrainDeadline), "Drain() did not report the canceled work")
+	default:
+		t.Errorf("the service did not drain")
+	}
+	select {
+	case reason := <-canceledReason:
+		assert.Equal(t, service.ErrDrainDeadline, reason)
+	default:
+		t.Errorf("the unfinished work unit was not canceled")
+	}
+	assert.Equal(t, service.ErrDrainDeadline, unfinished.Reason())
+	assert.Error(t, unfinished.Context().Err())
+	assert.NoError(t, finished.Reason())
+	unfinished.Finish()
+	assert.Equal(t, 0, l.ActiveWork())
+}
+
+func (s LifecycleSuite) testTasks(t *testing.T) {
+	release := make(chan struct{})
+	taskExited := make(chan struct{})
+	svc := &taskService{
+		task: func(ctx context.Context) error {
+			<-ctx.Done()
+			<-release
+			close(taskExited)
+			return ctx.Err()
+		},
+	}
+	l := s.Factory(svc)
+	result := runAsync(l)
+	if !waitFor(t, l.Ready(), s.Timeout, "the Ready() channel to be closed") {
+		return
+	}
+	go l.Stop(context.Background())
+	select {
+	case <-result:
+		t.Fatalf("the lifecycle stopped before its task exited")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.NotEqual(t, service.StateStopped, l.State())
+	close(release)
+	err, ok := waitForResult(t, result, s.Timeout)
+	if !ok {
+		return
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, service.StateStopped, l.State())
+	select {
+	case <-taskExited:
+	default:
+		t.Errorf("the lifecycle stopped before its task exited")
+	}
+}
+
+func (s LifecycleSuite) testFailingTask(t *testing.T) {
+	svc := &taskService{
+		task: func(ctx context.Context) error {
+			return errCrash
+		},
+	}
+	l := s.Factory(svc)
+	err, ok := waitForResult(t, runAsync(l), s.Timeout)
+	if !ok {
+		return
+	}
+	assert.True(t, errors.Is(err, errCrash), "the failed task did not crash the service")
+	var taskError *service.TaskError
+	if assert.True(t, errors.As(err, &taskError), "the error is not a *service.TaskError") {
+		assert.Equal(t, "reference task", taskError.Task)
+	}
+	assert.Equal(t, service.StateCrashed, l.State())
+}
+
+func (s LifecycleSuite) testDefer(t *testing.T) {
+	var order []string
+	svc := newCallbackService(func(lifecycle service.Lifecycle) error {
+		for _, name := range []string{"first", "second"} {
+			name := name
+			lifecycle.Defer(name, func(ctx context.Context) error {
+				order = append(order, name)
+				if name == "first" {
+					return errCrash
+				}
+				return nil
+			})
+		}
+		lifecycle.Running()
+		return errCrash
+	})
+	l := s.Factory(svc)
+	if _, ok := waitForResult(t, runAsync(l), s.Timeout); !ok {
+		return
+	}
+	assert.Equal(t, []string{"second", "first"}, order, "the cleanups did not run in reverse order")
+	var cleanupError *service.CleanupError
+	if assert.True(t, errors.As(l.Error(), &cleanupError), "the failed cleanup is not reported by Error()") {
+		assert.Equal(t, "first", cleanupError.Cleanup)
+	}
+}
+
+var errCrash = errors.New("reference service crashed")
+
+// referenceService is a service that follows the RunWithLifecycle contract to the letter.
+type referenceService struct {
+	crash           chan error
+	shutdownContext context.Context
+}
+
+func newReferenceService() *referenceService {
+	return &referenceService{
+		crash: make(chan error, 1),
+	}
+}
+
+func (r *referenceService) String() string {
+	return "Reference service"
+}
+
+func (r *referenceService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Running()
+	select {
+	case <-lifecycle.Context().Done():
+		r.shutdownContext = lifecycle.Stopping()
+		return nil
+	case err := <-r.crash:
+		return err
+	}
+}
+
+// drainingService is a reference service that drains its tracked work after calling Stopping().
+type drainingService struct {
+	drainResult chan<- error
+}
+
+func (d *drainingService) String() string {
+	return "Draining service"
+}
+
+func (d *drainingService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Running()
+	<-lifecycle.Context().Done()
+	lifecycle.Stopping()
+	d.drainResult <- lifecycle.Drain()
+	return nil
+}
+
+// taskService is a reference service that runs a single task with Lifecycle.Go() while it is running.
+type taskService struct {
+	task func(ctx context.Context) error
+}
+
+func (t *taskService) String() string {
+	return "Task service"
+}
+
+func (t *taskService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Go("reference task", t.task)
+	lifecycle.Running()
+	<-lifecycle.Context().Done()
+	lifecycle.Stopping()
+	return nil
+}
+
+// callbackService is a reference service that delegates to a function.
+type callbackService struct {
+	run func(lifecycle service.Lifecycle) error
+}
+
+func newCallbackService(run func(lifecycle service.Lifecycle) error) *callbackService {
+	return &callbackService{run: run}
+}
+
+func (c *callbackService) String() string {
+	return "Callback service"
+}
+
+func (c *callbackService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	return c.run(lifecycle)
+}
@@ -0,0 +1,145 @@
+package servicetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+// ServiceSuite is a conformance test suite for Service implementations. It runs the service through the default
+// lifecycle in a number of scenarios and verifies that it honors the RunWithLifecycle contract.
+//
+// Usage:
+//
+//	func TestMyService(t *testing.T) {
+//	    servicetest.ServiceSuite{
+//	        Factory: func() service.Service {
+//	            return newMyService()
+//	        },
+//	    }.Run(t)
+//	}
+type ServiceSuite struct {
+	// Factory creates a new, unstarted instance of the service under test. It is called once per scenario.
+	Factory func() service.Service
+
+	// Crash makes a running service exit with an error. If nil, the crash scenario is skipped.
+	Crash func(s service.Service)
+
+	// StartupTimeout is the time the service has to call Running() after it has been started. Defaults to 10
+	// seconds.
+	StartupTimeout time.Duration
+
+	// ShutdownTimeout is the time the service has to exit after a stop has been requested. Defaults to 10 seconds.
+	ShutdownTimeout time.Duration
+}
+
+// Run runs all scenarios of the suite as subtests of the specified test.
+func (s ServiceSuite) Run(t *testing.T) {
+	if s.Factory == nil {
+		t.Fatalf("no service factory provided")
+	}
+	if s.StartupTimeout == 0 {
+		s.StartupTimeout = defaultTimeout
+	}
+	if s.ShutdownTimeout == 0 {
+		s.ShutdownTimeout = defaultTimeout
+	}
+	t.Run("StartStop", s.testStartStop)
+	t.Run("StopDuringStartup", s.testStopDuringStartup)
+	t.Run("ShutdownDeadline", s.testShutdownDeadline)
+	t.Run("Crash", s.testCrash)
+}
+
+func (s ServiceSuite) start(t *testing.T) (service.Service, service.Lifecycle, *recorder, <-chan error, bool) {
+	t.Helper()
+	svc := s.Factory()
+	l := service.NewLifecycle(svc)
+	r := newRecorder()
+	r.attach(l)
+	result := runAsync(l)
+	if !waitFor(t, r.running, s.StartupTimeout, "the service to call Running()") {
+		l.Stop(context.Background())
+		return svc, l, r, result, false
+	}
+	return svc, l, r, result, true
+}
+
+func (s ServiceSuite) testStartStop(t *testing.T) {
+	_, l, r, result, ok := s.start(t)
+	if !ok {
+		return
+	}
+	assert.Equal(t, service.StateRunning, l.State())
+	go l.Stop(context.Background())
+	err, ok := waitForResult(t, result, s.ShutdownTimeout)
+	if !ok {
+		return
+	}
+	assert.NoError(t, err, "the service returned an error after a graceful stop")
+	assert.Equal(
+		t,
+		[]service.State{service.StateStarting, service.StateRunning, service.StateStopping, service.StateStopped},
+		r.getStates(),
+		"the service did not go through the expected states, did it call Running() and Stopping()?",
+	)
+}
+
+func (s ServiceSuite) testStopDuringStartup(t *testing.T) {
+	svc := s.Factory()
+	l := service.NewLifecycle(svc)
+	r := newRecorder()
+	r.attach(l)
+	starting := make(chan struct{})
+	l.OnStarting(func(s service.Service, l service.Lifecycle) {
+		close(starting)
+	})
+	result := runAsync(l)
+	if !waitFor(t, starting, s.StartupTimeout, "the service to start") {
+		return
+	}
+	go l.Stop(context.Background())
+	err, ok := waitForResult(t, result, s.StartupTimeout+s.ShutdownTimeout)
+	if !ok {
+		return
+	}
+	assert.NoError(t, err, "the service returned an error when stopped during startup")
+	assert.Equal(t, service.StateStopped, l.State())
+}
+
+func (s ServiceSuite) testShutdownDeadline(t *testing.T) {
+	_, l, _, result, ok := s.start(t)
+	if !ok {
+		return
+	}
+	shutdownContext, cancel := context.WithCancel(context.Background())
+	cancel()
+	go l.Stop(shutdownContext)
+	if _, ok := waitForResult(t, result, s.ShutdownTimeout); !ok {
+		t.Errorf("the service did not exit after the shutdown context expired")
+		return
+	}
+	assert.Contains(t, []service.State{service.StateStopped, service.StateCrashed}, l.State())
+}
+
+func (s ServiceSuite) testCrash(t *testing.T) {
+	if s.Crash == nil {
+		t.Skipf("no crash function provided")
+	}
+	svc, l, r, result, ok := s.start(t)
+	if !ok {
+		return
+	}
+	s.Crash(svc)
+	err, ok := waitForResult(t, result, s.ShutdownTimeout)
+	if !ok {
+		return
+	}
+	assert.Error(t, err, "the service did not return an error after a crash")
+	assert.Equal(t, service.StateCrashed, l.State())
+	assert.Equal(t, err, l.Error())
+	assert.Equal(t, service.StateCrashed, r.getStates()[len(r.getStates())-1])
+}
@@ -0,0 +1,62 @@
+package servicetest_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containerssh/log"
+
+	"github.com/containerssh/service"
+	"github.com/containerssh/service/servicetest"
+)
+
+func TestDefaultLifecycle(t *testing.T) {
+	servicetest.LifecycleSuite{
+		Factory: service.NewLifecycle,
+	}.Run(t)
+}
+
+func TestPoolService(t *testing.T) {
+	servicetest.ServiceSuite{
+		Factory: func() service.Service {
+			pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+			child := newCrashableService()
+			pool.Add(child)
+			return &crashablePool{Pool: pool, child: child}
+		},
+		Crash: func(s service.Service) {
+			// The pool crashes if one of its children crashes.
+			s.(*crashablePool).child.crash <- errors.New("crash")
+		},
+	}.Run(t)
+}
+
+type crashablePool struct {
+	service.Pool
+	child *crashableService
+}
+
+type crashableService struct {
+	crash chan error
+}
+
+func newCrashableService() *crashableService {
+	return &crashableService{
+		crash: make(chan error, 1),
+	}
+}
+
+func (c *crashableService) String() string {
+	return "Crashable service"
+}
+
+func (c *crashableService) RunWithLifecycle(lifecycle service.Lifecycle) error {
+	lifecycle.Running()
+	select {
+	case <-lifecycle.Context().Done():
+		lifecycle.Stopping()
+		return nil
+	case err := <-c.crash:
+		return err
+	}
+}
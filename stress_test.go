@@ -0,0 +1,156 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/service"
+)
+
+// TestLifecycleStress exercises the lifecycle from many goroutines at once. It is mainly useful with the race
+// detector enabled.
+func TestLifecycleStress(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		l := service.NewLifecycle(newTestService("Test service"))
+		events := l.Subscribe(context.Background())
+		go func() {
+			for range events {
+			}
+		}()
+		result := make(chan error, 1)
+		go func() {
+			result <- l.Run()
+		}()
+
+		readers := &sync.WaitGroup{}
+		done := make(chan struct{})
+		for j := 0; j < 4; j++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					_ = l.State()
+					_ = l.Error()
+					_ = l.Context()
+					_ = l.ShutdownContext()
+					_ = l.ShouldStop()
+					_ = l.Generation()
+					_ = l.Ready()
+					_ = l.Done()
+					runtime.Gosched()
+				}
+			}()
+		}
+		waiters := &sync.WaitGroup{}
+		for j := 0; j < 4; j++ {
+			waiters.Add(1)
+			go func() {
+				defer waiters.Done()
+				_ = l.Wait()
+				_, _ = l.WaitForState(context.Background(), service.StateStopped, service.StateCrashed)
+			}()
+		}
+
+		// Stop has no effect before Run has started the service, so keep stopping until it exits.
+		stopUntilExit(t, l, result)
+		waiters.Wait()
+		close(done)
+		readers.Wait()
+		assert.Contains(t, []service.State{service.StateStopped, service.StateCrashed}, l.State())
+	}
+}
+
+// TestLifecycleRunStopCycles runs and stops the same lifecycle repeatedly while other goroutines observe it.
+func TestLifecycleRunStopCycles(t *testing.T) {
+	l := service.NewLifecycle(newTestService("Test service"))
+	done := make(chan struct{})
+	readers := &sync.WaitGroup{}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = l.State()
+			_ = l.Error()
+			_ = l.ShouldStop()
+			_, _ = l.WaitForState(context.Background(), service.StateRunning, service.StateStopped)
+		}
+	}()
+	for i := 1; i <= 50; i++ {
+		result := make(chan error, 1)
+		go func() {
+			result <- l.Run()
+		}()
+		select {
+		case <-l.Ready():
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout while waiting for run %d to start", i)
+		}
+		go l.Stop(context.Background())
+		stopUntilExit(t, l, result)
+		assert.Equal(t, i, l.Generation())
+	}
+	close(done)
+	readers.Wait()
+}
+
+// TestPoolStress runs pools in which services crash during startup while the pool is being stopped.
+func TestPoolStress(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		pool := service.NewPool(service.NewLifecycleFactory(), log.NewTestLogger(t))
+		for j := 0; j < 10; j++ {
+			s := newTestService(fmt.Sprintf("Test service %d", j))
+			if j == i%10 {
+				s.CrashStartup()
+			}
+			pool.Add(s)
+		}
+		l := service.NewLifecycle(pool)
+		result := make(chan error, 1)
+		go func() {
+			result <- l.Run()
+		}()
+		go l.Stop(context.Background())
+		select {
+		case err := <-result:
+			// The pool doesn't launch the remaining services once it is stopping, so the crashing service may
+			// not have run at all.
+			if err != nil {
+				assert.Equal(t, "crash", err.Error())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("the pool did not stop")
+		}
+	}
+}
+
+func stopUntilExit(t *testing.T, l service.Lifecycle, result <-chan error) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		l.Stop(context.Background())
+		select {
+		case <-result:
+			return
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Fatalf("the lifecycle did not exit")
+		}
+	}
+}